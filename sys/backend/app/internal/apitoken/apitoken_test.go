@@ -0,0 +1,54 @@
+package apitoken
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chatshare/backend/internal/database"
+)
+
+func TestGenerate(t *testing.T) {
+	token, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAPIToken(token) || !strings.HasPrefix(token, Prefix) {
+		t.Errorf("token %q is not marked as an API token", token)
+	}
+	if hash != Hash(token) || strings.Contains(hash, token) {
+		t.Error("the stored hash does not match the token")
+	}
+
+	other, _, _ := Generate()
+	if other == token {
+		t.Error("Generate returned the same token twice")
+	}
+}
+
+func TestIsAPIToken(t *testing.T) {
+	if IsAPIToken("eyJhbGciOiJIUzI1NiJ9.e30.sig") {
+		t.Error("a JWT was taken for an API token")
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	token := &database.APIToken{Scopes: "read comments:write"}
+
+	if !HasScope(token, ScopeRead) || !HasScope(token, ScopeCommentsWrite) {
+		t.Error("granted scope not found")
+	}
+	if HasScope(token, ScopeChatsWrite) || HasScope(token, "comments") || HasScope(token, "") {
+		t.Error("scope that was not granted was found")
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	for _, scope := range Scopes {
+		if !ValidScope(scope) {
+			t.Errorf("ValidScope(%q) = false", scope)
+		}
+	}
+	if ValidScope("admin") || ValidScope("") {
+		t.Error("unknown scope accepted")
+	}
+}
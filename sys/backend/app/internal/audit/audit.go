@@ -0,0 +1,167 @@
+// Package audit records administrative actions in the append-only audit
+// log. Entries are written with the transaction that makes the change, so
+// a change is never saved without its entry.
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/chatshare/backend/internal/database"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Actions
+const (
+	ActionUserStatus     = "user.status"
+	ActionUserRole       = "user.role"
+	ActionUserMerge      = "user.merge" // target_id merged into target_user_id
+	ActionChatStatus     = "chat.status"
+	ActionChatDelete     = "chat.delete"
+	ActionChatLinkUpdate = "chat.link_update"
+	ActionCommentStatus  = "comment.status"
+	ActionCommentDelete  = "comment.delete"
+	ActionCategoryCreate = "category.create"
+	ActionCategoryUpdate = "category.update"
+	ActionCategoryDelete = "category.delete"
+	ActionReportResolve  = "report.resolve"
+	ActionReportDismiss  = "report.dismiss"
+
+	ActionScreeningRuleCreate = "screening_rule.create"
+	ActionScreeningRuleUpdate = "screening_rule.update"
+	ActionScreeningRuleDelete = "screening_rule.delete"
+)
+
+// ModerationActions are shown to the user whose account or content they
+// affected
+var ModerationActions = []string{
+	ActionUserStatus,
+	ActionChatStatus,
+	ActionChatDelete,
+	ActionCommentStatus,
+	ActionCommentDelete,
+	ActionReportResolve,
+	ActionReportDismiss,
+}
+
+// Target types
+const (
+	TargetUser     = "user"
+	TargetChat     = "chat"
+	TargetComment  = "comment"
+	TargetCategory = "category"
+
+	TargetScreeningRule = "screening_rule"
+)
+
+// Fields left out of before and after, which change with every update
+var ignoredFields = map[string]bool{
+	"updated_at": true,
+}
+
+// Actor is who performed an action, and from where
+type Actor struct {
+	ID uuid.UUID
+	IP string
+}
+
+// Entry describes one action
+type Entry struct {
+	Action       string
+	TargetType   string
+	TargetID     uuid.UUID
+	TargetUserID *uuid.UUID  // the account affected, or the author of the content
+	Before       interface{} // the target before the action; nil when created
+	After        interface{} // the target after the action; nil when deleted
+	Reason       string
+}
+
+// Record writes an entry to the audit log. Pass the transaction making the
+// change, so that the change fails if the entry cannot be written.
+func Record(tx *gorm.DB, actor Actor, entry Entry) error {
+	before, after, err := Diff(entry.Before, entry.After)
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(&database.AuditLog{
+		ActorID:      actor.ID,
+		Action:       entry.Action,
+		TargetType:   entry.TargetType,
+		TargetID:     entry.TargetID,
+		TargetUserID: entry.TargetUserID,
+		Before:       before,
+		After:        after,
+		Reason:       entry.Reason,
+		IPAddress:    actor.IP,
+	}).Error
+}
+
+// Diff returns the fields of before and after, as they are shown in the API,
+// that differ between them. When either is nil the other is returned whole,
+// without nested objects.
+func Diff(before, after interface{}) (database.JSON, database.JSON, error) {
+	beforeFields, err := fields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterFields, err := fields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if beforeFields != nil && afterFields != nil {
+		for key, value := range beforeFields {
+			if other, ok := afterFields[key]; ok && reflect.DeepEqual(value, other) {
+				delete(beforeFields, key)
+				delete(afterFields, key)
+			}
+		}
+	}
+
+	beforeJSON, err := encode(beforeFields)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterJSON, err := encode(afterFields)
+	if err != nil {
+		return nil, nil, err
+	}
+	return beforeJSON, afterJSON, nil
+}
+
+// fields flattens a value to its JSON fields, leaving out relationships
+func fields(v interface{}) (map[string]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	for key, value := range m {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			delete(m, key)
+			continue
+		}
+		if ignoredFields[key] {
+			delete(m, key)
+		}
+	}
+	return m, nil
+}
+
+func encode(m map[string]interface{}) (database.JSON, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -28,7 +32,10 @@ type Config struct {
 
 	// JWT
 	JWTSecret     string
-	JWTExpiration time.Duration
+	JWTExpiration time.Duration // access token lifetime
+
+	// Refresh tokens
+	RefreshTokenExpiration time.Duration
 
 	// OAuth Google
 	GoogleClientID     string
@@ -40,24 +47,91 @@ type Config struct {
 	LINEChannelSecret string
 	LINERedirectURL   string
 
+	// Additional OpenID Connect / OAuth 2.0 login providers
+	OIDCProviders []OIDCProviderConfig
+
 	// Email
 	SendGridAPIKey string
 	FromEmail      string
 	FromName       string
 
+	// Weekly digest emails
+	EmailDigestEnabled  bool
+	EmailDigestInterval time.Duration
+
+	// Signs unsubscribe links; defaults to a key derived from JWTSecret
+	UnsubscribeSecret string
+
+	// Public base URL of this API, used in links that must reach the backend
+	APIBaseURL string
+
 	// Frontend
 	FrontendURL string
 
 	// Firebase
 	FirebaseCredentialsPath string
 
-	// Rate Limiting
+	// Rate Limiting. RateLimitRequests per RateLimitDuration applies to
+	// changes without a policy of their own.
 	RateLimitRequests int
 	RateLimitDuration time.Duration
+	RateLimitRead     RateLimit // GET requests
+	RateLimitAuth     RateLimit // sign-in redirects and callbacks, token refresh and sign-out
+	RateLimitPosts    RateLimit // new chats and uploads
+	RateLimitComments RateLimit // new comments and replies
+	RateLimitReports  RateLimit // reports to moderators
 
 	// Pagination
 	DefaultPageSize int
 	MaxPageSize     int
+
+	// Link health checks
+	LinkCheckEnabled   bool
+	LinkCheckInterval  time.Duration
+	LinkRecheckAfter   time.Duration
+	LinkCheckBatchSize int
+	LinkCheckHostDelay time.Duration
+
+	// Transcript imports
+	TranscriptImportEnabled  bool
+	TranscriptImportInterval time.Duration
+	TranscriptUploadMaxBytes int64
+
+	// Moderation
+	ReportFlagThreshold int // open reports that flag a chat or comment for review
+}
+
+// RateLimit allows Requests per Window for each user, or each IP address
+// for anonymous clients. Zero Requests disables the limit.
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// OIDCProviderConfig configures a login provider served by the generic
+// /auth/:provider routes. Providers with an Issuer are discovered; plain
+// OAuth 2.0 providers such as GitHub set the endpoints instead.
+type OIDCProviderConfig struct {
+	Name         string // route name and UserIdentity.Provider
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// Endpoints, overriding discovery
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+	JWKSURL     string
+
+	// Claims maps sub, email, email_verified, name and picture to the
+	// provider's claim names where they differ
+	Claims map[string]string
+
+	// AuthParams are added to the authorization URL
+	AuthParams map[string]string
 }
 
 func LoadConfig() *Config {
@@ -66,9 +140,14 @@ func LoadConfig() *Config {
 	defaultPageSize, _ := strconv.Atoi(getEnv("DEFAULT_PAGE_SIZE", "20"))
 	maxPageSize, _ := strconv.Atoi(getEnv("MAX_PAGE_SIZE", "100"))
 
-	jwtExpiration, err := time.ParseDuration(getEnv("JWT_EXPIRATION", "24h"))
+	jwtExpiration, err := time.ParseDuration(getEnv("JWT_EXPIRATION", "15m"))
 	if err != nil {
-		jwtExpiration = 24 * time.Hour
+		jwtExpiration = 15 * time.Minute
+	}
+
+	refreshTokenExpiration, err := time.ParseDuration(getEnv("REFRESH_TOKEN_EXPIRATION", "168h"))
+	if err != nil {
+		refreshTokenExpiration = 7 * 24 * time.Hour
 	}
 
 	rateLimitDuration, err := time.ParseDuration(getEnv("RATE_LIMIT_DURATION", "1m"))
@@ -76,6 +155,46 @@ func LoadConfig() *Config {
 		rateLimitDuration = 1 * time.Minute
 	}
 
+	linkCheckEnabled, _ := strconv.ParseBool(getEnv("LINK_CHECK_ENABLED", "true"))
+	linkCheckBatchSize, _ := strconv.Atoi(getEnv("LINK_CHECK_BATCH_SIZE", "200"))
+
+	linkCheckInterval, err := time.ParseDuration(getEnv("LINK_CHECK_INTERVAL", "1h"))
+	if err != nil {
+		linkCheckInterval = 1 * time.Hour
+	}
+
+	linkRecheckAfter, err := time.ParseDuration(getEnv("LINK_RECHECK_AFTER", "24h"))
+	if err != nil {
+		linkRecheckAfter = 24 * time.Hour
+	}
+
+	linkCheckHostDelay, err := time.ParseDuration(getEnv("LINK_CHECK_HOST_DELAY", "2s"))
+	if err != nil {
+		linkCheckHostDelay = 2 * time.Second
+	}
+
+	emailDigestEnabled, _ := strconv.ParseBool(getEnv("EMAIL_DIGEST_ENABLED", "false"))
+
+	emailDigestInterval, err := time.ParseDuration(getEnv("EMAIL_DIGEST_INTERVAL", "1h"))
+	if err != nil {
+		emailDigestInterval = time.Hour
+	}
+
+	jwtSecret := getEnv("JWT_SECRET", "your-secret-key")
+
+	transcriptImportEnabled, _ := strconv.ParseBool(getEnv("TRANSCRIPT_IMPORT_ENABLED", "true"))
+	transcriptUploadMaxBytes, _ := strconv.ParseInt(getEnv("TRANSCRIPT_UPLOAD_MAX_BYTES", "5242880"), 10, 64)
+
+	reportFlagThreshold, err := strconv.Atoi(getEnv("REPORT_FLAG_THRESHOLD", "3"))
+	if err != nil || reportFlagThreshold < 1 {
+		reportFlagThreshold = 3
+	}
+
+	transcriptImportInterval, err := time.ParseDuration(getEnv("TRANSCRIPT_IMPORT_INTERVAL", "5m"))
+	if err != nil {
+		transcriptImportInterval = 5 * time.Minute
+	}
+
 	return &Config{
 		Port:        getEnv("PORT", "8080"),
 		GinMode:     getEnv("GIN_MODE", "debug"),
@@ -93,9 +212,11 @@ func LoadConfig() *Config {
 		RedisPassword: getEnv("REDIS_PASSWORD", ""),
 		RedisDB:       redisDB,
 
-		JWTSecret:     getEnv("JWT_SECRET", "your-secret-key"),
+		JWTSecret:     jwtSecret,
 		JWTExpiration: jwtExpiration,
 
+		RefreshTokenExpiration: refreshTokenExpiration,
+
 		GoogleClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
 		GoogleClientSecret: getEnv("GOOGLE_CLIENT_SECRET", ""),
 		GoogleRedirectURL:  getEnv("GOOGLE_REDIRECT_URL", ""),
@@ -104,20 +225,136 @@ func LoadConfig() *Config {
 		LINEChannelSecret: getEnv("LINE_CHANNEL_SECRET", ""),
 		LINERedirectURL:   getEnv("LINE_REDIRECT_URL", ""),
 
+		OIDCProviders: loadOIDCProviders(),
+
 		SendGridAPIKey: getEnv("SENDGRID_API_KEY", ""),
 		FromEmail:      getEnv("FROM_EMAIL", "noreply@chatshare.com"),
 		FromName:       getEnv("FROM_NAME", "ChatShare"),
 
+		EmailDigestEnabled:  emailDigestEnabled,
+		EmailDigestInterval: emailDigestInterval,
+
+		UnsubscribeSecret: getEnv("UNSUBSCRIBE_SECRET", deriveKey(jwtSecret, "unsubscribe links")),
+
+		APIBaseURL: getEnv("API_BASE_URL", "http://localhost:8080"),
+
 		FrontendURL: getEnv("FRONTEND_URL", "http://localhost:3000"),
 
 		FirebaseCredentialsPath: getEnv("FIREBASE_CREDENTIALS_PATH", ""),
 
 		RateLimitRequests: rateLimitRequests,
 		RateLimitDuration: rateLimitDuration,
+		RateLimitRead:     parseRateLimit("RATE_LIMIT_READ", "300/1m"),
+		RateLimitAuth:     parseRateLimit("RATE_LIMIT_AUTH", "10/1m"),
+		RateLimitPosts:    parseRateLimit("RATE_LIMIT_POSTS", "10/1h"),
+		RateLimitComments: parseRateLimit("RATE_LIMIT_COMMENTS", "5/1m"),
+		RateLimitReports:  parseRateLimit("RATE_LIMIT_REPORTS", "10/1h"),
 
 		DefaultPageSize: defaultPageSize,
 		MaxPageSize:     maxPageSize,
+
+		LinkCheckEnabled:   linkCheckEnabled,
+		LinkCheckInterval:  linkCheckInterval,
+		LinkRecheckAfter:   linkRecheckAfter,
+		LinkCheckBatchSize: linkCheckBatchSize,
+		LinkCheckHostDelay: linkCheckHostDelay,
+
+		TranscriptImportEnabled:  transcriptImportEnabled,
+		TranscriptImportInterval: transcriptImportInterval,
+		TranscriptUploadMaxBytes: transcriptUploadMaxBytes,
+
+		ReportFlagThreshold: reportFlagThreshold,
+	}
+}
+
+// loadOIDCProviders reads the providers named in OIDC_PROVIDERS from
+// OIDC_<NAME>_* variables. Providers without a client ID are skipped.
+func loadOIDCProviders() []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, name := range splitList(getEnv("OIDC_PROVIDERS", "")) {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+		provider := OIDCProviderConfig{
+			Name:         name,
+			DisplayName:  getEnv(prefix+"DISPLAY_NAME", name),
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", ""),
+			AuthURL:      getEnv(prefix+"AUTH_URL", ""),
+			TokenURL:     getEnv(prefix+"TOKEN_URL", ""),
+			UserInfoURL:  getEnv(prefix+"USERINFO_URL", ""),
+			JWKSURL:      getEnv(prefix+"JWKS_URL", ""),
+			Claims:       splitPairs(getEnv(prefix+"CLAIMS", "")),
+			AuthParams:   splitPairs(getEnv(prefix+"AUTH_PARAMS", "")),
+		}
+		if provider.ClientID == "" {
+			continue
+		}
+
+		defaultScopes := ""
+		if provider.Issuer != "" {
+			defaultScopes = "openid email profile"
+		}
+		provider.Scopes = splitList(getEnv(prefix+"SCOPES", defaultScopes))
+
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+// parseRateLimit reads a rate limit written as "requests/window", e.g.
+// "5/1m", falling back to defaultValue when the variable is malformed
+func parseRateLimit(key, defaultValue string) RateLimit {
+	parse := func(value string) (RateLimit, bool) {
+		requests, window, ok := strings.Cut(value, "/")
+		if !ok {
+			return RateLimit{}, false
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(requests))
+		if err != nil || n < 0 {
+			return RateLimit{}, false
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(window))
+		if err != nil || d <= 0 {
+			return RateLimit{}, false
+		}
+		return RateLimit{Requests: n, Window: d}, true
+	}
+
+	if limit, ok := parse(getEnv(key, defaultValue)); ok {
+		return limit
+	}
+	limit, _ := parse(defaultValue)
+	return limit
+}
+
+// splitList splits a comma or space separated list
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// splitPairs parses "key=value,key=value"
+func splitPairs(value string) map[string]string {
+	pairs := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if ok && key != "" {
+			pairs[key] = val
+		}
 	}
+	return pairs
+}
+
+// deriveKey derives a key for one purpose from secret, so that a key
+// serving another purpose is never reused as is
+func deriveKey(secret, purpose string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("chatshare " + purpose))
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
 func getEnv(key, defaultValue string) string {
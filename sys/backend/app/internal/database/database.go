@@ -26,9 +26,16 @@ func InitDB(cfg *config.Config) (*gorm.DB, error) {
 }
 
 func RunMigrations(db *gorm.DB) error {
-	return db.AutoMigrate(
+	// Default screening rules are only added with the table, so rules
+	// deleted by admins stay deleted
+	seedScreeningRules := !db.Migrator().HasTable(&ScreeningRule{})
+
+	if err := db.AutoMigrate(
 		&User{},
+		&UserIdentity{},
+		&APIToken{},
 		&Chat{},
+		&ChatMessage{},
 		&Category{},
 		&Keyword{},
 		&ChatKeyword{},
@@ -37,5 +44,58 @@ func RunMigrations(db *gorm.DB) error {
 		&Comment{},
 		&View{},
 		&Share{},
-	)
+		&Notification{},
+		&EmailSuppression{},
+		&Report{},
+		&AuditLog{},
+		&ScreeningRule{},
+	); err != nil {
+		return err
+	}
+
+	if err := migrateIdentities(db); err != nil {
+		return err
+	}
+
+	if err := migrateAuditLog(db); err != nil {
+		return err
+	}
+
+	if seedScreeningRules {
+		rules := defaultScreeningRules()
+		if err := db.Create(&rules).Error; err != nil {
+			return err
+		}
+	}
+
+	return migrateSearch(db)
+}
+
+// defaultScreeningRules are the rules a new installation starts with: limits
+// on links in comments, repeated posts and posting by new accounts. There
+// are no banned words until admins add them.
+func defaultScreeningRules() []ScreeningRule {
+	return []ScreeningRule{
+		{Type: "links", Threshold: 2, Action: "flag", AppliesTo: "comment", IsActive: true,
+			Note: "Comments with more than 2 links are held for review"},
+		{Type: "links", Threshold: 5, Action: "reject", AppliesTo: "comment", IsActive: true,
+			Note: "Comments with more than 5 links are refused"},
+		{Type: "duplicate", Threshold: 0, WindowMinutes: 24 * 60, Action: "flag", AppliesTo: "all", IsActive: true,
+			Note: "Repeating a post from the last day is held for review"},
+		{Type: "duplicate", Threshold: 2, WindowMinutes: 24 * 60, Action: "reject", AppliesTo: "all", IsActive: true,
+			Note: "Posting the same thing more than 3 times a day is refused"},
+		{Type: "new_account", Threshold: 5, WindowMinutes: 60, AccountAgeHours: 24, Action: "reject", AppliesTo: "all", IsActive: true,
+			Note: "Accounts less than a day old can post 5 times an hour"},
+	}
+}
+
+// migrateIdentities links every user to the provider account it was created
+// with, for users created before identities existed
+func migrateIdentities(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO user_identities (user_id, provider, provider_id, email, name, avatar, last_login_at, created_at, updated_at)
+		SELECT id, provider, provider_id, email, name, avatar, last_login_at, created_at, NOW()
+		FROM users
+		WHERE deleted_at IS NULL
+		ON CONFLICT (provider, provider_id) DO NOTHING`).Error
 }
@@ -16,9 +16,13 @@ type User struct {
 	Avatar          string         `gorm:"size:512" json:"avatar"`
 	Provider        string         `gorm:"size:50;not null" json:"provider"` // google, line
 	ProviderID      string         `gorm:"uniqueIndex;not null" json:"provider_id"`
-	Role            string         `gorm:"size:50;default:'user'" json:"role"` // user, admin
+	Role            string         `gorm:"size:50;default:'user'" json:"role"` // user, curator, moderator, admin
 	Status          string         `gorm:"size:50;default:'active'" json:"status"` // active, suspended, deleted
+	SuspendedUntil  *time.Time     `json:"suspended_until,omitempty"` // nil for an indefinite suspension
+	SuspensionReason string        `gorm:"size:500" json:"suspension_reason,omitempty"`
 	LastLoginAt     *time.Time     `json:"last_login_at"`
+	NotificationPreferences NotificationPreferences `gorm:"embedded;embeddedPrefix:notify_" json:"notification_preferences"`
+	LastDigestAt    *time.Time     `json:"-"` // when the weekly digest email was last sent
 	CreatedAt       time.Time      `json:"created_at"`
 	UpdatedAt       time.Time      `json:"updated_at"`
 	DeletedAt       gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
@@ -30,6 +34,51 @@ type User struct {
 	Comments        []Comment      `gorm:"foreignKey:UserID" json:"comments,omitempty"`
 	Views           []View         `gorm:"foreignKey:UserID" json:"views,omitempty"`
 	Shares          []Share        `gorm:"foreignKey:UserID" json:"shares,omitempty"`
+	Identities      []UserIdentity `gorm:"foreignKey:UserID" json:"identities,omitempty"`
+}
+
+// UserIdentity is a provider account that can sign in as a user. A user has
+// one per linked provider account; User.Provider and User.ProviderID keep the
+// one the account was created with.
+type UserIdentity struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Provider    string     `gorm:"size:50;not null;uniqueIndex:idx_user_identities_provider_id" json:"provider"` // google, line, firebase
+	ProviderID  string     `gorm:"size:255;not null;uniqueIndex:idx_user_identities_provider_id" json:"-"`
+	Email       string     `gorm:"size:255" json:"email"`
+	Name        string     `gorm:"size:255" json:"name"`
+	Avatar      string     `gorm:"size:512" json:"avatar"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// APIToken is a personal access token a user created for an integration.
+// Only the SHA-256 of the token is stored.
+type APIToken struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	TokenHash  string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	Prefix     string     `gorm:"size:12" json:"prefix"`  // start of the token, to tell tokens apart
+	Scopes     string     `gorm:"size:255" json:"-"`       // space separated, see the apitoken package
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `gorm:"size:45" json:"last_used_ip"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `gorm:"index" json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// Relationships
+	User       User       `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// NotificationPreferences records which notification types a user receives.
+// Every type is on until the user opts out.
+type NotificationPreferences struct {
+	Comment  bool `gorm:"default:true" json:"comment"`
+	Reply    bool `gorm:"default:true" json:"reply"`
+	Favorite bool `gorm:"default:true" json:"favorite"`
+	NewChat  bool `gorm:"default:true" json:"new_chat"`
 }
 
 // Category represents a chat category
@@ -57,12 +106,21 @@ type Chat struct {
 	CategoryID      uuid.UUID      `gorm:"type:uuid;index" json:"category_id"`
 	Title           string         `gorm:"size:255;not null" json:"title"`
 	Description     string         `gorm:"size:1000" json:"description"`
-	PublicLink      string         `gorm:"size:512;uniqueIndex;not null" json:"public_link"`
-	ChatType        string         `gorm:"size:50;default:'chatgpt'" json:"chat_type"` // chatgpt, claude, copilot
+	PublicLink      string         `gorm:"size:512;uniqueIndex;not null" json:"public_link"` // canonical share URL, or the ChatShare page of an upload
+	ChatType        string         `gorm:"size:50;default:'chatgpt';index:idx_chats_provider_share,priority:1" json:"chat_type"` // provider name, see the providers package
+	ShareID         string         `gorm:"size:255;index:idx_chats_provider_share,priority:2" json:"share_id"` // provider's share identifier
+	Source          string         `gorm:"size:20;default:'link'" json:"source"` // link, upload
 	IsLinkValid     bool           `gorm:"default:true" json:"is_link_valid"`
+	LinkCheckedAt   *time.Time     `json:"link_checked_at"`                 // last check that found the link valid or dead
+	LinkAttemptedAt *time.Time     `gorm:"index" json:"link_attempted_at"`   // last check, including inconclusive ones
+	LinkError       string         `gorm:"size:255" json:"link_error,omitempty"` // why the last check marked the link invalid
+	MessageCount    int            `gorm:"default:0" json:"message_count"`
+	TranscriptFetchedAt *time.Time `json:"transcript_fetched_at"`
+	TranscriptError string         `gorm:"size:255" json:"transcript_error,omitempty"` // why the last transcript import failed
 	IsPublic        bool           `gorm:"default:true" json:"is_public"`
 	IsFeatured      bool           `gorm:"default:false" json:"is_featured"`
 	Status          string         `gorm:"size:50;default:'active'" json:"status"` // active, flagged, removed
+	FlagReason      string         `gorm:"size:255" json:"flag_reason,omitempty"` // why screening or reports flagged the chat
 	ViewCount       int            `gorm:"default:0" json:"view_count"`
 	ShareCount      int            `gorm:"default:0" json:"share_count"`
 	FavoriteCount   int            `gorm:"default:0" json:"favorite_count"`
@@ -75,6 +133,11 @@ type Chat struct {
 	// Non-persisted fields
 	IsFavorited     bool           `gorm:"-" json:"is_favorited,omitempty"`
 
+	// Read-only fields filled in by full-text search queries
+	SearchRank           float64   `gorm:"->;-:migration" json:"search_rank,omitempty"`
+	TitleHighlight       string    `gorm:"->;-:migration" json:"title_highlight,omitempty"`
+	DescriptionHighlight string    `gorm:"->;-:migration" json:"description_highlight,omitempty"`
+
 	// Relationships
 	User            User           `gorm:"foreignKey:UserID" json:"user,omitempty"`
 	Category        Category       `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
@@ -83,6 +146,17 @@ type Chat struct {
 	Comments        []Comment      `gorm:"foreignKey:ChatID" json:"comments,omitempty"`
 	Views           []View         `gorm:"foreignKey:ChatID" json:"views,omitempty"`
 	Shares          []Share        `gorm:"foreignKey:ChatID" json:"shares,omitempty"`
+	Messages        []ChatMessage  `gorm:"foreignKey:ChatID" json:"messages,omitempty"`
+}
+
+// ChatMessage is one turn of a chat's imported conversation
+type ChatMessage struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	ChatID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_chat_messages_position" json:"chat_id"`
+	Position  int       `gorm:"not null;uniqueIndex:idx_chat_messages_position" json:"position"` // 0-based order in the conversation
+	Role      string    `gorm:"size:20;not null" json:"role"`                                    // user, assistant
+	Content   string    `gorm:"type:text;not null" json:"content"`                               // Markdown
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // Keyword represents a keyword/tag
@@ -139,15 +213,22 @@ type Comment struct {
 	ID        uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
 	ChatID    uuid.UUID      `gorm:"type:uuid;not null;index" json:"chat_id"`
 	UserID    uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	ParentID  *uuid.UUID     `gorm:"type:uuid;index" json:"parent_id"` // nil for top-level comments
+	Depth     int            `gorm:"default:0" json:"depth"`           // 0 for top-level comments
 	Content   string         `gorm:"type:text;not null" json:"content"`
-	Status    string         `gorm:"size:50;default:'active'" json:"status"` // active, flagged, removed
+	Status    string         `gorm:"size:50;default:'active'" json:"status"` // active, flagged, hidden, removed, deleted
+	FlagReason string        `gorm:"size:255" json:"flag_reason,omitempty"` // why screening or reports flagged the comment
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
 
+	// Non-persisted fields
+	Replies   []Comment      `gorm:"-" json:"replies,omitempty"`
+
 	// Relationships
 	Chat      Chat           `gorm:"foreignKey:ChatID" json:"chat,omitempty"`
 	User      User           `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Parent    *Comment       `gorm:"foreignKey:ParentID" json:"-"`
 }
 
 // View represents a user viewing a chat
@@ -176,3 +257,97 @@ type Share struct {
 	Chat      Chat      `gorm:"foreignKey:ChatID" json:"chat,omitempty"`
 	User      User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
 }
+
+// Notification tells a user about activity on their chats or from users they
+// follow
+type Notification struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index:idx_notifications_user_created,priority:1" json:"user_id"` // recipient
+	ActorID   uuid.UUID  `gorm:"type:uuid;not null;index" json:"actor_id"`                                            // user whose action caused it
+	Type      string     `gorm:"size:50;not null" json:"type"`                                                        // comment, reply, favorite, new_chat, report_resolved, report_dismissed
+	ChatID    *uuid.UUID `gorm:"type:uuid;index" json:"chat_id"`
+	CommentID *uuid.UUID `gorm:"type:uuid" json:"comment_id"`
+	ReadAt    *time.Time `gorm:"index" json:"read_at"`
+	CreatedAt time.Time  `gorm:"index:idx_notifications_user_created,priority:2" json:"created_at"`
+
+	// Relationships
+	Actor     User       `gorm:"foreignKey:ActorID" json:"actor,omitempty"`
+	Chat      *Chat      `gorm:"foreignKey:ChatID" json:"chat,omitempty"`
+}
+
+// EmailSuppression records an address that unsubscribed from a mailing list.
+// It is keyed by address so it outlives the account.
+type EmailSuppression struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Email     string    `gorm:"size:255;not null;uniqueIndex:idx_email_suppressions_email_list" json:"email"` // lowercased
+	List      string    `gorm:"size:50;not null;uniqueIndex:idx_email_suppressions_email_list" json:"list"`   // digest, comments, all
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Report is a user's report of a chat or comment for moderators to review.
+// Each user can report a piece of content once; reporting it again after the
+// report was closed reopens it.
+type Report struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	ReporterID   uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_reports_reporter_target,priority:1" json:"reporter_id"`
+	TargetType   string     `gorm:"size:20;not null;uniqueIndex:idx_reports_reporter_target,priority:2;index:idx_reports_target,priority:1" json:"target_type"` // chat, comment
+	TargetID     uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_reports_reporter_target,priority:3;index:idx_reports_target,priority:2" json:"target_id"`
+	TargetUserID uuid.UUID  `gorm:"type:uuid;not null;index" json:"target_user_id"` // author of the reported content
+	ChatID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"chat_id"`        // the reported chat, or the chat of the reported comment
+	CommentID    *uuid.UUID `gorm:"type:uuid" json:"comment_id"`                    // nil for chat reports
+	Reason       string     `gorm:"size:50;not null" json:"reason"`                 // see handlers.ReportReasons
+	Details      string     `gorm:"size:1000" json:"details"`
+	Status       string     `gorm:"size:20;default:'open';index" json:"status"` // open, resolved, dismissed
+	ResolvedByID *uuid.UUID `gorm:"type:uuid" json:"resolved_by_id,omitempty"`
+	ResolvedAt   *time.Time `json:"resolved_at,omitempty"`
+	Resolution   string     `gorm:"size:500" json:"resolution,omitempty"` // moderator's note
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+
+	// Non-persisted fields
+	OpenReports  int64      `gorm:"-" json:"open_reports,omitempty"` // open reports on the same content
+
+	// Relationships
+	Reporter     User       `gorm:"foreignKey:ReporterID" json:"reporter,omitempty"`
+	Chat         *Chat      `gorm:"foreignKey:ChatID" json:"chat,omitempty"`
+	Comment      *Comment   `gorm:"foreignKey:CommentID" json:"comment,omitempty"`
+}
+
+// ScreeningRule is one check new chats and comments are screened with
+// before they are saved; see the screening package. Threshold and the windows
+// only apply to the rule types that use them.
+type ScreeningRule struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Type            string     `gorm:"size:20;not null;index" json:"type"`      // word, regex, links, duplicate, new_account
+	Pattern         string     `gorm:"size:500" json:"pattern,omitempty"`       // the word or regular expression
+	Threshold       int        `json:"threshold"`                               // links allowed, earlier copies allowed, or posts allowed per window
+	WindowMinutes   int        `json:"window_minutes"`                          // how far back duplicate and new_account rules count posts
+	AccountAgeHours int        `json:"account_age_hours"`                       // accounts younger than this are new
+	Action          string     `gorm:"size:20;not null" json:"action"`          // flag, reject
+	AppliesTo       string     `gorm:"size:20;default:'all'" json:"applies_to"` // all, chat, comment
+	IsActive        bool       `json:"is_active"`
+	Note            string     `gorm:"size:500" json:"note"`
+	CreatedByID     *uuid.UUID `gorm:"type:uuid" json:"created_by_id,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// AuditLog records an administrative action. Rows are never changed or
+// deleted; see migrateAuditLog.
+type AuditLog struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	ActorID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"actor_id"`
+	Action       string     `gorm:"size:50;not null;index" json:"action"` // see the audit package
+	TargetType   string     `gorm:"size:20;not null;index:idx_audit_logs_target,priority:1" json:"target_type"` // user, chat, comment, category
+	TargetID     uuid.UUID  `gorm:"type:uuid;not null;index:idx_audit_logs_target,priority:2" json:"target_id"`
+	TargetUserID *uuid.UUID `gorm:"type:uuid;index" json:"target_user_id"` // the account affected, or the author of the content
+	Before       JSON       `gorm:"type:jsonb" json:"before"` // changed fields before the action
+	After        JSON       `gorm:"type:jsonb" json:"after"`  // changed fields after it
+	Reason       string     `gorm:"size:500" json:"reason"`
+	IPAddress    string     `gorm:"size:45" json:"ip_address"`
+	CreatedAt    time.Time  `gorm:"index" json:"created_at"`
+
+	// Relationships
+	// No foreign key: entries outlive the accounts they mention
+	Actor        User       `gorm:"foreignKey:ActorID;constraint:-" json:"actor,omitempty"`
+}
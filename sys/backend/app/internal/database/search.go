@@ -0,0 +1,53 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SearchConfig is the PostgreSQL text search configuration used for chats
+const SearchConfig = "english"
+
+// searchVectorExpr builds a chat's search document: title and keyword names
+// rank above the description, which ranks above the imported conversation.
+// The conversation is truncated to stay within tsvector size limits.
+var searchVectorExpr = fmt.Sprintf(`
+	setweight(to_tsvector('%[1]s', coalesce(chats.title, '')), 'A') ||
+	setweight(to_tsvector('%[1]s', coalesce((
+		SELECT string_agg(keywords.name, ' ')
+		FROM chat_keywords
+		JOIN keywords ON keywords.id = chat_keywords.keyword_id
+		WHERE chat_keywords.chat_id = chats.id
+	), '')), 'B') ||
+	setweight(to_tsvector('%[1]s', coalesce(chats.description, '')), 'C') ||
+	setweight(to_tsvector('%[1]s', left(coalesce((
+		SELECT string_agg(chat_messages.content, ' ' ORDER BY chat_messages.position)
+		FROM chat_messages
+		WHERE chat_messages.chat_id = chats.id
+	), ''), 200000)), 'D')`, SearchConfig)
+
+// migrateSearch adds the full-text search column and its GIN index to chats,
+// and fills in the column for rows created before it existed
+func migrateSearch(db *gorm.DB) error {
+	statements := []string{
+		"ALTER TABLE chats ADD COLUMN IF NOT EXISTS search_vector tsvector",
+		"CREATE INDEX IF NOT EXISTS idx_chats_search_vector ON chats USING GIN (search_vector)",
+		"UPDATE chats SET search_vector = " + searchVectorExpr + " WHERE search_vector IS NULL",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RefreshChatSearchVector rebuilds the search document of a chat. Call it
+// after the chat's title, description, keywords or messages change.
+func RefreshChatSearchVector(db *gorm.DB, chatID uuid.UUID) error {
+	return db.Exec("UPDATE chats SET search_vector = "+searchVectorExpr+" WHERE id = ?", chatID).Error
+}
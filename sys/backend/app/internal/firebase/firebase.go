@@ -9,6 +9,14 @@ import (
 	"google.golang.org/api/option"
 )
 
+// TokenVerifier checks Firebase ID tokens and mints custom tokens. It is the
+// part of FirebaseService that sign-in depends on, so a fake can stand in
+// for it.
+type TokenVerifier interface {
+	VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error)
+	CreateCustomToken(ctx context.Context, uid string, claims map[string]interface{}) (string, error)
+}
+
 type FirebaseService struct {
 	app        *firebase.App
 	authClient *auth.Client
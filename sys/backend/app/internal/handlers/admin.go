@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	"context"
+	"log"
 	"net/http"
-	"strconv"
+	"time"
 
+	"github.com/chatshare/backend/internal/audit"
 	"github.com/chatshare/backend/internal/config"
 	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/providers"
+	"github.com/chatshare/backend/internal/rbac"
+	"github.com/chatshare/backend/internal/userstate"
 	"github.com/chatshare/backend/internal/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -13,21 +19,25 @@ import (
 )
 
 type AdminHandler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db           *gorm.DB
+	cfg          *config.Config
+	sessionStore *utils.SessionStore
+	userStates   *userstate.Store
 }
 
-func NewAdminHandler(db *gorm.DB, cfg *config.Config) *AdminHandler {
-	return &AdminHandler{db: db, cfg: cfg}
+func NewAdminHandler(db *gorm.DB, cfg *config.Config, sessionStore *utils.SessionStore, userStates *userstate.Store) *AdminHandler {
+	return &AdminHandler{db: db, cfg: cfg, sessionStore: sessionStore, userStates: userStates}
 }
 
+// userKeyset orders user listings newest first
+var userKeyset = keysetColumns{createdAt: "users.created_at", id: "users.id"}
+
 // User management
 func (h *AdminHandler) ListUsers(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(h.cfg.DefaultPageSize)))
-
-	if pageSize > h.cfg.MaxPageSize {
-		pageSize = h.cfg.MaxPageSize
+	params, err := parseListParams(c, h.cfg, userKeyset)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid cursor")
+		return
 	}
 
 	query := h.db.Model(&database.User{})
@@ -41,16 +51,20 @@ func (h *AdminHandler) ListUsers(c *gin.Context) {
 	}
 
 	var total int64
-	query.Count(&total)
+	if params.includeTotal {
+		query.Count(&total)
+	}
 
-	offset := (page - 1) * pageSize
 	var users []database.User
-	if err := query.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&users).Error; err != nil {
+	if err := params.apply(query, userKeyset).Find(&users).Error; err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch users")
 		return
 	}
+	users, hasMore := trimPage(params, users)
 
-	utils.PaginatedSuccessResponse(c, http.StatusOK, users, page, pageSize, total)
+	respondList(c, params, users, hasMore, total, func(user database.User) utils.Cursor {
+		return utils.Cursor{CreatedAt: user.CreatedAt, ID: user.ID}
+	})
 }
 
 func (h *AdminHandler) UpdateUserStatus(c *gin.Context) {
@@ -62,7 +76,9 @@ func (h *AdminHandler) UpdateUserStatus(c *gin.Context) {
 	}
 
 	var req struct {
-		Status string `json:"status" binding:"required"`
+		Status string     `json:"status" binding:"required,oneof=active suspended deleted"`
+		Until  *time.Time `json:"until"` // end of a suspension; omit to suspend indefinitely
+		Reason string     `json:"reason" binding:"max=500"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -70,18 +86,65 @@ func (h *AdminHandler) UpdateUserStatus(c *gin.Context) {
 		return
 	}
 
+	if req.Status == userstate.StatusSuspended && req.Until != nil && !req.Until.After(time.Now()) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Suspension end must be in the future")
+		return
+	}
+
+	if userID == c.MustGet("user_id").(uuid.UUID) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Cannot change your own status")
+		return
+	}
+
 	var user database.User
 	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
 		utils.ErrorResponse(c, http.StatusNotFound, "User not found")
 		return
 	}
 
+	before := user
 	user.Status = req.Status
-	if err := h.db.Save(&user).Error; err != nil {
+	user.SuspendedUntil = nil
+	user.SuspensionReason = ""
+	if req.Status != userstate.StatusActive {
+		user.SuspensionReason = req.Reason
+	}
+	if req.Status == userstate.StatusSuspended {
+		user.SuspendedUntil = req.Until
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, auditActor(c), audit.Entry{
+			Action:       audit.ActionUserStatus,
+			TargetType:   audit.TargetUser,
+			TargetID:     user.ID,
+			TargetUserID: &user.ID,
+			Before:       before,
+			After:        user,
+			Reason:       req.Reason,
+		})
+	}); err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update user status")
 		return
 	}
 
+	// Apply the change to the user's next request
+	ctx := context.Background()
+	if err := h.userStates.Invalidate(ctx, user.ID); err != nil {
+		log.Printf("Failed to clear cached state of user %s: %v", user.ID, err)
+	}
+
+	// Suspended users keep their sessions for when the suspension ends;
+	// deleted users are signed out everywhere
+	if user.Status == userstate.StatusDeleted {
+		if _, err := h.sessionStore.RevokeAllSessions(ctx, user.ID, "", h.cfg.JWTExpiration); err != nil {
+			log.Printf("Failed to revoke sessions of user %s: %v", user.ID, err)
+		}
+	}
+
 	utils.SuccessResponse(c, http.StatusOK, user)
 }
 
@@ -94,7 +157,8 @@ func (h *AdminHandler) UpdateUserRole(c *gin.Context) {
 	}
 
 	var req struct {
-		Role string `json:"role" binding:"required"`
+		Role   string `json:"role" binding:"required"`
+		Reason string `json:"reason" binding:"max=500"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -102,28 +166,75 @@ func (h *AdminHandler) UpdateUserRole(c *gin.Context) {
 		return
 	}
 
+	if !rbac.ValidRole(req.Role) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Unknown role")
+		return
+	}
+
+	// Keep admins from locking themselves out
+	if userID == c.MustGet("user_id").(uuid.UUID) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Cannot change your own role")
+		return
+	}
+
 	var user database.User
 	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
 		utils.ErrorResponse(c, http.StatusNotFound, "User not found")
 		return
 	}
 
+	before := user
 	user.Role = req.Role
-	if err := h.db.Save(&user).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, auditActor(c), audit.Entry{
+			Action:       audit.ActionUserRole,
+			TargetType:   audit.TargetUser,
+			TargetID:     user.ID,
+			TargetUserID: &user.ID,
+			Before:       before,
+			After:        user,
+			Reason:       req.Reason,
+		})
+	}); err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update user role")
 		return
 	}
 
+	// Signed-in sessions get the new role on their next request rather than
+	// when their access token is refreshed
+	if err := h.userStates.Invalidate(context.Background(), user.ID); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Role updated but existing sessions could not be refreshed")
+		return
+	}
+
 	utils.SuccessResponse(c, http.StatusOK, user)
 }
 
+// ListRoles returns the roles users can be given and their permissions
+// GET /api/v1/admin/roles
+func (h *AdminHandler) ListRoles(c *gin.Context) {
+	type roleInfo struct {
+		Role        string            `json:"role"`
+		Permissions []rbac.Permission `json:"permissions"`
+	}
+
+	roles := make([]roleInfo, 0, len(rbac.Roles))
+	for _, role := range rbac.Roles {
+		roles = append(roles, roleInfo{Role: role, Permissions: rbac.Permissions(role)})
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, roles)
+}
+
 // Chat management
 func (h *AdminHandler) ListAllChats(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(h.cfg.DefaultPageSize)))
-
-	if pageSize > h.cfg.MaxPageSize {
-		pageSize = h.cfg.MaxPageSize
+	params, err := parseListParams(c, h.cfg, chatKeyset)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid cursor")
+		return
 	}
 
 	query := h.db.Model(&database.Chat{})
@@ -133,17 +244,19 @@ func (h *AdminHandler) ListAllChats(c *gin.Context) {
 	}
 
 	var total int64
-	query.Count(&total)
+	if params.includeTotal {
+		query.Count(&total)
+	}
 
-	offset := (page - 1) * pageSize
 	var chats []database.Chat
-	if err := query.Preload("User").Preload("Category").Offset(offset).Limit(pageSize).
-		Order("created_at DESC").Find(&chats).Error; err != nil {
+	if err := params.apply(query.Preload("User").Preload("Category"), chatKeyset).
+		Find(&chats).Error; err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch chats")
 		return
 	}
+	chats, hasMore := trimPage(params, chats)
 
-	utils.PaginatedSuccessResponse(c, http.StatusOK, chats, page, pageSize, total)
+	respondList(c, params, chats, hasMore, total, chatCursor)
 }
 
 func (h *AdminHandler) UpdateChatStatus(c *gin.Context) {
@@ -155,7 +268,8 @@ func (h *AdminHandler) UpdateChatStatus(c *gin.Context) {
 	}
 
 	var req struct {
-		Status string `json:"status" binding:"required"`
+		Status string `json:"status" binding:"required,oneof=active flagged hidden removed"`
+		Reason string `json:"reason" binding:"max=500"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -169,8 +283,26 @@ func (h *AdminHandler) UpdateChatStatus(c *gin.Context) {
 		return
 	}
 
+	before := chat
 	chat.Status = req.Status
-	if err := h.db.Save(&chat).Error; err != nil {
+	// The status is now the moderator's decision, not the reports'
+	if chat.FlagReason == ReportFlagReason {
+		chat.FlagReason = ""
+	}
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&chat).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, auditActor(c), audit.Entry{
+			Action:       audit.ActionChatStatus,
+			TargetType:   audit.TargetChat,
+			TargetID:     chat.ID,
+			TargetUserID: &chat.UserID,
+			Before:       before,
+			After:        chat,
+			Reason:       req.Reason,
+		})
+	}); err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update chat status")
 		return
 	}
@@ -178,6 +310,9 @@ func (h *AdminHandler) UpdateChatStatus(c *gin.Context) {
 	utils.SuccessResponse(c, http.StatusOK, chat)
 }
 
+// DeleteChatByAdmin deletes a chat. An optional reason query parameter is
+// recorded in the audit log.
+// DELETE /api/v1/admin/chats/:id
 func (h *AdminHandler) DeleteChatByAdmin(c *gin.Context) {
 	chatIDStr := c.Param("id")
 	chatID, err := uuid.Parse(chatIDStr)
@@ -192,7 +327,19 @@ func (h *AdminHandler) DeleteChatByAdmin(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.Delete(&chat).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&chat).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, auditActor(c), audit.Entry{
+			Action:       audit.ActionChatDelete,
+			TargetType:   audit.TargetChat,
+			TargetID:     chat.ID,
+			TargetUserID: &chat.UserID,
+			Before:       chat,
+			Reason:       c.Query("reason"),
+		})
+	}); err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to delete chat")
 		return
 	}
@@ -200,6 +347,108 @@ func (h *AdminHandler) DeleteChatByAdmin(c *gin.Context) {
 	utils.MessageResponse(c, http.StatusOK, "Chat deleted successfully")
 }
 
+// BackfillCanonicalLinks is a one-off migration for chats posted before links
+// were canonicalized. It fills in the provider share ID of every chat and
+// rewrites links to their canonical form where that does not collide with
+// another chat; colliding chats show up in ListDuplicateChats. Each chat it
+// changes gets an audit log entry.
+// POST /api/v1/admin/chats/backfill-links
+func (h *AdminHandler) BackfillCanonicalLinks(c *gin.Context) {
+	var updated, unresolved int
+	actor := auditActor(c)
+
+	var batch []database.Chat
+	err := h.db.Unscoped().Model(&database.Chat{}).Select("id", "user_id", "public_link", "chat_type", "share_id").
+		Where("share_id = '' OR share_id IS NULL").
+		FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+			for _, chat := range batch {
+				match, err := providers.Resolve(chat.PublicLink)
+				if err != nil {
+					unresolved++
+					continue
+				}
+
+				updates := map[string]interface{}{
+					"chat_type": match.Provider.Name,
+					"share_id":  match.ShareID,
+				}
+
+				if match.CanonicalURL != chat.PublicLink {
+					var taken int64
+					h.db.Unscoped().Model(&database.Chat{}).Where("public_link = ?", match.CanonicalURL).Count(&taken)
+					if taken == 0 {
+						updates["public_link"] = match.CanonicalURL
+					}
+				}
+
+				if err := h.db.Transaction(func(tx *gorm.DB) error {
+					if err := tx.Unscoped().Model(&database.Chat{}).Where("id = ?", chat.ID).
+						UpdateColumns(updates).Error; err != nil {
+						return err
+					}
+					return audit.Record(tx, actor, audit.Entry{
+						Action:       audit.ActionChatLinkUpdate,
+						TargetType:   audit.TargetChat,
+						TargetID:     chat.ID,
+						TargetUserID: &chat.UserID,
+						Before: gin.H{
+							"chat_type":   chat.ChatType,
+							"share_id":    chat.ShareID,
+							"public_link": chat.PublicLink,
+						},
+						After: updates,
+					})
+				}); err != nil {
+					return err
+				}
+				updated++
+			}
+			return nil
+		}).Error
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to backfill chat links")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{
+		"updated":    updated,
+		"unresolved": unresolved,
+	})
+}
+
+// ListDuplicateChats returns groups of chats that point at the same share,
+// oldest first, so admins can decide which one to keep
+// GET /api/v1/admin/chats/duplicates
+func (h *AdminHandler) ListDuplicateChats(c *gin.Context) {
+	var groups []struct {
+		ChatType string
+		ShareID  string
+	}
+	if err := h.db.Model(&database.Chat{}).Select("chat_type, share_id").
+		Where("share_id <> ''").
+		Group("chat_type, share_id").
+		Having("COUNT(*) > 1").
+		Scan(&groups).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to find duplicate chats")
+		return
+	}
+
+	duplicates := make([]gin.H, 0, len(groups))
+	for _, group := range groups {
+		var chats []database.Chat
+		h.db.Preload("User").Where("chat_type = ? AND share_id = ?", group.ChatType, group.ShareID).
+			Order("created_at ASC").Find(&chats)
+
+		duplicates = append(duplicates, gin.H{
+			"chat_type": group.ChatType,
+			"share_id":  group.ShareID,
+			"chats":     chats,
+		})
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, duplicates)
+}
+
 // Category management
 func (h *AdminHandler) CreateCategory(c *gin.Context) {
 	var req struct {
@@ -227,7 +476,17 @@ func (h *AdminHandler) CreateCategory(c *gin.Context) {
 		IsActive:    true,
 	}
 
-	if err := h.db.Create(&category).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&category).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, auditActor(c), audit.Entry{
+			Action:     audit.ActionCategoryCreate,
+			TargetType: audit.TargetCategory,
+			TargetID:   category.ID,
+			After:      category,
+		})
+	}); err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create category")
 		return
 	}
@@ -264,6 +523,7 @@ func (h *AdminHandler) UpdateCategory(c *gin.Context) {
 		return
 	}
 
+	before := category
 	if req.Name != "" {
 		category.Name = req.Name
 	}
@@ -276,7 +536,18 @@ func (h *AdminHandler) UpdateCategory(c *gin.Context) {
 	category.SortOrder = req.SortOrder
 	category.IsActive = req.IsActive
 
-	if err := h.db.Save(&category).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&category).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, auditActor(c), audit.Entry{
+			Action:     audit.ActionCategoryUpdate,
+			TargetType: audit.TargetCategory,
+			TargetID:   category.ID,
+			Before:     before,
+			After:      category,
+		})
+	}); err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update category")
 		return
 	}
@@ -292,7 +563,23 @@ func (h *AdminHandler) DeleteCategory(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.Delete(&database.Category{}, "id = ?", categoryID).Error; err != nil {
+	var category database.Category
+	if err := h.db.First(&category, "id = ?", categoryID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Category not found")
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&category).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, auditActor(c), audit.Entry{
+			Action:     audit.ActionCategoryDelete,
+			TargetType: audit.TargetCategory,
+			TargetID:   category.ID,
+			Before:     category,
+		})
+	}); err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to delete category")
 		return
 	}
@@ -365,3 +652,9 @@ func (h *AdminHandler) GetStatistics(c *gin.Context) {
 
 	utils.SuccessResponse(c, http.StatusOK, stats)
 }
+
+// ListProviders returns the chat providers whose share links can be posted
+// GET /api/v1/admin/providers
+func (h *AdminHandler) ListProviders(c *gin.Context) {
+	utils.SuccessResponse(c, http.StatusOK, providers.List())
+}
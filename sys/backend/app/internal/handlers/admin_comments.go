@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/chatshare/backend/internal/audit"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// moderatedCommentStatuses are the statuses moderators move comments
+// between. Comments deleted by their author keep "deleted".
+var moderatedCommentStatuses = []string{"active", "flagged", "hidden", "removed"}
+
+// commentKeyset orders comment listings newest first
+var commentKeyset = keysetColumns{createdAt: "comments.created_at", id: "comments.id"}
+
+// ListAllComments lists comments for moderation, newest first. Filters:
+// status, chat_id, user_id, from and to (RFC 3339 or YYYY-MM-DD) and q,
+// which matches the content.
+// GET /api/v1/admin/comments
+func (h *AdminHandler) ListAllComments(c *gin.Context) {
+	params, err := parseListParams(c, h.cfg, commentKeyset)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	query := h.db.Model(&database.Comment{})
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("comments.status = ?", status)
+	}
+	if chatID := c.Query("chat_id"); chatID != "" {
+		id, err := uuid.Parse(chatID)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid chat ID")
+			return
+		}
+		query = query.Where("comments.chat_id = ?", id)
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+		query = query.Where("comments.user_id = ?", id)
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := parseDateParam(from, false)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid from date")
+			return
+		}
+		query = query.Where("comments.created_at >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseDateParam(to, true)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid to date")
+			return
+		}
+		query = query.Where("comments.created_at < ?", t)
+	}
+	if search := c.Query("q"); search != "" {
+		query = query.Where("comments.content ILIKE ?", "%"+search+"%")
+	}
+
+	var total int64
+	if params.includeTotal {
+		query.Count(&total)
+	}
+
+	var comments []database.Comment
+	if err := params.apply(query.Preload("User").Preload("Chat"), commentKeyset).
+		Find(&comments).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch comments")
+		return
+	}
+	comments, hasMore := trimPage(params, comments)
+
+	respondList(c, params, comments, hasMore, total, func(comment database.Comment) utils.Cursor {
+		return utils.Cursor{CreatedAt: comment.CreatedAt, ID: comment.ID}
+	})
+}
+
+// UpdateCommentStatus hides, removes or restores a comment
+// PUT /api/v1/admin/comments/:id/status
+func (h *AdminHandler) UpdateCommentStatus(c *gin.Context) {
+	commentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid comment ID")
+		return
+	}
+
+	var req struct {
+		Status string `json:"status" binding:"required,oneof=active flagged hidden removed"`
+		Reason string `json:"reason" binding:"max=500"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	var comment database.Comment
+	if err := h.db.First(&comment, "id = ?", commentID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Comment not found")
+		return
+	}
+	if comment.Status == "deleted" {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Comment was deleted by its author")
+		return
+	}
+
+	before := comment
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		// The status is now the moderator's decision, not the reports'
+		if err := tx.Model(&comment).Updates(map[string]interface{}{
+			"status":      req.Status,
+			"flag_reason": clearReportFlagReason(),
+		}).Error; err != nil {
+			return err
+		}
+		if err := audit.Record(tx, auditActor(c), commentStatusEntry(before, req.Status, req.Reason)); err != nil {
+			return err
+		}
+		return syncCommentCount(tx, comment.ChatID)
+	}); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update comment status")
+		return
+	}
+	comment.Status = req.Status
+
+	utils.SuccessResponse(c, http.StatusOK, comment)
+}
+
+// BulkUpdateCommentStatus hides, removes or restores many comments at once
+// POST /api/v1/admin/comments/bulk
+func (h *AdminHandler) BulkUpdateCommentStatus(c *gin.Context) {
+	var req struct {
+		CommentIDs []uuid.UUID `json:"comment_ids" binding:"required,min=1,max=100"`
+		Status     string      `json:"status" binding:"required,oneof=active hidden removed"`
+		Reason     string      `json:"reason" binding:"max=500"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	var updated int64
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		var comments []database.Comment
+		if err := tx.Where("id IN ? AND status IN ? AND status <> ?", req.CommentIDs, moderatedCommentStatuses, req.Status).
+			Find(&comments).Error; err != nil {
+			return err
+		}
+		if len(comments) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, 0, len(comments))
+		chatIDs := make(map[uuid.UUID]bool)
+		for _, comment := range comments {
+			ids = append(ids, comment.ID)
+			chatIDs[comment.ChatID] = true
+		}
+
+		result := tx.Model(&database.Comment{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":      req.Status,
+			"flag_reason": clearReportFlagReason(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		updated = result.RowsAffected
+
+		actor := auditActor(c)
+		for _, comment := range comments {
+			if err := audit.Record(tx, actor, commentStatusEntry(comment, req.Status, req.Reason)); err != nil {
+				return err
+			}
+		}
+
+		for chatID := range chatIDs {
+			if err := syncCommentCount(tx, chatID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update comments")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"updated": updated})
+}
+
+// commentStatusEntry is the audit log entry for moving a comment to status
+func commentStatusEntry(comment database.Comment, status, reason string) audit.Entry {
+	after := comment
+	after.Status = status
+	return audit.Entry{
+		Action:       audit.ActionCommentStatus,
+		TargetType:   audit.TargetComment,
+		TargetID:     comment.ID,
+		TargetUserID: &comment.UserID,
+		Before:       comment,
+		After:        after,
+		Reason:       reason,
+	}
+}
+
+// parseDateParam parses an RFC 3339 time or a YYYY-MM-DD date. A date used
+// as the end of a range (endOfDay) includes that whole day.
+func parseDateParam(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
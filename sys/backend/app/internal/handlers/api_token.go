@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chatshare/backend/internal/apitoken"
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxAPITokens is how many active API tokens a user can have
+const maxAPITokens = 20
+
+type APITokenHandler struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+func NewAPITokenHandler(db *gorm.DB, cfg *config.Config) *APITokenHandler {
+	return &APITokenHandler{db: db, cfg: cfg}
+}
+
+// apiTokenResponse is an API token as shown to its owner
+type apiTokenResponse struct {
+	database.APIToken
+	Scopes []string `json:"scopes"`
+}
+
+func newAPITokenResponse(token database.APIToken) apiTokenResponse {
+	return apiTokenResponse{APIToken: token, Scopes: apitoken.ScopeList(&token)}
+}
+
+// ListAPITokens returns the user's active API tokens, newest first
+// GET /api/v1/user/api-tokens
+func (h *APITokenHandler) ListAPITokens(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var tokens []database.APIToken
+	if err := h.db.Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&tokens).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch API tokens")
+		return
+	}
+
+	response := make([]apiTokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		response = append(response, newAPITokenResponse(token))
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, response)
+}
+
+// CreateAPIToken creates an API token. The token itself is only returned
+// here; afterwards only its prefix is shown.
+// POST /api/v1/user/api-tokens
+func (h *APITokenHandler) CreateAPIToken(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var req struct {
+		Name          string   `json:"name" binding:"required,max=100"`
+		Scopes        []string `json:"scopes" binding:"required,min=1"`
+		ExpiresInDays int      `json:"expires_in_days" binding:"min=0,max=365"` // 0 for no expiry
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Keep scopes in their canonical order without duplicates
+	requested := make(map[string]bool)
+	for _, scope := range req.Scopes {
+		if !apitoken.ValidScope(scope) {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Unknown scope: "+scope)
+			return
+		}
+		requested[scope] = true
+	}
+	var scopes []string
+	for _, scope := range apitoken.Scopes {
+		if requested[scope] {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	var active int64
+	if err := h.db.Model(&database.APIToken{}).
+		Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).
+		Count(&active).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create API token")
+		return
+	}
+	if active >= maxAPITokens {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Too many API tokens; revoke one first")
+		return
+	}
+
+	plaintext, hash, err := apitoken.Generate()
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate API token")
+		return
+	}
+
+	token := database.APIToken{
+		UserID:    userID,
+		Name:      strings.TrimSpace(req.Name),
+		TokenHash: hash,
+		Prefix:    plaintext[:len(apitoken.Prefix)+6],
+		Scopes:    strings.Join(scopes, " "),
+	}
+	if req.ExpiresInDays > 0 {
+		token.ExpiresAt = timePtr(time.Now().AddDate(0, 0, req.ExpiresInDays))
+	}
+
+	if err := h.db.Create(&token).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create API token")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusCreated, gin.H{
+		"token":     plaintext,
+		"api_token": newAPITokenResponse(token),
+	})
+}
+
+// RevokeAPIToken stops an API token from working
+// DELETE /api/v1/user/api-tokens/:id
+func (h *APITokenHandler) RevokeAPIToken(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	tokenID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid API token ID")
+		return
+	}
+
+	result := h.db.Model(&database.APIToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke API token")
+		return
+	}
+	if result.RowsAffected == 0 {
+		utils.ErrorResponse(c, http.StatusNotFound, "API token not found")
+		return
+	}
+
+	utils.MessageResponse(c, http.StatusOK, "API token revoked")
+}
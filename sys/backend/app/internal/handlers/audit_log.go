@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chatshare/backend/internal/audit"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxAuditExportRows caps a CSV export of the audit log; narrow the filters
+// to export more
+const maxAuditExportRows = 10000
+
+// auditKeyset orders audit log listings newest first
+var auditKeyset = keysetColumns{createdAt: "audit_logs.created_at", id: "audit_logs.id"}
+
+// auditActor is the signed-in user making a request, for the audit log
+func auditActor(c *gin.Context) audit.Actor {
+	return audit.Actor{ID: c.MustGet("user_id").(uuid.UUID), IP: c.ClientIP()}
+}
+
+// ListAuditLog lists administrative actions, newest first. Filters:
+// actor_id, action, target_type, target_id, target_user_id, and from and to
+// (RFC 3339 or YYYY-MM-DD). With format=csv the matching entries are
+// downloaded as a CSV file instead, up to 10000 rows.
+// GET /api/v1/admin/audit-log
+func (h *AdminHandler) ListAuditLog(c *gin.Context) {
+	query := h.db.Model(&database.AuditLog{})
+
+	for _, filter := range []struct{ param, column string }{
+		{"actor_id", "audit_logs.actor_id"},
+		{"target_id", "audit_logs.target_id"},
+		{"target_user_id", "audit_logs.target_user_id"},
+	} {
+		if value := c.Query(filter.param); value != "" {
+			id, err := uuid.Parse(value)
+			if err != nil {
+				utils.ErrorResponse(c, http.StatusBadRequest, "Invalid "+filter.param)
+				return
+			}
+			query = query.Where(filter.column+" = ?", id)
+		}
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("audit_logs.action = ?", action)
+	}
+	if targetType := c.Query("target_type"); targetType != "" {
+		query = query.Where("audit_logs.target_type = ?", targetType)
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := parseDateParam(from, false)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid from date")
+			return
+		}
+		query = query.Where("audit_logs.created_at >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseDateParam(to, true)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid to date")
+			return
+		}
+		query = query.Where("audit_logs.created_at < ?", t)
+	}
+
+	if c.Query("format") == "csv" {
+		h.exportAuditLog(c, query)
+		return
+	}
+
+	params, err := parseListParams(c, h.cfg, auditKeyset)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	var total int64
+	if params.includeTotal {
+		query.Count(&total)
+	}
+
+	var entries []database.AuditLog
+	if err := params.apply(query.Preload("Actor"), auditKeyset).Find(&entries).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch audit log")
+		return
+	}
+	entries, hasMore := trimPage(params, entries)
+
+	respondList(c, params, entries, hasMore, total, func(entry database.AuditLog) utils.Cursor {
+		return utils.Cursor{CreatedAt: entry.CreatedAt, ID: entry.ID}
+	})
+}
+
+// exportAuditLog writes the entries matched by query as a CSV download
+func (h *AdminHandler) exportAuditLog(c *gin.Context, query *gorm.DB) {
+	var entries []database.AuditLog
+	if err := query.Preload("Actor").
+		Order("audit_logs.created_at DESC, audit_logs.id DESC").
+		Limit(maxAuditExportRows).
+		Find(&entries).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch audit log")
+		return
+	}
+
+	filename := fmt.Sprintf("audit-log-%s.csv", time.Now().UTC().Format("20060102-150405"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("X-Total-Rows", strconv.Itoa(len(entries)))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{
+		"id", "created_at", "actor_id", "actor_email", "action", "target_type", "target_id",
+		"target_user_id", "reason", "ip_address", "before", "after",
+	})
+	for _, entry := range entries {
+		targetUserID := ""
+		if entry.TargetUserID != nil {
+			targetUserID = entry.TargetUserID.String()
+		}
+		w.Write(csvRow(
+			entry.ID.String(),
+			entry.CreatedAt.UTC().Format(time.RFC3339),
+			entry.ActorID.String(),
+			entry.Actor.Email,
+			entry.Action,
+			entry.TargetType,
+			entry.TargetID.String(),
+			targetUserID,
+			entry.Reason,
+			entry.IPAddress,
+			string(entry.Before),
+			string(entry.After),
+		))
+	}
+	w.Flush()
+}
+
+// csvRow makes cells safe to open in a spreadsheet: text starting with =, +,
+// -, @, a tab or a carriage return would run as a formula, so it is
+// prefixed with a quote
+func csvRow(cells ...string) []string {
+	for i, cell := range cells {
+		if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			cells[i] = "'" + cell
+		}
+	}
+	return cells
+}
+
+// moderationHistoryEntry is an audit log entry as shown to the user it
+// affected, without who made it or from where
+type moderationHistoryEntry struct {
+	ID         uuid.UUID     `json:"id"`
+	Action     string        `json:"action"`
+	TargetType string        `json:"target_type"`
+	TargetID   uuid.UUID     `json:"target_id"`
+	Before     database.JSON `json:"before"`
+	After      database.JSON `json:"after"`
+	Reason     string        `json:"reason"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// GetModerationHistory lists moderation actions taken against the current
+// user's account and content, including accounts merged into it, newest
+// first
+// GET /api/v1/user/moderation-history
+func (h *UserHandler) GetModerationHistory(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	params, err := parseListParams(c, h.cfg, auditKeyset)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	mergedIDs := h.db.Model(&database.AuditLog{}).Select("target_id").
+		Where("action = ? AND target_user_id = ?", audit.ActionUserMerge, userID)
+	query := h.db.Model(&database.AuditLog{}).
+		Where("(audit_logs.target_user_id = ? OR audit_logs.target_user_id IN (?)) AND audit_logs.action IN ?",
+			userID, mergedIDs, audit.ModerationActions)
+
+	var total int64
+	if params.includeTotal {
+		query.Count(&total)
+	}
+
+	var entries []database.AuditLog
+	if err := params.apply(query, auditKeyset).Find(&entries).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch moderation history")
+		return
+	}
+	entries, hasMore := trimPage(params, entries)
+
+	history := make([]moderationHistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		history = append(history, moderationHistoryEntry{
+			ID:         entry.ID,
+			Action:     entry.Action,
+			TargetType: entry.TargetType,
+			TargetID:   entry.TargetID,
+			Before:     entry.Before,
+			After:      entry.After,
+			Reason:     entry.Reason,
+			CreatedAt:  entry.CreatedAt,
+		})
+	}
+
+	respondList(c, params, history, hasMore, total, func(entry moderationHistoryEntry) utils.Cursor {
+		return utils.Cursor{CreatedAt: entry.CreatedAt, ID: entry.ID}
+	})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chatshare/backend/internal/audit"
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestRecordMergeCarriesEarlierMerges(t *testing.T) {
+	db, mock := newMockDB(t)
+	source := &database.User{ID: uuid.New(), Email: "old@example.com"}
+	targetID, earlierID := uuid.New(), uuid.New()
+
+	mock.ExpectQuery(`SELECT "target_id" FROM "audit_logs" WHERE action = \$1 AND target_user_id = \$2`).
+		WithArgs(audit.ActionUserMerge, source.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"target_id"}).AddRow(earlierID))
+	// The merged account, then the one merged into it before
+	for _, id := range []uuid.UUID{source.ID, earlierID} {
+		mock.ExpectQuery(`INSERT INTO "audit_logs"`).
+			WithArgs(sqlmock.AnyArg(), audit.ActionUserMerge, audit.TargetUser, id, targetID,
+				sqlmock.AnyArg(), sqlmock.AnyArg(), "", "", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	}
+
+	if err := recordMerge(db, audit.Actor{ID: targetID}, source, targetID); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestModerationHistoryIncludesMergedAccounts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock := newMockDB(t)
+	handler := NewUserHandler(db, &config.Config{DefaultPageSize: 20, MaxPageSize: 100}, nil, nil)
+	userID := uuid.New()
+
+	mock.ExpectQuery(`SELECT \* FROM "audit_logs" WHERE \(audit_logs.target_user_id = \$1 OR audit_logs.target_user_id IN ` +
+		`\(SELECT "target_id" FROM "audit_logs" WHERE action = \$2 AND target_user_id = \$3\)\) AND audit_logs.action IN`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "action"}).AddRow(uuid.New(), audit.ActionUserStatus))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/?cursor=", nil)
+	c.Set("user_id", userID)
+
+	handler.GetModerationHistory(c)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d: %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCSVRow(t *testing.T) {
+	got := csvRow("=HYPERLINK(\"https://evil.test\")", "+1", "-1", "@SUM(A1)", "\t=1", "spam", "", "a=b")
+	want := []string{"'=HYPERLINK(\"https://evil.test\")", "'+1", "'-1", "'@SUM(A1)", "'\t=1", "spam", "", "a=b"}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("cell %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -3,8 +3,10 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"time"
@@ -12,10 +14,12 @@ import (
 	"github.com/chatshare/backend/internal/config"
 	"github.com/chatshare/backend/internal/database"
 	"github.com/chatshare/backend/internal/firebase"
+	"github.com/chatshare/backend/internal/oidc"
+	"github.com/chatshare/backend/internal/rbac"
+	"github.com/chatshare/backend/internal/userstate"
 	"github.com/chatshare/backend/internal/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/redis/go-redis/v9"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"gorm.io/gorm"
@@ -26,11 +30,18 @@ type AuthHandler struct {
 	cfg           *config.Config
 	googleConfig  *oauth2.Config
 	lineConfig    *oauth2.Config
+	lineVerifier  *oidc.Verifier
+	oidcProviders map[string]*oidc.Provider // configured with OIDC_PROVIDERS
 	sessionStore  *utils.SessionStore
 	firebaseService *firebase.FirebaseService
+	firebaseAuth  firebase.TokenVerifier // nil when Firebase is not configured
+	userStates    *userstate.Store
 }
 
-func NewAuthHandler(db *gorm.DB, cfg *config.Config, redisClient *redis.Client, firebaseService *firebase.FirebaseService) *AuthHandler {
+// NewAuthHandler sets up sign-in. firebaseAuth may be nil when Firebase is
+// not configured; when it is the Admin SDK service, users signing in through
+// the other providers are also mirrored into Firebase.
+func NewAuthHandler(db *gorm.DB, cfg *config.Config, sessionStore *utils.SessionStore, firebaseAuth firebase.TokenVerifier, userStates *userstate.Store) *AuthHandler {
 	googleConfig := &oauth2.Config{
 		ClientID:     cfg.GoogleClientID,
 		ClientSecret: cfg.GoogleClientSecret,
@@ -53,14 +64,41 @@ func NewAuthHandler(db *gorm.DB, cfg *config.Config, redisClient *redis.Client,
 		},
 	}
 
-	return &AuthHandler{
+	handler := &AuthHandler{
 		db:            db,
 		cfg:           cfg,
 		googleConfig:  googleConfig,
 		lineConfig:    lineConfig,
-		sessionStore:  utils.NewSessionStore(redisClient),
-		firebaseService: firebaseService,
+		lineVerifier: &oidc.Verifier{
+			Issuer:   "https://access.line.me",
+			ClientID: cfg.LINEChannelID,
+			// Web login signs ID tokens with the channel secret (HS256),
+			// the LINE SDK with the published ES256 keys
+			ClientSecret: cfg.LINEChannelSecret,
+			Keys:         oidc.NewKeySet("https://api.line.me/oauth2/v2.1/certs"),
+		},
+		sessionStore:  sessionStore,
+		firebaseAuth:  firebaseAuth,
+		userStates:    userStates,
+	}
+	if service, ok := firebaseAuth.(*firebase.FirebaseService); ok {
+		if service == nil {
+			handler.firebaseAuth = nil
+		} else {
+			handler.firebaseService = service
+		}
+	}
+
+	handler.oidcProviders = make(map[string]*oidc.Provider)
+	for _, providerCfg := range cfg.OIDCProviders {
+		if builtinLoginProviders[providerCfg.Name] {
+			log.Printf("Ignoring OIDC provider %q: the name is taken by a built-in provider", providerCfg.Name)
+			continue
+		}
+		handler.oidcProviders[providerCfg.Name] = oidc.NewProvider(providerCfg)
 	}
+
+	return handler
 }
 
 // GoogleLogin generates OAuth URL with state token and redirects to Google
@@ -104,18 +142,38 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 
 	ctx := context.Background()
 
+	profile, err := h.googleProfile(ctx, req.Code, req.State)
+	if err != nil {
+		respondOAuthError(c, err)
+		return
+	}
+
+	// Create or update user in database and Firebase
+	user, err := h.findOrCreateUser(ctx, profile)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to process user")
+		return
+	}
+
+	// Sign-ins from the mobile SDK come without a state token
+	c.Set("mobile_sdk", req.State == "")
+
+	h.respondWithSession(c, user, "google")
+}
+
+// googleProfile completes a Google authorization and fetches the account's
+// profile. state is empty for the mobile SDK flow.
+func (h *AuthHandler) googleProfile(ctx context.Context, code, state string) (*oauthProfile, error) {
 	// If state is provided (web flow), validate it
 	// If state is not provided (mobile SDK flow), skip validation
-	if req.State != "" {
+	if state != "" {
 		// Validate state token for web OAuth flow
-		valid, err := h.sessionStore.ValidateAndDeleteState(ctx, req.State)
+		valid, err := h.sessionStore.ValidateAndDeleteState(ctx, state)
 		if err != nil {
-			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to validate state")
-			return
+			return nil, &oauthError{http.StatusInternalServerError, "Failed to validate state"}
 		}
 		if !valid {
-			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid or expired state token")
-			return
+			return nil, &oauthError{http.StatusBadRequest, "Invalid or expired state token"}
 		}
 	}
 
@@ -125,7 +183,7 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 
 	// Mobile SDK flow (no state): use empty redirect URI
 	// Web OAuth flow (with state): use configured redirect URI
-	if req.State == "" {
+	if state == "" {
 		// Mobile SDK flow: Create config with empty redirect URI
 		mobileConfig := &oauth2.Config{
 			ClientID:     h.googleConfig.ClientID,
@@ -134,30 +192,27 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 			Scopes:       h.googleConfig.Scopes,
 			Endpoint:     h.googleConfig.Endpoint,
 		}
-		token, err = mobileConfig.Exchange(ctx, req.Code)
+		token, err = mobileConfig.Exchange(ctx, code)
 	} else {
 		// Web OAuth flow: use configured redirect URI
-		token, err = h.googleConfig.Exchange(ctx, req.Code)
+		token, err = h.googleConfig.Exchange(ctx, code)
 	}
 
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("Failed to exchange token: %v", err))
-		return
+		return nil, &oauthError{http.StatusBadRequest, fmt.Sprintf("Failed to exchange token: %v", err)}
 	}
 
 	// Get user info from Google
 	client := h.googleConfig.Client(ctx, token)
 	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to get user info")
-		return
+		return nil, &oauthError{http.StatusInternalServerError, "Failed to get user info"}
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to read user info")
-		return
+		return nil, &oauthError{http.StatusInternalServerError, "Failed to read user info"}
 	}
 
 	var googleUser struct {
@@ -169,42 +224,28 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 	}
 
 	if err := json.Unmarshal(body, &googleUser); err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to parse user info")
-		return
-	}
-
-	// Create or update user in database and Firebase
-	user, jwtToken, err := h.findOrCreateUser(
-		ctx,
-		googleUser.Email,
-		googleUser.Name,
-		googleUser.Picture,
-		"google",
-		googleUser.ID,
-		googleUser.VerifiedEmail,
-	)
-	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to process user")
-		return
+		return nil, &oauthError{http.StatusInternalServerError, "Failed to parse user info"}
 	}
 
-	// Set session cookie
-	isProduction := h.cfg.Environment == "production"
-	utils.SetAuthCookie(c, jwtToken, isProduction)
-
-	utils.SuccessResponse(c, http.StatusOK, gin.H{
-		"token": jwtToken,
-		"user":  user,
-	})
+	return &oauthProfile{
+		Provider:      "google",
+		ProviderID:    googleUser.ID,
+		Email:         googleUser.Email,
+		EmailVerified: googleUser.VerifiedEmail,
+		Name:          googleUser.Name,
+		Avatar:        googleUser.Picture,
+	}, nil
 }
 
 // LINE OAuth - Get OAuth URL
 // GET /api/v1/auth/line/url
+//
+// Apps must pass code_challenge (S256) and keep the PKCE verifier to send
+// with the callback, so a code intercepted on its way back through the
+// chatshare:// scheme cannot be redeemed. Without it the server keeps the
+// verifier, which is only for the web flow posting the code straight back;
+// LINECallbackGET refuses to hand such a code to the app.
 func (h *AuthHandler) GetLINEOAuthURL(c *gin.Context) {
-	// Debug: Log the LINE configuration
-	fmt.Printf("DEBUG: LINE Config - ClientID: %s\n", h.lineConfig.ClientID)
-	fmt.Printf("DEBUG: LINE Config - RedirectURL: %s\n", h.lineConfig.RedirectURL)
-	
 	// Generate state token
 	state, err := utils.GenerateRandomString(32)
 	if err != nil {
@@ -212,19 +253,39 @@ func (h *AuthHandler) GetLINEOAuthURL(c *gin.Context) {
 		return
 	}
 
+	// The nonce ties the ID token to this request
+	nonce, err := utils.GenerateRandomString(32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate nonce")
+		return
+	}
+
+	stateData := &utils.OAuthState{Nonce: nonce}
+	opts := []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("nonce", nonce)}
+
+	if codeChallenge := c.Query("code_challenge"); codeChallenge != "" {
+		if c.DefaultQuery("code_challenge_method", "S256") != "S256" {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Only the S256 code challenge method is supported")
+			return
+		}
+		opts = append(opts,
+			oauth2.SetAuthURLParam("code_challenge", codeChallenge),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
+	} else {
+		stateData.CodeVerifier = oauth2.GenerateVerifier()
+		opts = append(opts, oauth2.S256ChallengeOption(stateData.CodeVerifier))
+	}
+
 	// Store state in Redis
 	ctx := context.Background()
-	if err := h.sessionStore.StoreState(ctx, state); err != nil {
+	if err := h.sessionStore.StoreStateData(ctx, state, stateData); err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to store state")
 		return
 	}
 
 	// Generate OAuth URL
-	url := h.lineConfig.AuthCodeURL(state)
-	
-	// Debug: Log the generated URL
-	fmt.Printf("DEBUG: Generated LINE OAuth URL: %s\n", url)
-	fmt.Printf("DEBUG: State token: %s\n", state)
+	url := h.lineConfig.AuthCodeURL(state, opts...)
 
 	// Return format matching the guide
 	utils.SuccessResponse(c, http.StatusOK, gin.H{
@@ -242,26 +303,18 @@ func (h *AuthHandler) LINECallbackGET(c *gin.Context) {
 	errorParam := c.Query("error")
 	errorDescription := c.Query("error_description")
 
-	// Debug: Log the User-Agent and request details
-	userAgent := c.GetHeader("User-Agent")
-	fmt.Printf("DEBUG: LINE Callback - User-Agent: %s\n", userAgent)
-	fmt.Printf("DEBUG: LINE Callback - All Headers: %v\n", c.Request.Header)
-	
 	// Check for OAuth errors
 	if errorParam != "" {
-		fmt.Printf("DEBUG: OAuth error received: %s - %s\n", errorParam, errorDescription)
 		// Always redirect to custom URL scheme for any OAuth errors since they likely come from mobile
 		redirectURL := fmt.Sprintf("chatshare://auth/line/callback?error=%s", url.QueryEscape(errorParam))
 		if errorDescription != "" {
 			redirectURL += fmt.Sprintf("&error_description=%s", url.QueryEscape(errorDescription))
 		}
-		fmt.Printf("DEBUG: Redirecting to: %s\n", redirectURL)
 		c.Redirect(http.StatusFound, redirectURL)
 		return
 	}
 
 	if code == "" || state == "" {
-		fmt.Printf("DEBUG: Missing code or state parameter\n")
 		// Assume mobile and redirect to custom URL scheme with error
 		c.Redirect(http.StatusFound, "chatshare://auth/line/callback?error=invalid_request&error_description=Missing+code+or+state+parameter")
 		return
@@ -269,25 +322,30 @@ func (h *AuthHandler) LINECallbackGET(c *gin.Context) {
 
 	// For mobile clients, just validate state without deleting it
 	// The mobile app will use the POST endpoint which will delete the state
-	ctx := context.Background()
-	valid, err := h.sessionStore.ValidateState(ctx, state) // Don't delete yet
+	ctx := c.Request.Context()
+	stateData, err := h.sessionStore.LookupState(ctx, state) // Don't delete yet
 	if err != nil {
-		fmt.Printf("DEBUG: State validation error in GET: %v\n", err)
+		log.Printf("Failed to look up LINE state token: %v", err)
 		c.Redirect(http.StatusFound, "chatshare://auth/line/callback?error=invalid_state&error_description=State+validation+failed")
 		return
 	}
-	if !valid {
-		fmt.Printf("DEBUG: Invalid state token in GET: %s\n", state)
+	if stateData == nil {
 		c.Redirect(http.StatusFound, "chatshare://auth/line/callback?error=invalid_state&error_description=Invalid+or+expired+state+token")
 		return
 	}
 
+	// Any app registered for the custom scheme can receive the code, so it is
+	// only handed over when the app holds the PKCE verifier needed to redeem it
+	if stateData.CodeVerifier != "" {
+		h.sessionStore.ConsumeState(ctx, state)
+		c.Redirect(http.StatusFound, "chatshare://auth/line/callback?error=invalid_request&error_description=code_challenge+is+required")
+		return
+	}
+
 	// For now, always assume this is a mobile client since we're primarily targeting mobile
 	// TODO: Improve detection based on referrer or custom parameters
-	fmt.Printf("DEBUG: Processing as mobile client, redirecting to custom URL scheme\n")
-	redirectURL := fmt.Sprintf("chatshare://auth/line/callback?code=%s&state=%s", 
+	redirectURL := fmt.Sprintf("chatshare://auth/line/callback?code=%s&state=%s",
 		url.QueryEscape(code), url.QueryEscape(state))
-	fmt.Printf("DEBUG: Redirecting to: %s\n", redirectURL)
 	c.Redirect(http.StatusFound, redirectURL)
 }
 
@@ -296,8 +354,9 @@ func (h *AuthHandler) LINECallbackGET(c *gin.Context) {
 func (h *AuthHandler) LINECallback(c *gin.Context) {
 	// Parse request body
 	var req struct {
-		Code  string `json:"code" binding:"required"`
-		State string `json:"state" binding:"required"`
+		Code         string `json:"code" binding:"required"`
+		State        string `json:"state" binding:"required"`
+		CodeVerifier string `json:"code_verifier"` // required if the URL was requested with code_challenge
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -306,162 +365,428 @@ func (h *AuthHandler) LINECallback(c *gin.Context) {
 	}
 
 	// Process the callback using common logic
-	h.processLINECallback(c, req.Code, req.State)
+	h.processLINECallback(c, req.Code, req.State, req.CodeVerifier)
 }
 
 // processLINECallback contains the shared logic for both GET and POST callbacks
-func (h *AuthHandler) processLINECallback(c *gin.Context, code, state string) {
-
-	// Validate state token
+func (h *AuthHandler) processLINECallback(c *gin.Context, code, state, codeVerifier string) {
 	ctx := context.Background()
-	valid, err := h.sessionStore.ValidateAndDeleteState(ctx, state)
+
+	profile, err := h.lineProfile(ctx, code, state, codeVerifier)
 	if err != nil {
-		fmt.Printf("DEBUG: State validation error: %v\n", err)
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to validate state")
+		respondOAuthError(c, err)
 		return
 	}
-	if !valid {
-		fmt.Printf("DEBUG: Invalid state token: %s\n", state)
-		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid or expired state token")
+
+	user, err := h.findOrCreateUser(ctx, profile)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to process user")
 		return
 	}
 
-	fmt.Printf("DEBUG: State validation successful, proceeding with token exchange\n")
+	h.respondWithSession(c, user, "line")
+}
 
-	// Exchange code for token
-	token, err := h.lineConfig.Exchange(ctx, code)
+// lineProfile completes a LINE authorization and reads the account from
+// the verified ID token. codeVerifier is the app's PKCE verifier, if it
+// started the flow with its own code challenge.
+func (h *AuthHandler) lineProfile(ctx context.Context, code, state, codeVerifier string) (*oauthProfile, error) {
+	// Validate state token
+	stateData, err := h.sessionStore.ConsumeState(ctx, state)
 	if err != nil {
-		fmt.Printf("DEBUG: Token exchange error: %v\n", err)
-		utils.ErrorResponse(c, http.StatusBadRequest, "Failed to exchange token")
-		return
+		log.Printf("Failed to validate LINE state token: %v", err)
+		return nil, &oauthError{http.StatusInternalServerError, "Failed to validate state"}
+	}
+	if stateData == nil {
+		return nil, &oauthError{http.StatusBadRequest, "Invalid or expired state token"}
 	}
 
-	client := h.lineConfig.Client(ctx, token)
-	resp, err := client.Get("https://api.line.me/v2/profile")
-	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to get user info")
-		return
+	verifier := stateData.CodeVerifier
+	if verifier == "" {
+		if codeVerifier == "" {
+			return nil, &oauthError{http.StatusBadRequest, "code_verifier is required"}
+		}
+		verifier = codeVerifier
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	// Exchange code for token
+	token, err := h.lineConfig.Exchange(ctx, code, oauth2.VerifierOption(verifier))
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to read user info")
-		return
+		log.Printf("LINE token exchange failed: %v", err)
+		return nil, &oauthError{http.StatusBadRequest, "Failed to exchange token"}
 	}
 
-	var lineUser struct {
-		UserID        string `json:"userId"`
-		DisplayName   string `json:"displayName"`
-		PictureURL    string `json:"pictureUrl"`
-		StatusMessage string `json:"statusMessage"`
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, &oauthError{http.StatusBadGateway, "LINE did not return an ID token"}
 	}
 
-	if err := json.Unmarshal(body, &lineUser); err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to parse user info")
-		return
+	claims, err := h.lineVerifier.Verify(ctx, rawIDToken, stateData.Nonce)
+	if err != nil {
+		log.Printf("LINE ID token verification failed: %v", err)
+		return nil, &oauthError{http.StatusUnauthorized, "Invalid LINE ID token"}
 	}
 
-	// LINE doesn't always provide email, use userID as email fallback
-	email := fmt.Sprintf("%s@line.user", lineUser.UserID)
+	profile := &oauthProfile{
+		Provider:   "line",
+		ProviderID: claims.Subject,
+		Email:      claims.Email,
+		// LINE does not say whether the address was verified
+		EmailVerified: false,
+		Name:          claims.Name,
+		Avatar:        claims.Picture,
+	}
 
-	user, jwtToken, err := h.findOrCreateUser(
-		ctx,
-		email,
-		lineUser.DisplayName,
-		lineUser.PictureURL,
-		"line",
-		lineUser.UserID,
-		false, // LINE doesn't verify email by default
-	)
-	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to process user")
-		return
+	// Email is only included when the channel has the email permission and
+	// the user agreed to share it
+	if profile.Email == "" {
+		profile.Email = placeholderEmail(profile.Provider, profile.ProviderID)
 	}
 
-	// Set session cookie
-	isProduction := h.cfg.Environment == "production"
-	utils.SetAuthCookie(c, jwtToken, isProduction)
+	return profile, nil
+}
 
-	utils.SuccessResponse(c, http.StatusOK, gin.H{
-		"token": jwtToken,
-		"user":  user,
-	})
+// placeholderEmail is the address given to users whose provider shares none
+func placeholderEmail(provider, providerID string) string {
+	return fmt.Sprintf("%s@%s.user", providerID, provider)
+}
+
+// oauthProfile is the account a provider vouched for at the end of a sign-in
+type oauthProfile struct {
+	Provider      string
+	ProviderID    string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Avatar        string
+}
+
+// oauthError is a failed provider sign-in along with the response to send
+type oauthError struct {
+	status  int
+	message string
+}
+
+func (e *oauthError) Error() string {
+	return e.message
+}
+
+func respondOAuthError(c *gin.Context, err error) {
+	var oauthErr *oauthError
+	if errors.As(err, &oauthErr) {
+		utils.ErrorResponse(c, oauthErr.status, oauthErr.message)
+		return
+	}
+	utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to process user")
 }
 
-func (h *AuthHandler) findOrCreateUser(ctx context.Context, email, name, avatar, provider, providerID string, emailVerified bool) (*database.User, string, error) {
+// findOrCreateUser resolves a provider account to its user through the
+// linked identities, creating the user on first sign-in
+func (h *AuthHandler) findOrCreateUser(ctx context.Context, profile *oauthProfile) (*database.User, error) {
 	var user database.User
+	var identity database.UserIdentity
 
-	err := h.db.Where("provider = ? AND provider_id = ?", provider, providerID).First(&user).Error
+	err := h.db.Where("provider = ? AND provider_id = ?", profile.Provider, profile.ProviderID).First(&identity).Error
 	if err == gorm.ErrRecordNotFound {
 		// Create new user
+		now := time.Now()
 		user = database.User{
 			ID:            uuid.New(),
-			Email:         email,
-			EmailVerified: emailVerified,
-			Name:          name,
-			Avatar:        avatar,
-			Provider:      provider,
-			ProviderID:    providerID,
+			Email:         profile.Email,
+			EmailVerified: profile.EmailVerified,
+			Name:          profile.Name,
+			Avatar:        profile.Avatar,
+			Provider:      profile.Provider,
+			ProviderID:    profile.ProviderID,
 			Role:          "user",
 			Status:        "active",
-			LastLoginAt:   timePtr(time.Now()),
+			LastLoginAt:   &now,
 		}
 
-		if err := h.db.Create(&user).Error; err != nil {
-			return nil, "", err
+		// Emails are unique; a provider account whose address another user
+		// already has gets a placeholder and can be linked to that user later
+		if taken, err := h.emailTaken(user.Email, user.ID); err != nil {
+			return nil, err
+		} else if taken {
+			user.Email = placeholderEmail(profile.Provider, profile.ProviderID)
+			user.EmailVerified = false
 		}
 
-		// Create user in Firebase Admin
-		if h.firebaseService != nil {
-			firebaseUID := fmt.Sprintf("%s_%s", provider, user.ID.String())
-			if err := h.firebaseService.CreateOrUpdateUser(
-				ctx,
-				firebaseUID,
-				email,
-				name,
-				avatar,
-				emailVerified,
-			); err != nil {
-				// Log error but don't fail the request
-				fmt.Printf("Warning: Failed to create Firebase user: %v\n", err)
+		if err := h.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&user).Error; err != nil {
+				return err
 			}
+			return tx.Create(newUserIdentity(user.ID, profile)).Error
+		}); err != nil {
+			return nil, err
 		}
 	} else if err != nil {
-		return nil, "", err
+		return nil, err
 	} else {
-		// Update existing user
+		if err := h.db.First(&user, "id = ?", identity.UserID).Error; err != nil {
+			return nil, err
+		}
+
+		// Update existing user. The profile shown on ChatShare follows the
+		// provider the account was created with.
 		now := time.Now()
 		user.LastLoginAt = &now
-		user.Name = name
-		user.Avatar = avatar
-		user.EmailVerified = emailVerified
+		if profile.Provider == user.Provider && profile.ProviderID == user.ProviderID {
+			user.Name = profile.Name
+			user.Avatar = profile.Avatar
+			user.EmailVerified = profile.EmailVerified
+
+			// Replace the placeholder once the provider shares a real address
+			placeholder := placeholderEmail(profile.Provider, profile.ProviderID)
+			if user.Email == placeholder && profile.Email != placeholder {
+				if taken, err := h.emailTaken(profile.Email, user.ID); err == nil && !taken {
+					user.Email = profile.Email
+				}
+			}
+		}
 		h.db.Save(&user)
 
-		// Update user in Firebase Admin
-		if h.firebaseService != nil {
-			firebaseUID := fmt.Sprintf("%s_%s", provider, user.ID.String())
-			if err := h.firebaseService.CreateOrUpdateUser(
-				ctx,
-				firebaseUID,
-				email,
-				name,
-				avatar,
-				emailVerified,
-			); err != nil {
-				// Log error but don't fail the request
-				fmt.Printf("Warning: Failed to update Firebase user: %v\n", err)
-			}
+		identity.Email = profile.Email
+		identity.Name = profile.Name
+		identity.Avatar = profile.Avatar
+		identity.LastLoginAt = &now
+		h.db.Save(&identity)
+	}
+
+	// Create or update the user in Firebase Admin. Users who signed up
+	// through Firebase Auth are already there.
+	if h.firebaseService != nil && user.Provider != "firebase" {
+		firebaseUID := fmt.Sprintf("%s_%s", user.Provider, user.ID.String())
+		if err := h.firebaseService.CreateOrUpdateUser(
+			ctx,
+			firebaseUID,
+			user.Email,
+			user.Name,
+			user.Avatar,
+			user.EmailVerified,
+		); err != nil {
+			// Log error but don't fail the request
+			fmt.Printf("Warning: Failed to sync Firebase user: %v\n", err)
 		}
 	}
 
-	// Generate JWT token
-	token, err := utils.GenerateJWT(user.ID, user.Email, user.Role, h.cfg.JWTSecret, h.cfg.JWTExpiration)
+	return &user, nil
+}
+
+// emailTaken reports whether a user other than userID has the address
+func (h *AuthHandler) emailTaken(email string, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := h.db.Model(&database.User{}).Where("email = ? AND id <> ?", email, userID).Count(&count).Error
+	return count > 0, err
+}
+
+// newUserIdentity links a provider account to a user
+func newUserIdentity(userID uuid.UUID, profile *oauthProfile) *database.UserIdentity {
+	return &database.UserIdentity{
+		UserID:      userID,
+		Provider:    profile.Provider,
+		ProviderID:  profile.ProviderID,
+		Email:       profile.Email,
+		Name:        profile.Name,
+		Avatar:      profile.Avatar,
+		LastLoginAt: timePtr(time.Now()),
+	}
+}
+
+// tokenPair is the access and refresh token handed out on sign-in and refresh
+type tokenPair struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// startSession records a login on this device and issues its first token
+// pair. provider is how the user signed in. Suspended users get an
+// *userstate.AccountError.
+func (h *AuthHandler) startSession(c *gin.Context, user *database.User, provider string) (*tokenPair, error) {
+	ctx := context.Background()
+
+	state, err := h.userStates.Get(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if accountErr := state.Check(time.Now()); accountErr != nil {
+		return nil, accountErr
+	}
+	// Reflect a timed suspension that has just been lifted
+	user.Status, user.SuspendedUntil, user.SuspensionReason = state.Status, state.SuspendedUntil, state.SuspensionReason
+
+	session := &utils.Session{
+		UserID:    user.ID,
+		Device:    utils.DetectDevice(c.GetHeader("X-Client-Platform"), c.GetHeader("User-Agent")),
+		Provider:  provider,
+		UserAgent: c.GetHeader("User-Agent"),
+		IPAddress: c.ClientIP(),
+	}
+	if session.Device == "web" && c.GetBool("mobile_sdk") {
+		session.Device = "mobile"
+	}
+
+	refreshToken, err := h.sessionStore.CreateRefreshSession(ctx, session, h.cfg.RefreshTokenExpiration)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := utils.GenerateJWT(user.ID, user.Email, user.Role, session.ID, h.cfg.JWTSecret, h.cfg.JWTExpiration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// respondWithSession starts a session for a signed-in user and returns the
+// tokens both in the body and as cookies
+func (h *AuthHandler) respondWithSession(c *gin.Context, user *database.User, provider string) {
+	tokens, err := h.startSession(c, user, provider)
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+
+	h.respondWithTokens(c, tokens, gin.H{"user": user})
+}
+
+// respondSessionError reports why startSession failed
+func respondSessionError(c *gin.Context, err error) {
+	var accountErr *userstate.AccountError
+	if errors.As(err, &accountErr) {
+		utils.ErrorResponseWithCode(c, http.StatusForbidden, accountErr.Code, accountErr.Message, accountErr.Details())
+		return
+	}
+	utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create session")
+}
+
+func (h *AuthHandler) respondWithTokens(c *gin.Context, tokens *tokenPair, data gin.H) {
+	csrfToken, err := h.setAuthCookies(c, tokens)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate CSRF token")
+		return
+	}
+
+	data["csrf_token"] = csrfToken
+	data["token"] = tokens.AccessToken
+	data["refresh_token"] = tokens.RefreshToken
+	data["expires_in"] = int(h.cfg.JWTExpiration / time.Second)
+	utils.SuccessResponse(c, http.StatusOK, data)
+}
+
+// setAuthCookies stores a token pair in cookies for browser clients and
+// returns the CSRF token that goes with them
+func (h *AuthHandler) setAuthCookies(c *gin.Context, tokens *tokenPair) (string, error) {
+	csrfToken, err := h.setCSRFCookie(c)
+	if err != nil {
+		return "", err
+	}
+
+	isProduction := h.cfg.Environment == "production"
+	utils.SetAuthCookie(c, tokens.AccessToken, h.cfg.JWTExpiration, isProduction)
+	utils.SetRefreshCookie(c, tokens.RefreshToken, h.cfg.RefreshTokenExpiration, isProduction)
+	return csrfToken, nil
+}
+
+// setCSRFCookie issues a new double-submit token for cookie-authenticated
+// requests. It lives as long as the refresh session can.
+func (h *AuthHandler) setCSRFCookie(c *gin.Context) (string, error) {
+	token, err := utils.GenerateRandomString(32)
 	if err != nil {
-		return nil, "", err
+		return "", err
 	}
 
-	return &user, token, nil
+	utils.SetCSRFCookie(c, token, h.cfg.RefreshTokenExpiration, h.cfg.Environment == "production")
+	return token, nil
+}
+
+// GetCSRFToken issues a CSRF token for the web app. Requests authenticated by
+// the session cookie must send it back in the X-CSRF-Token header.
+// GET /api/v1/auth/csrf
+func (h *AuthHandler) GetCSRFToken(c *gin.Context) {
+	token, err := h.setCSRFCookie(c)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate CSRF token")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"csrf_token": token})
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. Each refresh token works once; presenting a used one revokes the
+// session.
+// POST /api/v1/auth/refresh
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	// The body is optional when the refresh cookie is sent
+	_ = c.ShouldBindJSON(&req)
+
+	refreshToken := req.RefreshToken
+	if refreshToken == "" {
+		refreshToken, _ = c.Cookie(utils.RefreshCookieName)
+
+		if refreshToken != "" && !utils.VerifyCSRF(c, h.cfg.FrontendURL, h.cfg.APIBaseURL) {
+			utils.ErrorResponse(c, http.StatusForbidden, "CSRF token missing or invalid")
+			return
+		}
+	}
+	if refreshToken == "" {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Refresh token required")
+		return
+	}
+
+	ctx := context.Background()
+	session, newRefreshToken, err := h.sessionStore.RotateRefreshToken(ctx, refreshToken, c.ClientIP(),
+		h.cfg.RefreshTokenExpiration, h.cfg.JWTExpiration)
+	if err != nil {
+		utils.ClearRefreshCookie(c)
+		switch {
+		case errors.Is(err, utils.ErrRefreshTokenReused):
+			utils.ErrorResponse(c, http.StatusUnauthorized, "Refresh token was already used; the session has been revoked")
+		case errors.Is(err, utils.ErrRefreshTokenInvalid):
+			utils.ErrorResponse(c, http.StatusUnauthorized, "Invalid or expired refresh token")
+		default:
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to refresh session")
+		}
+		return
+	}
+
+	var user database.User
+	if err := h.db.First(&user, "id = ?", session.UserID).Error; err != nil {
+		h.sessionStore.RevokeSession(ctx, session.UserID, session.ID, h.cfg.JWTExpiration)
+		utils.ClearRefreshCookie(c)
+		utils.ErrorResponse(c, http.StatusUnauthorized, "Account is not active")
+		return
+	}
+
+	// A suspended user keeps the session for when the suspension ends, but
+	// gets no new access tokens
+	state, err := h.userStates.Get(ctx, user.ID)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to refresh session")
+		return
+	}
+	if accountErr := state.Check(time.Now()); accountErr != nil {
+		if accountErr.Code == userstate.CodeAccountDeleted {
+			h.sessionStore.RevokeSession(ctx, session.UserID, session.ID, h.cfg.JWTExpiration)
+			utils.ClearRefreshCookie(c)
+		}
+		utils.ErrorResponseWithCode(c, http.StatusForbidden, accountErr.Code, accountErr.Message, accountErr.Details())
+		return
+	}
+
+	// Pick up role changes made since the last refresh
+	accessToken, err := utils.GenerateJWT(user.ID, user.Email, user.Role, session.ID, h.cfg.JWTSecret, h.cfg.JWTExpiration)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	h.respondWithTokens(c, &tokenPair{AccessToken: accessToken, RefreshToken: newRefreshToken}, gin.H{})
 }
 
 func (h *AuthHandler) GetCurrentUser(c *gin.Context) {
@@ -477,12 +802,37 @@ func (h *AuthHandler) GetCurrentUser(c *gin.Context) {
 		return
 	}
 
-	utils.SuccessResponse(c, http.StatusOK, user)
+	// Permissions let the frontend show the admin pages the user can use
+	utils.SuccessResponse(c, http.StatusOK, struct {
+		database.User
+		Permissions []rbac.Permission `json:"permissions"`
+	}{user, rbac.Permissions(user.Role)})
 }
 
-// Logout clears the session cookie
+// Logout revokes the access token it was called with and ends its refresh
+// session, then clears the cookies
 func (h *AuthHandler) Logout(c *gin.Context) {
-	utils.ClearSessionCookie(c)
+	ctx := context.Background()
+	userID, _ := c.Get("user_id")
+
+	if tokenID := c.GetString("token_id"); tokenID != "" {
+		expiresAt, _ := c.Get("token_expires_at")
+		if expiry, ok := expiresAt.(time.Time); ok {
+			if err := h.sessionStore.DenyToken(ctx, tokenID, expiry); err != nil {
+				utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke token")
+				return
+			}
+		}
+	}
+
+	if sessionID := c.GetString("session_id"); sessionID != "" {
+		if err := h.sessionStore.RevokeSession(ctx, userID.(uuid.UUID), sessionID, h.cfg.JWTExpiration); err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke session")
+			return
+		}
+	}
+
+	utils.ClearAuthCookies(c)
 	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/chatshare/backend/internal/config"
 	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/notifications"
+	"github.com/chatshare/backend/internal/providers"
+	"github.com/chatshare/backend/internal/screening"
 	"github.com/chatshare/backend/internal/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -14,12 +19,91 @@ import (
 )
 
 type ChatHandler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db       *gorm.DB
+	cfg      *config.Config
+	notifier *notifications.Notifier
+	screener *screening.Screener
 }
 
-func NewChatHandler(db *gorm.DB, cfg *config.Config) *ChatHandler {
-	return &ChatHandler{db: db, cfg: cfg}
+func NewChatHandler(db *gorm.DB, cfg *config.Config, screener *screening.Screener) *ChatHandler {
+	return &ChatHandler{db: db, cfg: cfg, notifier: notifications.NewNotifier(db), screener: screener}
+}
+
+// chatKeyset orders chat listings newest first
+var chatKeyset = keysetColumns{createdAt: "chats.created_at", id: "chats.id"}
+
+func chatCursor(chat database.Chat) utils.Cursor {
+	return utils.Cursor{CreatedAt: chat.CreatedAt, ID: chat.ID}
+}
+
+// liveLinkScoreExpr is 1 for chats whose public link works and 0 for dead ones
+const liveLinkScoreExpr = "(CASE WHEN chats.is_link_valid THEN 1 ELSE 0 END)"
+
+// liveLinkKeyset orders chat listings with dead links last, then newest first
+var liveLinkKeyset = keysetColumns{score: liveLinkScoreExpr, createdAt: "chats.created_at", id: "chats.id"}
+
+func liveLinkCursor(chat database.Chat) utils.Cursor {
+	score := 0.0
+	if chat.IsLinkValid {
+		score = 1
+	}
+	return utils.Cursor{Score: &score, CreatedAt: chat.CreatedAt, ID: chat.ID}
+}
+
+// providerErrorMessage explains why a public link was rejected
+func providerErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, providers.ErrUnsupportedHost):
+		var names []string
+		for _, p := range providers.List() {
+			names = append(names, p.DisplayName)
+		}
+		return "Unsupported chat provider. Supported providers: " + strings.Join(names, ", ")
+	case errors.Is(err, providers.ErrInvalidShareLink):
+		return "Invalid public link: " + err.Error()
+	default:
+		return "Invalid public link"
+	}
+}
+
+// findDuplicate looks for another chat with the same share, matching either
+// the provider's share ID or the link itself (for rows not yet backfilled)
+func (h *ChatHandler) findDuplicate(match *providers.Match, rawLink string, excludeID uuid.UUID) (*database.Chat, bool) {
+	var existing database.Chat
+	err := h.db.Where("(chat_type = ? AND share_id = ?) OR public_link IN ?",
+		match.Provider.Name, match.ShareID, []string{match.CanonicalURL, rawLink}).
+		Where("id <> ?", excludeID).
+		First(&existing).Error
+	if err != nil {
+		return nil, false
+	}
+	return &existing, true
+}
+
+// addChatKeywords tags a chat, creating keywords that do not exist yet
+func addChatKeywords(db *gorm.DB, chatID uuid.UUID, names []string) {
+	for _, keywordName := range names {
+		var keyword database.Keyword
+		err := db.Where("name = ?", keywordName).First(&keyword).Error
+		if err == gorm.ErrRecordNotFound {
+			keyword = database.Keyword{
+				ID:   uuid.New(),
+				Name: keywordName,
+				Slug: keywordName,
+			}
+			db.Create(&keyword)
+		}
+
+		chatKeyword := database.ChatKeyword{
+			ID:        uuid.New(),
+			ChatID:    chatID,
+			KeywordID: keyword.ID,
+		}
+		db.Create(&chatKeyword)
+
+		keyword.UsageCount++
+		db.Save(&keyword)
+	}
 }
 
 // syncChatCounts updates the favorite_count for a chat based on actual records
@@ -45,7 +129,6 @@ func (h *ChatHandler) CreateChat(c *gin.Context) {
 		CategoryID  uuid.UUID `json:"category_id"`
 		Keywords    []string  `json:"keywords"`
 		IsPublic    bool      `json:"is_public"`
-		ChatType    string    `json:"chat_type"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -53,17 +136,29 @@ func (h *ChatHandler) CreateChat(c *gin.Context) {
 		return
 	}
 
-	// Check if public link already exists
-	var existing database.Chat
-	if err := h.db.Where("public_link = ?", req.PublicLink).First(&existing).Error; err == nil {
-		utils.ErrorResponse(c, http.StatusConflict, "Public link already exists")
+	// Identify the chat provider from the link
+	match, err := providers.Resolve(req.PublicLink)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, providerErrorMessage(err))
 		return
 	}
 
-	// Auto-detect chat type from URL if not provided
-	chatType := req.ChatType
-	if chatType == "" {
-		chatType = utils.DetectChatTypeFromURL(req.PublicLink)
+	// Check if the same share has already been posted
+	if existing, found := h.findDuplicate(match, req.PublicLink, uuid.Nil); found {
+		utils.ErrorResponseWithData(c, http.StatusConflict, "Public link already exists", gin.H{
+			"existing_chat_id": existing.ID,
+		})
+		return
+	}
+
+	status, flagReason, ok := screenContent(c, h.screener, screening.Content{
+		Kind:   screening.KindChat,
+		UserID: userID.(uuid.UUID),
+		Title:  req.Title,
+		Body:   req.Description,
+	})
+	if !ok {
+		return
 	}
 
 	chat := database.Chat{
@@ -72,11 +167,13 @@ func (h *ChatHandler) CreateChat(c *gin.Context) {
 		CategoryID:  req.CategoryID,
 		Title:       req.Title,
 		Description: req.Description,
-		PublicLink:  req.PublicLink,
-		ChatType:    chatType,
+		PublicLink:  match.CanonicalURL,
+		ChatType:    match.Provider.Name,
+		ShareID:     match.ShareID,
 		IsPublic:    req.IsPublic,
 		IsLinkValid: true,
-		Status:      "active",
+		Status:      status,
+		FlagReason:  flagReason,
 	}
 
 	if err := h.db.Create(&chat).Error; err != nil {
@@ -85,29 +182,16 @@ func (h *ChatHandler) CreateChat(c *gin.Context) {
 	}
 
 	// Add keywords
-	if len(req.Keywords) > 0 {
-		for _, keywordName := range req.Keywords {
-			var keyword database.Keyword
-			err := h.db.Where("name = ?", keywordName).First(&keyword).Error
-			if err == gorm.ErrRecordNotFound {
-				keyword = database.Keyword{
-					ID:   uuid.New(),
-					Name: keywordName,
-					Slug: keywordName,
-				}
-				h.db.Create(&keyword)
-			}
+	addChatKeywords(h.db, chat.ID, req.Keywords)
 
-			chatKeyword := database.ChatKeyword{
-				ID:        uuid.New(),
-				ChatID:    chat.ID,
-				KeywordID: keyword.ID,
-			}
-			h.db.Create(&chatKeyword)
+	// Index title, description and keywords for full-text search
+	if err := database.RefreshChatSearchVector(h.db, chat.ID); err != nil {
+		log.Printf("Failed to index chat %s for search: %v", chat.ID, err)
+	}
 
-			keyword.UsageCount++
-			h.db.Save(&keyword)
-		}
+	// Flagged chats are announced if a moderator approves them
+	if chat.Status == "active" {
+		h.notifier.ChatPublished(&chat)
 	}
 
 	utils.SuccessResponse(c, http.StatusCreated, chat)
@@ -153,11 +237,17 @@ func (h *ChatHandler) GetChat(c *gin.Context) {
 }
 
 func (h *ChatHandler) ListChats(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(h.cfg.DefaultPageSize)))
+	// Chats whose public link the health checker found to be dead can be
+	// pushed to the end of the listing
+	keyset, cursorOf := chatKeyset, chatCursor
+	if c.Query("demote_dead_links") == "true" {
+		keyset, cursorOf = liveLinkKeyset, liveLinkCursor
+	}
 
-	if pageSize > h.cfg.MaxPageSize {
-		pageSize = h.cfg.MaxPageSize
+	params, err := parseListParams(c, h.cfg, keyset)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid cursor")
+		return
 	}
 
 	query := h.db.Model(&database.Chat{}).Where("is_public = ? AND status = ?", true, "active")
@@ -183,6 +273,11 @@ func (h *ChatHandler) ListChats(c *gin.Context) {
 		query = query.Where("title ILIKE ? OR description ILIKE ?", "%"+search+"%", "%"+search+"%")
 	}
 
+	// Hide chats whose public link the health checker found to be dead
+	if c.Query("hide_dead_links") == "true" {
+		query = query.Where("is_link_valid = ?", true)
+	}
+
 	// Filter by favorites (requires authentication)
 	if favorite := c.Query("favorite"); favorite == "true" {
 		userID, exists := c.Get("user_id")
@@ -198,16 +293,18 @@ func (h *ChatHandler) ListChats(c *gin.Context) {
 
 	// Count total
 	var total int64
-	query.Count(&total)
+	if params.includeTotal {
+		query.Count(&total)
+	}
 
 	// Pagination
-	offset := (page - 1) * pageSize
 	var chats []database.Chat
-	if err := query.Select("chats.*").Preload("User").Preload("Category").Offset(offset).Limit(pageSize).
-		Order("created_at DESC").Find(&chats).Error; err != nil {
+	if err := params.apply(query.Select("chats.*").Preload("User").Preload("Category"), keyset).
+		Find(&chats).Error; err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch chats")
 		return
 	}
+	chats, hasMore := trimPage(params, chats)
 
 	// Mark chats as favorited if user is logged in
 	userID, exists := c.Get("user_id")
@@ -232,7 +329,7 @@ func (h *ChatHandler) ListChats(c *gin.Context) {
 		}
 	}
 
-	utils.PaginatedSuccessResponse(c, http.StatusOK, chats, page, pageSize, total)
+	respondList(c, params, chats, hasMore, total, cursorOf)
 }
 
 func (h *ChatHandler) UpdateChat(c *gin.Context) {
@@ -268,10 +365,29 @@ func (h *ChatHandler) UpdateChat(c *gin.Context) {
 		return
 	}
 
+	textChanged := (req.Title != "" && req.Title != chat.Title) || req.Description != chat.Description
 	if req.Title != "" {
 		chat.Title = req.Title
 	}
 	chat.Description = req.Description
+	if textChanged {
+		status, flagReason, ok := screenContent(c, h.screener, screening.Content{
+			Kind:   screening.KindChat,
+			ID:     chat.ID,
+			UserID: chat.UserID,
+			Title:  chat.Title,
+			Body:   chat.Description,
+			Edit:   true,
+		})
+		if !ok {
+			return
+		}
+		// An edit can hold a chat for review, but never lifts a moderator's decision
+		if status == "flagged" && chat.Status == "active" {
+			chat.Status = status
+			chat.FlagReason = flagReason
+		}
+	}
 	if req.CategoryID != nil && *req.CategoryID != uuid.Nil {
 		chat.CategoryID = *req.CategoryID
 	}
@@ -279,15 +395,55 @@ func (h *ChatHandler) UpdateChat(c *gin.Context) {
 	if req.IsPublic != nil {
 		chat.IsPublic = *req.IsPublic
 	}
-	if req.PublicLink != "" {
-		chat.PublicLink = req.PublicLink
+	linkChanged := false
+	if req.PublicLink != "" && req.PublicLink != chat.PublicLink {
+		match, err := providers.Resolve(req.PublicLink)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, providerErrorMessage(err))
+			return
+		}
+		if existing, found := h.findDuplicate(match, req.PublicLink, chat.ID); found {
+			utils.ErrorResponseWithData(c, http.StatusConflict, "Public link already exists", gin.H{
+				"existing_chat_id": existing.ID,
+			})
+			return
+		}
+		if match.CanonicalURL != chat.PublicLink {
+			// A new link has not been checked yet
+			chat.IsLinkValid = true
+			chat.LinkCheckedAt = nil
+			chat.LinkAttemptedAt = nil
+			chat.LinkError = ""
+
+			// The stored conversation belongs to the old link; the importer
+			// fetches the new one
+			chat.Source = "link"
+			chat.MessageCount = 0
+			chat.TranscriptFetchedAt = nil
+			chat.TranscriptError = ""
+			linkChanged = true
+		}
+		chat.PublicLink = match.CanonicalURL
+		chat.ChatType = match.Provider.Name
+		chat.ShareID = match.ShareID
 	}
 
-	if err := h.db.Save(&chat).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if linkChanged {
+			if err := tx.Where("chat_id = ?", chat.ID).Delete(&database.ChatMessage{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Save(&chat).Error
+	}); err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update chat")
 		return
 	}
 
+	if err := database.RefreshChatSearchVector(h.db, chat.ID); err != nil {
+		log.Printf("Failed to index chat %s for search: %v", chat.ID, err)
+	}
+
 	// Preload user and category for response
 	h.db.Preload("User").Preload("Category").First(&chat, "id = ?", chat.ID)
 
@@ -363,6 +519,8 @@ func (h *ChatHandler) AddFavorite(c *gin.Context) {
 		"favorite_count": count,
 	})
 
+	h.notifier.ChatFavorited(&chat, favorite.UserID)
+
 	utils.MessageResponse(c, http.StatusCreated, "Chat favorited successfully")
 }
 
@@ -1,23 +1,40 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 
+	"github.com/chatshare/backend/internal/audit"
 	"github.com/chatshare/backend/internal/config"
 	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/mailer"
+	"github.com/chatshare/backend/internal/notifications"
+	"github.com/chatshare/backend/internal/rbac"
+	"github.com/chatshare/backend/internal/screening"
 	"github.com/chatshare/backend/internal/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+const (
+	// MaxCommentDepth is the deepest a reply can be nested below a top-level comment
+	MaxCommentDepth = 5
+
+	// DeletedCommentPlaceholder replaces the content of deleted comments that still have replies
+	DeletedCommentPlaceholder = "[deleted]"
+)
+
 type CommentHandler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db       *gorm.DB
+	cfg      *config.Config
+	notifier *notifications.Notifier
+	emails   *mailer.Sender
+	screener *screening.Screener
 }
 
-func NewCommentHandler(db *gorm.DB, cfg *config.Config) *CommentHandler {
-	return &CommentHandler{db: db, cfg: cfg}
+func NewCommentHandler(db *gorm.DB, cfg *config.Config, emails *mailer.Sender, screener *screening.Screener) *CommentHandler {
+	return &CommentHandler{db: db, cfg: cfg, notifier: notifications.NewNotifier(db), emails: emails, screener: screener}
 }
 
 func (h *CommentHandler) CreateComment(c *gin.Context) {
@@ -45,12 +62,22 @@ func (h *CommentHandler) CreateComment(c *gin.Context) {
 		return
 	}
 
+	status, flagReason, ok := screenContent(c, h.screener, screening.Content{
+		Kind:   screening.KindComment,
+		UserID: userID.(uuid.UUID),
+		Body:   req.Content,
+	})
+	if !ok {
+		return
+	}
+
 	comment := database.Comment{
-		ID:      uuid.New(),
-		ChatID:  chatID,
-		UserID:  userID.(uuid.UUID),
-		Content: req.Content,
-		Status:  "active",
+		ID:         uuid.New(),
+		ChatID:     chatID,
+		UserID:     userID.(uuid.UUID),
+		Content:    req.Content,
+		Status:     status,
+		FlagReason: flagReason,
 	}
 
 	if err := h.db.Create(&comment).Error; err != nil {
@@ -58,13 +85,104 @@ func (h *CommentHandler) CreateComment(c *gin.Context) {
 		return
 	}
 
-	// Update comment count
-	chat.CommentCount++
-	h.db.Save(&chat)
+	syncCommentCount(h.db, chat.ID)
+
+	// Flagged comments stay quiet until a moderator approves them
+	if comment.Status == "active" {
+		h.notifier.ChatCommented(&chat, &comment)
+		go h.emails.CommentPosted(context.Background(), chat, comment)
+	}
 
 	utils.SuccessResponse(c, http.StatusCreated, comment)
 }
 
+// CreateReply adds a reply to an existing comment on the same chat
+// POST /api/v1/chats/:id/comments/:commentId/replies
+func (h *CommentHandler) CreateReply(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	chatIDStr := c.Param("id")
+	chatID, err := uuid.Parse(chatIDStr)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid chat ID")
+		return
+	}
+
+	parentIDStr := c.Param("commentId")
+	parentID, err := uuid.Parse(parentIDStr)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid comment ID")
+		return
+	}
+
+	var req struct {
+		Content string `json:"content" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	var chat database.Chat
+	if err := h.db.First(&chat, "id = ?", chatID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Chat not found")
+		return
+	}
+
+	var parent database.Comment
+	if err := h.db.First(&parent, "id = ? AND chat_id = ?", parentID, chatID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Comment not found")
+		return
+	}
+
+	if parent.Status != "active" {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Cannot reply to this comment")
+		return
+	}
+
+	if parent.Depth+1 > MaxCommentDepth {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Maximum reply depth reached")
+		return
+	}
+
+	status, flagReason, ok := screenContent(c, h.screener, screening.Content{
+		Kind:   screening.KindComment,
+		UserID: userID.(uuid.UUID),
+		Body:   req.Content,
+	})
+	if !ok {
+		return
+	}
+
+	reply := database.Comment{
+		ID:         uuid.New(),
+		ChatID:     chatID,
+		UserID:     userID.(uuid.UUID),
+		ParentID:   &parent.ID,
+		Depth:      parent.Depth + 1,
+		Content:    req.Content,
+		Status:     status,
+		FlagReason: flagReason,
+	}
+
+	if err := h.db.Create(&reply).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create reply")
+		return
+	}
+
+	syncCommentCount(h.db, chat.ID)
+
+	if reply.Status == "active" {
+		h.notifier.CommentReplied(&chat, &parent, &reply)
+		go h.emails.CommentPosted(context.Background(), chat, reply)
+	}
+
+	utils.SuccessResponse(c, http.StatusCreated, reply)
+}
+
+// ListComments returns the comments on a chat as a tree of threads.
+// Pass format=flat to get the threads flattened in display order, with each
+// comment's depth indicating its indentation.
 func (h *CommentHandler) ListComments(c *gin.Context) {
 	chatIDStr := c.Param("id")
 	chatID, err := uuid.Parse(chatIDStr)
@@ -73,16 +191,36 @@ func (h *CommentHandler) ListComments(c *gin.Context) {
 		return
 	}
 
+	// Deleted comments are kept as placeholders so their replies stay attached
 	var comments []database.Comment
-	if err := h.db.Preload("User").Where("chat_id = ? AND status = ?", chatID, "active").
-		Order("created_at DESC").Find(&comments).Error; err != nil {
+	if err := h.db.Preload("User").Where("chat_id = ? AND status IN ?", chatID, []string{"active", "deleted"}).
+		Order("created_at ASC").Find(&comments).Error; err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch comments")
 		return
 	}
 
-	utils.SuccessResponse(c, http.StatusOK, comments)
+	for i := range comments {
+		if comments[i].Status == "deleted" {
+			comments[i].Content = DeletedCommentPlaceholder
+			comments[i].UserID = uuid.Nil
+			comments[i].User = database.User{}
+		}
+	}
+
+	threads := buildCommentTree(comments)
+
+	if c.Query("format") == "flat" {
+		utils.SuccessResponse(c, http.StatusOK, flattenCommentTree(threads))
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, threads)
 }
 
+// DeleteComment removes a comment. A comment that still has replies is
+// replaced by a placeholder so the thread below it is preserved. Moderators
+// deleting someone else's comment can give a reason query parameter, which
+// is recorded in the audit log.
 func (h *CommentHandler) DeleteComment(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	commentIDStr := c.Param("commentId")
@@ -98,24 +236,140 @@ func (h *CommentHandler) DeleteComment(c *gin.Context) {
 		return
 	}
 
-	if comment.UserID != userID.(uuid.UUID) {
-		utils.ErrorResponse(c, http.StatusForbidden, "Not authorized to delete this comment")
+	// Moderators can remove anyone's comment, but only when signed in: like
+	// the rest of moderation it is out of reach of API tokens
+	moderated := comment.UserID != userID.(uuid.UUID)
+	if moderated {
+		_, isAPIToken := c.Get("api_token_id")
+		if isAPIToken || !rbac.Can(c.GetString("user_role"), rbac.PermCommentsModerate) {
+			utils.ErrorResponse(c, http.StatusForbidden, "Not authorized to delete this comment")
+			return
+		}
+	}
+
+	if comment.Status == "deleted" {
+		utils.ErrorResponse(c, http.StatusNotFound, "Comment not found")
 		return
 	}
 
-	if err := h.db.Delete(&comment).Error; err != nil {
+	var replyCount int64
+	h.db.Model(&database.Comment{}).Where("parent_id = ?", comment.ID).Count(&replyCount)
+
+	before := comment
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if replyCount > 0 {
+			if err := tx.Model(&comment).Updates(map[string]interface{}{
+				"content": DeletedCommentPlaceholder,
+				"status":  "deleted",
+			}).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Delete(&comment).Error; err != nil {
+			return err
+		}
+
+		if !moderated {
+			return nil
+		}
+		return audit.Record(tx, auditActor(c), audit.Entry{
+			Action:       audit.ActionCommentDelete,
+			TargetType:   audit.TargetComment,
+			TargetID:     comment.ID,
+			TargetUserID: &comment.UserID,
+			Before:       before,
+			Reason:       c.Query("reason"),
+		})
+	}); err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to delete comment")
 		return
 	}
 
-	// Update comment count
-	var chat database.Chat
-	if err := h.db.First(&chat, "id = ?", comment.ChatID).Error; err == nil {
-		if chat.CommentCount > 0 {
-			chat.CommentCount--
-			h.db.Save(&chat)
-		}
+	if replyCount == 0 {
+		h.pruneDeletedAncestors(comment.ParentID)
 	}
 
+	syncCommentCount(h.db, comment.ChatID)
+
 	utils.MessageResponse(c, http.StatusOK, "Comment deleted successfully")
 }
+
+// syncCommentCount recounts the comments shown on a chat. Only active
+// comments count; flagged, hidden and removed ones and deleted placeholders
+// do not.
+func syncCommentCount(tx *gorm.DB, chatID uuid.UUID) error {
+	return tx.Exec(`
+		UPDATE chats SET comment_count = (
+			SELECT COUNT(*) FROM comments
+			WHERE chat_id = ? AND status = 'active' AND deleted_at IS NULL
+		) WHERE id = ?`, chatID, chatID).Error
+}
+
+// pruneDeletedAncestors removes placeholder comments that no longer have any
+// replies, walking up the thread from parentID
+func (h *CommentHandler) pruneDeletedAncestors(parentID *uuid.UUID) {
+	for parentID != nil {
+		var parent database.Comment
+		if err := h.db.First(&parent, "id = ?", *parentID).Error; err != nil {
+			return
+		}
+		if parent.Status != "deleted" {
+			return
+		}
+
+		var replyCount int64
+		h.db.Model(&database.Comment{}).Where("parent_id = ?", parent.ID).Count(&replyCount)
+		if replyCount > 0 {
+			return
+		}
+
+		h.db.Delete(&parent)
+		parentID = parent.ParentID
+	}
+}
+
+// buildCommentTree nests comments (ordered oldest first) under their parents.
+// Top-level threads are returned newest first; replies stay oldest first.
+// Replies whose parent is not visible are promoted to the top level.
+func buildCommentTree(comments []database.Comment) []database.Comment {
+	children := make(map[uuid.UUID][]database.Comment)
+	visible := make(map[uuid.UUID]bool, len(comments))
+	for _, comment := range comments {
+		visible[comment.ID] = true
+	}
+
+	var roots []database.Comment
+	for _, comment := range comments {
+		if comment.ParentID != nil && visible[*comment.ParentID] {
+			children[*comment.ParentID] = append(children[*comment.ParentID], comment)
+		} else {
+			roots = append(roots, comment)
+		}
+	}
+
+	var attach func(comment database.Comment) database.Comment
+	attach = func(comment database.Comment) database.Comment {
+		for _, child := range children[comment.ID] {
+			comment.Replies = append(comment.Replies, attach(child))
+		}
+		return comment
+	}
+
+	threads := make([]database.Comment, 0, len(roots))
+	for i := len(roots) - 1; i >= 0; i-- {
+		threads = append(threads, attach(roots[i]))
+	}
+
+	return threads
+}
+
+// flattenCommentTree returns the comments of a tree in depth-first order
+func flattenCommentTree(threads []database.Comment) []database.Comment {
+	flat := make([]database.Comment, 0, len(threads))
+	for _, comment := range threads {
+		replies := comment.Replies
+		comment.Replies = nil
+		flat = append(flat, comment)
+		flat = append(flat, flattenCommentTree(replies)...)
+	}
+	return flat
+}
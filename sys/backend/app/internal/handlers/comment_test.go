@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chatshare/backend/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func deleteCommentAs(t *testing.T, role string, viaAPIToken bool) int {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, mock := newMockDB(t)
+	handler := NewCommentHandler(db, &config.Config{}, nil, nil)
+
+	commentID := uuid.New()
+	mock.ExpectQuery(`SELECT \* FROM "comments" WHERE id = \$1`).
+		WithArgs(commentID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "chat_id", "user_id", "content", "status"}).
+			AddRow(commentID, uuid.New(), uuid.New(), "Someone else's comment", "active"))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodDelete, "/", nil)
+	c.Params = gin.Params{{Key: "commentId", Value: commentID.String()}}
+	c.Set("user_id", uuid.New())
+	c.Set("user_role", role)
+	if viaAPIToken {
+		c.Set("api_token_id", uuid.New())
+	}
+
+	handler.DeleteComment(c)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	return w.Code
+}
+
+func TestDeleteCommentOfAnotherUser(t *testing.T) {
+	if code := deleteCommentAs(t, "user", false); code != http.StatusForbidden {
+		t.Errorf("user: status = %d, want 403", code)
+	}
+	// Moderation goes through a signed-in session, never an API token
+	if code := deleteCommentAs(t, "moderator", true); code != http.StatusForbidden {
+		t.Errorf("moderator's API token: status = %d, want 403", code)
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/mailer"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type EmailHandler struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+func NewEmailHandler(db *gorm.DB, cfg *config.Config) *EmailHandler {
+	return &EmailHandler{db: db, cfg: cfg}
+}
+
+// unsubscribeToken reads the signed token from the query string or, for
+// form posts from an unsubscribe page, the form body
+func (h *EmailHandler) unsubscribeToken(c *gin.Context) (email, list string, err error) {
+	token := c.Query("token")
+	if token == "" {
+		token = c.PostForm("token")
+	}
+	return mailer.ParseUnsubscribeToken(h.cfg.UnsubscribeSecret, token)
+}
+
+// GetUnsubscribe describes what an unsubscribe link will do, so the
+// frontend can ask for confirmation. No login is needed.
+// GET /api/v1/email/unsubscribe?token=...
+func (h *EmailHandler) GetUnsubscribe(c *gin.Context) {
+	email, list, err := h.unsubscribeToken(c)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid unsubscribe link")
+		return
+	}
+
+	var count int64
+	h.db.Model(&database.EmailSuppression{}).Where("email = ? AND list IN ?", email, []string{list, mailer.ListAll}).Count(&count)
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{
+		"email":        email,
+		"list":         list,
+		"unsubscribed": count > 0,
+	})
+}
+
+// Unsubscribe removes the address in a signed token from a mailing list. It
+// also serves one-click unsubscribes (RFC 8058) sent by mail clients.
+// POST /api/v1/email/unsubscribe?token=...
+func (h *EmailHandler) Unsubscribe(c *gin.Context) {
+	email, list, err := h.unsubscribeToken(c)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid unsubscribe link")
+		return
+	}
+
+	suppression := database.EmailSuppression{
+		ID:    uuid.New(),
+		Email: email,
+		List:  list,
+	}
+	if err := h.db.Where("email = ? AND list = ?", email, list).FirstOrCreate(&suppression).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to unsubscribe")
+		return
+	}
+
+	utils.MessageResponse(c, http.StatusOK, "Unsubscribed successfully")
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"firebase.google.com/go/v4/auth"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errFirebaseEmailTaken = errors.New("email belongs to another account")
+
+// firebaseProviders maps Firebase sign-in providers to the providers
+// ChatShare signs in with directly, so a Firebase user federated through one
+// of them finds the account created by the OAuth flow. oidc.line is LINE set
+// up as an OpenID Connect provider named "line".
+var firebaseProviders = map[string]string{
+	"google.com": "google",
+	"oidc.line":  "line",
+}
+
+// FirebaseSignIn exchanges a Firebase ID token for ChatShare tokens. Apps
+// that sign in with Firebase Auth (Apple, email link, anonymous, ...) use it
+// instead of the OAuth callbacks. The response also carries firebase_token, a
+// custom token for the same Firebase user, so the app's Firebase session
+// stays in sync.
+// POST /api/v1/auth/firebase
+func (h *AuthHandler) FirebaseSignIn(c *gin.Context) {
+	var req struct {
+		IDToken string `json:"id_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if h.firebaseAuth == nil {
+		utils.ErrorResponse(c, http.StatusServiceUnavailable, "Firebase sign-in is not configured")
+		return
+	}
+
+	ctx := context.Background()
+	token, err := h.firebaseAuth.VerifyIDToken(ctx, req.IDToken)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "Invalid or expired Firebase ID token")
+		return
+	}
+
+	profile := firebaseProfile(token)
+	if err := h.linkFirebaseIdentity(token, profile); err != nil {
+		if errors.Is(err, errFirebaseEmailTaken) {
+			utils.ErrorResponse(c, http.StatusConflict, "An account with this email already exists. Sign in to it and link this account instead.")
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to process user")
+		return
+	}
+
+	user, err := h.findOrCreateUser(ctx, profile)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to process user")
+		return
+	}
+
+	// Firebase Auth is used by the mobile apps
+	c.Set("mobile_sdk", true)
+
+	tokens, err := h.startSession(c, user, "firebase")
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+
+	data := gin.H{"user": user}
+
+	customToken, err := h.firebaseAuth.CreateCustomToken(ctx, token.UID, map[string]interface{}{
+		"chatshare_uid": user.ID.String(),
+	})
+	if err != nil {
+		// The ChatShare session works without it
+		log.Printf("Failed to create Firebase custom token for %s: %v", token.UID, err)
+	} else {
+		data["firebase_token"] = customToken
+	}
+
+	h.respondWithTokens(c, tokens, data)
+}
+
+// firebaseProfile reads the account details of a verified Firebase ID token.
+// Anonymous users have no email, so they get a placeholder like LINE users.
+func firebaseProfile(token *auth.Token) *oauthProfile {
+	claim := func(name string) string {
+		value, _ := token.Claims[name].(string)
+		return value
+	}
+
+	profile := &oauthProfile{
+		Provider:   "firebase",
+		ProviderID: token.UID,
+		Email:      claim("email"),
+		Name:       claim("name"),
+		Avatar:     claim("picture"),
+	}
+	profile.EmailVerified, _ = token.Claims["email_verified"].(bool)
+
+	if profile.Email == "" {
+		profile.Email = placeholderEmail(profile.Provider, profile.ProviderID)
+		profile.EmailVerified = false
+	}
+
+	return profile
+}
+
+// linkFirebaseIdentity attaches a Firebase user seen for the first time to
+// the ChatShare user it belongs to: the one linked to the same provider
+// account, or else the one with the same verified email. Without a match
+// findOrCreateUser creates a new user.
+func (h *AuthHandler) linkFirebaseIdentity(token *auth.Token, profile *oauthProfile) error {
+	var count int64
+	if err := h.db.Model(&database.UserIdentity{}).
+		Where("provider = ? AND provider_id = ?", profile.Provider, profile.ProviderID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	userID, err := h.matchFirebaseUser(token, profile)
+	if err != nil || userID == uuid.Nil {
+		return err
+	}
+
+	return h.db.Create(newUserIdentity(userID, profile)).Error
+}
+
+// matchFirebaseUser finds the existing user a new Firebase user belongs to,
+// or returns uuid.Nil. An unverified email that matches another user is
+// refused, since anyone can claim an address.
+func (h *AuthHandler) matchFirebaseUser(token *auth.Token, profile *oauthProfile) (uuid.UUID, error) {
+	for firebaseProvider, provider := range firebaseProviders {
+		ids, _ := token.Firebase.Identities[firebaseProvider].([]interface{})
+		for _, id := range ids {
+			providerID, ok := id.(string)
+			if !ok {
+				continue
+			}
+
+			var identity database.UserIdentity
+			err := h.db.Where("provider = ? AND provider_id = ?", provider, providerID).First(&identity).Error
+			if err == nil {
+				return identity.UserID, nil
+			}
+			if err != gorm.ErrRecordNotFound {
+				return uuid.Nil, err
+			}
+		}
+	}
+
+	var user database.User
+	err := h.db.Where("LOWER(email) = LOWER(?)", profile.Email).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if !profile.EmailVerified {
+		return uuid.Nil, errFirebaseEmailTaken
+	}
+	return user.ID, nil
+}
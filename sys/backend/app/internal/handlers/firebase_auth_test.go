@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/v4/auth"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/userstate"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeFirebase stands in for the Firebase Admin SDK
+type fakeFirebase struct {
+	token       *auth.Token
+	customToken string
+	customErr   error
+}
+
+func (f *fakeFirebase) VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error) {
+	if idToken != "valid-id-token" {
+		return nil, errors.New("invalid token")
+	}
+	return f.token, nil
+}
+
+func (f *fakeFirebase) CreateCustomToken(ctx context.Context, uid string, claims map[string]interface{}) (string, error) {
+	return f.customToken, f.customErr
+}
+
+type firebaseTest struct {
+	handler *AuthHandler
+	mock    sqlmock.Sqlmock
+	redis   *miniredis.Miniredis
+}
+
+// newMockDB returns a database whose queries are answered by the mock
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger:                 logger.Default.LogMode(logger.Silent),
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, mock
+}
+
+func newFirebaseTest(t *testing.T, firebase *fakeFirebase) *firebaseTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, mock := newMockDB(t)
+
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+
+	cfg := &config.Config{
+		JWTSecret:              "jwt-secret",
+		JWTExpiration:          15 * time.Minute,
+		RefreshTokenExpiration: 24 * time.Hour,
+		FrontendURL:            "http://localhost:3000",
+		APIBaseURL:             "http://localhost:8080",
+	}
+
+	handler := NewAuthHandler(db, cfg, utils.NewSessionStore(redisClient), firebase,
+		userstate.NewStore(db, redisClient))
+	return &firebaseTest{handler: handler, mock: mock, redis: redisServer}
+}
+
+// activeUser caches an active state for the user, as the auth middleware
+// would have, so starting the session needs no database query
+func (ft *firebaseTest) activeUser(t *testing.T, userID uuid.UUID) {
+	t.Helper()
+	ft.redis.Set(userstate.StatePrefix+userID.String(), `{"status":"active","role":"user"}`)
+}
+
+func (ft *firebaseTest) signIn(t *testing.T) (int, map[string]interface{}) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/auth/firebase",
+		strings.NewReader(`{"id_token": "valid-id-token"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	ft.handler.FirebaseSignIn(c)
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+
+	if err := ft.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	return w.Code, body.Data
+}
+
+func userRow(id uuid.UUID, email, provider string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "email", "provider", "provider_id", "role", "status"}).
+		AddRow(id, email, provider, provider+"-id", "user", "active")
+}
+
+func identityRow(userID uuid.UUID, provider, providerID string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "user_id", "provider", "provider_id"}).
+		AddRow(uuid.New(), userID, provider, providerID)
+}
+
+func countRow(count int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"count"}).AddRow(count)
+}
+
+// expectReturningUser expects findOrCreateUser to find the user through
+// their Firebase identity and record the sign-in
+func expectReturningUser(mock sqlmock.Sqlmock, userID uuid.UUID, uid, email, provider string) {
+	mock.ExpectQuery(`SELECT \* FROM "user_identities" WHERE provider = \$1 AND provider_id = \$2`).
+		WithArgs("firebase", uid).
+		WillReturnRows(identityRow(userID, "firebase", uid))
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE id = \$1`).
+		WillReturnRows(userRow(userID, email, provider))
+	mock.ExpectExec(`UPDATE "users"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "user_identities"`).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestFirebaseSignInMatchesLinkedProviderUID(t *testing.T) {
+	userID := uuid.New()
+	ft := newFirebaseTest(t, &fakeFirebase{
+		token: &auth.Token{
+			UID:    "firebase-uid",
+			Claims: map[string]interface{}{"email": "ada@example.com", "email_verified": true},
+			Firebase: auth.FirebaseInfo{
+				SignInProvider: "google.com",
+				Identities:     map[string]interface{}{"google.com": []interface{}{"google-sub"}},
+			},
+		},
+		customToken: "custom-token",
+	})
+	ft.activeUser(t, userID)
+
+	ft.mock.ExpectQuery(`SELECT count\(\*\) FROM "user_identities"`).
+		WithArgs("firebase", "firebase-uid").
+		WillReturnRows(countRow(0))
+	// The Google account inside the Firebase user was linked by the OAuth flow
+	ft.mock.ExpectQuery(`SELECT \* FROM "user_identities" WHERE provider = \$1 AND provider_id = \$2`).
+		WithArgs("google", "google-sub").
+		WillReturnRows(identityRow(userID, "google", "google-sub"))
+	ft.mock.ExpectQuery(`INSERT INTO "user_identities"`).
+		WithArgs(userID, "firebase", "firebase-uid", "ada@example.com", sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	expectReturningUser(ft.mock, userID, "firebase-uid", "ada@example.com", "google")
+
+	code, data := ft.signIn(t)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if user, _ := data["user"].(map[string]interface{}); user["id"] != userID.String() {
+		t.Errorf("signed in as %v, want %s", user["id"], userID)
+	}
+	if data["firebase_token"] != "custom-token" {
+		t.Errorf("firebase_token = %v, want custom-token", data["firebase_token"])
+	}
+	if data["token"] == nil || data["refresh_token"] == nil {
+		t.Error("response has no ChatShare tokens")
+	}
+}
+
+func TestFirebaseSignInMatchesVerifiedEmail(t *testing.T) {
+	userID := uuid.New()
+	ft := newFirebaseTest(t, &fakeFirebase{
+		token: &auth.Token{
+			UID:      "apple-firebase-uid",
+			Claims:   map[string]interface{}{"email": "Ada@Example.com", "email_verified": true},
+			Firebase: auth.FirebaseInfo{SignInProvider: "apple.com"},
+		},
+		customToken: "custom-token",
+	})
+	ft.activeUser(t, userID)
+
+	ft.mock.ExpectQuery(`SELECT count\(\*\) FROM "user_identities"`).
+		WithArgs("firebase", "apple-firebase-uid").
+		WillReturnRows(countRow(0))
+	ft.mock.ExpectQuery(`SELECT \* FROM "users" WHERE LOWER\(email\) = LOWER\(\$1\)`).
+		WithArgs("Ada@Example.com").
+		WillReturnRows(userRow(userID, "ada@example.com", "google"))
+	ft.mock.ExpectQuery(`INSERT INTO "user_identities"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	expectReturningUser(ft.mock, userID, "apple-firebase-uid", "ada@example.com", "google")
+
+	code, data := ft.signIn(t)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if user, _ := data["user"].(map[string]interface{}); user["id"] != userID.String() {
+		t.Errorf("signed in as %v, want %s", user["id"], userID)
+	}
+}
+
+func TestFirebaseSignInRefusesUnverifiedEmailOfAnotherAccount(t *testing.T) {
+	ft := newFirebaseTest(t, &fakeFirebase{
+		token: &auth.Token{
+			UID:      "password-firebase-uid",
+			Claims:   map[string]interface{}{"email": "ada@example.com", "email_verified": false},
+			Firebase: auth.FirebaseInfo{SignInProvider: "password"},
+		},
+	})
+
+	ft.mock.ExpectQuery(`SELECT count\(\*\) FROM "user_identities"`).
+		WithArgs("firebase", "password-firebase-uid").
+		WillReturnRows(countRow(0))
+	ft.mock.ExpectQuery(`SELECT \* FROM "users" WHERE LOWER\(email\) = LOWER\(\$1\)`).
+		WillReturnRows(userRow(uuid.New(), "ada@example.com", "google"))
+
+	code, data := ft.signIn(t)
+	if code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", code)
+	}
+	if data != nil {
+		t.Errorf("refused sign-in returned data: %v", data)
+	}
+}
+
+func TestFirebaseSignInWithoutCustomToken(t *testing.T) {
+	userID := uuid.New()
+	ft := newFirebaseTest(t, &fakeFirebase{
+		token: &auth.Token{
+			UID:      "firebase-uid",
+			Claims:   map[string]interface{}{"email": "ada@example.com", "email_verified": true},
+			Firebase: auth.FirebaseInfo{SignInProvider: "apple.com"},
+		},
+		customErr: errors.New("service account cannot sign tokens"),
+	})
+	ft.activeUser(t, userID)
+
+	// The Firebase user signed in before
+	ft.mock.ExpectQuery(`SELECT count\(\*\) FROM "user_identities"`).
+		WithArgs("firebase", "firebase-uid").
+		WillReturnRows(countRow(1))
+	expectReturningUser(ft.mock, userID, "firebase-uid", "ada@example.com", "firebase")
+
+	code, data := ft.signIn(t)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if _, ok := data["firebase_token"]; ok {
+		t.Error("response has a firebase_token although minting it failed")
+	}
+	if data["token"] == nil {
+		t.Error("sign-in failed without the custom token")
+	}
+}
+
+func TestFirebaseSignInRejectsInvalidIDToken(t *testing.T) {
+	ft := newFirebaseTest(t, &fakeFirebase{})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/auth/firebase",
+		strings.NewReader(`{"id_token": "forged"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	ft.handler.FirebaseSignIn(c)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", w.Code)
+	}
+}
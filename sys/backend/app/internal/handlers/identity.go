@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/chatshare/backend/internal/audit"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/userstate"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ListIdentities returns the provider accounts the user can sign in with
+// GET /api/v1/user/identities
+func (h *AuthHandler) ListIdentities(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var identities []database.UserIdentity
+	if err := h.db.Where("user_id = ?", userID).Order("created_at ASC").Find(&identities).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch identities")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, identities)
+}
+
+// LinkIdentity adds a provider account to the signed-in user. The client
+// runs the provider's usual sign-in flow and sends the resulting code here
+// instead of to the callback. If the provider account already belongs to
+// another user, the response is 409 with a merge_token that
+// POST /api/v1/user/merge accepts.
+// POST /api/v1/user/identities/:provider
+func (h *AuthHandler) LinkIdentity(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var req struct {
+		Code         string `json:"code" binding:"required"`
+		State        string `json:"state"`         // required for LINE and the Google web flow
+		CodeVerifier string `json:"code_verifier"` // LINE, if the URL was requested with code_challenge
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	ctx := context.Background()
+
+	var profile *oauthProfile
+	var err error
+	switch c.Param("provider") {
+	case "google":
+		profile, err = h.googleProfile(ctx, req.Code, req.State)
+	case "line":
+		if req.State == "" {
+			utils.ErrorResponse(c, http.StatusBadRequest, "State is required")
+			return
+		}
+		profile, err = h.lineProfile(ctx, req.Code, req.State, req.CodeVerifier)
+	default:
+		provider := h.oidcProviders[c.Param("provider")]
+		if provider == nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Unsupported provider")
+			return
+		}
+		profile, err = h.oidcProfile(ctx, provider, req.Code, req.State)
+	}
+	if err != nil {
+		respondOAuthError(c, err)
+		return
+	}
+
+	var existing database.UserIdentity
+	err = h.db.Where("provider = ? AND provider_id = ?", profile.Provider, profile.ProviderID).First(&existing).Error
+	if err == nil {
+		if existing.UserID == userID {
+			utils.ErrorResponse(c, http.StatusConflict, "This account is already linked")
+			return
+		}
+		h.offerMerge(c, userID, existing.UserID, profile.Provider)
+		return
+	}
+	if err != gorm.ErrRecordNotFound {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to link account")
+		return
+	}
+
+	identity := newUserIdentity(userID, profile)
+	if err := h.db.Create(identity).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to link account")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusCreated, identity)
+}
+
+// offerMerge answers a link attempt for a provider account owned by another
+// user. Completing the provider sign-in proved the caller controls that
+// account too, so they may merge it into the current one.
+func (h *AuthHandler) offerMerge(c *gin.Context, targetID, sourceID uuid.UUID, provider string) {
+	var source database.User
+	if err := h.db.First(&source, "id = ?", sourceID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to link account")
+		return
+	}
+
+	token, err := h.sessionStore.CreateMergeRequest(context.Background(), &utils.MergeRequest{
+		TargetUserID: targetID,
+		SourceUserID: sourceID,
+	})
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to link account")
+		return
+	}
+
+	utils.ErrorResponseWithData(c, http.StatusConflict,
+		fmt.Sprintf("This %s account belongs to another ChatShare account", provider),
+		gin.H{
+			"merge_token": token,
+			"expires_in":  int(utils.MergeTokenExpiration.Seconds()),
+			"account": gin.H{
+				"id":     source.ID,
+				"name":   source.Name,
+				"avatar": source.Avatar,
+			},
+		})
+}
+
+// UnlinkIdentity removes a provider account from the user. The last one
+// cannot be removed since the user could no longer sign in.
+// DELETE /api/v1/user/identities/:id
+func (h *AuthHandler) UnlinkIdentity(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	identityID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid identity ID")
+		return
+	}
+
+	var identities []database.UserIdentity
+	if err := h.db.Where("user_id = ?", userID).Order("created_at ASC").Find(&identities).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch identities")
+		return
+	}
+
+	var removed *database.UserIdentity
+	var remaining []database.UserIdentity
+	for i := range identities {
+		if identities[i].ID == identityID {
+			removed = &identities[i]
+		} else {
+			remaining = append(remaining, identities[i])
+		}
+	}
+	if removed == nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Identity not found")
+		return
+	}
+	if len(remaining) == 0 {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Cannot unlink the only sign-in method")
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(removed).Error; err != nil {
+			return err
+		}
+
+		// The account was created with this identity; hand that role to the
+		// oldest remaining one
+		return tx.Model(&database.User{}).
+			Where("id = ? AND provider = ? AND provider_id = ?", userID, removed.Provider, removed.ProviderID).
+			Updates(map[string]interface{}{
+				"provider":    remaining[0].Provider,
+				"provider_id": remaining[0].ProviderID,
+			}).Error
+	}); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to unlink account")
+		return
+	}
+
+	utils.MessageResponse(c, http.StatusOK, "Account unlinked")
+}
+
+// MergeAccount moves everything owned by another account into the signed-in
+// user and deletes the other account. The merge token comes from a
+// LinkIdentity attempt that returned 409 and is valid once.
+// POST /api/v1/user/merge
+func (h *AuthHandler) MergeAccount(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var req struct {
+		MergeToken string `json:"merge_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	ctx := context.Background()
+	merge, err := h.sessionStore.ConsumeMergeRequest(ctx, req.MergeToken)
+	if errors.Is(err, utils.ErrMergeTokenInvalid) || (err == nil && merge.TargetUserID != userID) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid or expired merge token")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to merge accounts")
+		return
+	}
+
+	var source database.User
+	if err := h.db.First(&source, "id = ?", merge.SourceUserID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Account to merge not found")
+		return
+	}
+
+	// Merging must not lift a suspension
+	if userstate.FromUser(&source).Check(time.Now()) != nil {
+		utils.ErrorResponse(c, http.StatusForbidden, "The other account is not active and cannot be merged")
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := mergeUsers(tx, source.ID, userID); err != nil {
+			return err
+		}
+		return recordMerge(tx, auditActor(c), &source, userID)
+	}); err != nil {
+		log.Printf("MergeAccount transaction error merging %s into %s: %v", source.ID, userID, err)
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to merge accounts")
+		return
+	}
+
+	// Sign the merged account out everywhere
+	if _, err := h.sessionStore.RevokeAllSessions(ctx, source.ID, "", h.cfg.JWTExpiration); err != nil {
+		log.Printf("Failed to revoke sessions of merged user %s: %v", source.ID, err)
+	}
+	if err := h.userStates.Invalidate(ctx, source.ID); err != nil {
+		log.Printf("Failed to clear cached state of merged user %s: %v", source.ID, err)
+	}
+
+	var user database.User
+	if err := h.db.Preload("Identities").First(&user, "id = ?", userID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch user")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, user)
+}
+
+// recordMerge records in the audit log that source, and the accounts merged
+// into it before, now belong to targetID. The log cannot be changed, so the
+// moderation history of the target includes them through these entries.
+func recordMerge(tx *gorm.DB, actor audit.Actor, source *database.User, targetID uuid.UUID) error {
+	var earlierIDs []uuid.UUID
+	if err := tx.Model(&database.AuditLog{}).
+		Where("action = ? AND target_user_id = ?", audit.ActionUserMerge, source.ID).
+		Pluck("target_id", &earlierIDs).Error; err != nil {
+		return err
+	}
+
+	if err := audit.Record(tx, actor, audit.Entry{
+		Action:       audit.ActionUserMerge,
+		TargetType:   audit.TargetUser,
+		TargetID:     source.ID,
+		TargetUserID: &targetID,
+		Before:       source,
+	}); err != nil {
+		return err
+	}
+	for _, id := range earlierIDs {
+		if err := audit.Record(tx, actor, audit.Entry{
+			Action:       audit.ActionUserMerge,
+			TargetType:   audit.TargetUser,
+			TargetID:     id,
+			TargetUserID: &targetID,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeUsers reassigns the chats, favorites, comments, follows, views,
+// shares, notifications and identities of one user to another, then deletes
+// the first. Favorites and follows both accounts had are kept once.
+func mergeUsers(tx *gorm.DB, sourceID, targetID uuid.UUID) error {
+	// Chats, comments (including deleted ones), views and shares simply change owner
+	if err := tx.Unscoped().Model(&database.Chat{}).Where("user_id = ?", sourceID).Update("user_id", targetID).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Model(&database.Comment{}).Where("user_id = ?", sourceID).Update("user_id", targetID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&database.View{}).Where("user_id = ?", sourceID).Update("user_id", targetID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&database.Share{}).Where("user_id = ?", sourceID).Update("user_id", targetID).Error; err != nil {
+		return err
+	}
+
+	// Favorites of chats both accounts favorited are dropped and counted once
+	var duplicateChatIDs []uuid.UUID
+	if err := tx.Model(&database.Favorite{}).
+		Where("user_id = ? AND chat_id IN (?)", sourceID,
+			tx.Model(&database.Favorite{}).Select("chat_id").Where("user_id = ?", targetID)).
+		Pluck("chat_id", &duplicateChatIDs).Error; err != nil {
+		return err
+	}
+	if len(duplicateChatIDs) > 0 {
+		if err := tx.Where("user_id = ? AND chat_id IN ?", sourceID, duplicateChatIDs).Delete(&database.Favorite{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&database.Chat{}).Where("id IN ?", duplicateChatIDs).
+			Update("favorite_count", gorm.Expr("GREATEST(favorite_count - 1, 0)")).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(&database.Favorite{}).Where("user_id = ?", sourceID).Update("user_id", targetID).Error; err != nil {
+		return err
+	}
+
+	// Follows between the two accounts, and ones both accounts have, are dropped
+	if err := tx.Where("(user_id = ? AND target_user_id = ?) OR (user_id = ? AND target_user_id = ?)",
+		sourceID, targetID, targetID, sourceID).Delete(&database.FavoriteUser{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ? AND target_user_id IN (?)", sourceID,
+		tx.Model(&database.FavoriteUser{}).Select("target_user_id").Where("user_id = ?", targetID)).
+		Delete(&database.FavoriteUser{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("target_user_id = ? AND user_id IN (?)", sourceID,
+		tx.Model(&database.FavoriteUser{}).Select("user_id").Where("target_user_id = ?", targetID)).
+		Delete(&database.FavoriteUser{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&database.FavoriteUser{}).Where("user_id = ?", sourceID).Update("user_id", targetID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&database.FavoriteUser{}).Where("target_user_id = ?", sourceID).Update("target_user_id", targetID).Error; err != nil {
+		return err
+	}
+
+	// Notifications follow their recipient and actor; ones about the user's
+	// own activity no longer make sense
+	if err := tx.Model(&database.Notification{}).Where("user_id = ?", sourceID).Update("user_id", targetID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&database.Notification{}).Where("actor_id = ?", sourceID).Update("actor_id", targetID).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ? AND actor_id = ?", targetID, targetID).Delete(&database.Notification{}).Error; err != nil {
+		return err
+	}
+
+	// The merged account's sign-in methods now sign in to the target
+	if err := tx.Model(&database.UserIdentity{}).Where("user_id = ?", sourceID).Update("user_id", targetID).Error; err != nil {
+		return err
+	}
+
+	// Reports are kept once per reporter and content
+	if err := tx.Where("reporter_id = ? AND target_id IN (?)", sourceID,
+		tx.Model(&database.Report{}).Select("target_id").Where("reporter_id = ?", targetID)).
+		Delete(&database.Report{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&database.Report{}).Where("reporter_id = ?", sourceID).Update("reporter_id", targetID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&database.Report{}).Where("target_user_id = ?", sourceID).Update("target_user_id", targetID).Error; err != nil {
+		return err
+	}
+
+	// API tokens were issued to the merged account only
+	if err := tx.Where("user_id = ?", sourceID).Delete(&database.APIToken{}).Error; err != nil {
+		return err
+	}
+
+	// Remove the merged account permanently so its email and provider ID can
+	// be reused
+	return tx.Unscoped().Where("id = ?", sourceID).Delete(&database.User{}).Error
+}
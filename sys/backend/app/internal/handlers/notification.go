@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type NotificationHandler struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+func NewNotificationHandler(db *gorm.DB, cfg *config.Config) *NotificationHandler {
+	return &NotificationHandler{db: db, cfg: cfg}
+}
+
+// notificationKeyset orders notifications newest first
+var notificationKeyset = keysetColumns{createdAt: "notifications.created_at", id: "notifications.id"}
+
+func (h *NotificationHandler) unreadCount(userID interface{}) int64 {
+	var count int64
+	h.db.Model(&database.Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count)
+	return count
+}
+
+// ListNotifications returns the user's notifications, newest first, with
+// the unread count in meta. Pass unread=true to list only unread ones.
+// GET /api/v1/user/notifications
+func (h *NotificationHandler) ListNotifications(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	params, err := parseListParams(c, h.cfg, notificationKeyset)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	query := h.db.Model(&database.Notification{}).Where("user_id = ?", userID)
+	if c.Query("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var total int64
+	if params.includeTotal {
+		query.Count(&total)
+	}
+
+	var notifications []database.Notification
+	if err := params.apply(query.Preload("Actor").Preload("Chat"), notificationKeyset).
+		Find(&notifications).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch notifications")
+		return
+	}
+
+	notifications, hasMore := trimPage(params, notifications)
+	respondListWithMeta(c, params, notifications, hasMore, total, func(n database.Notification) utils.Cursor {
+		return utils.Cursor{CreatedAt: n.CreatedAt, ID: n.ID}
+	}, gin.H{"unread_count": h.unreadCount(userID)})
+}
+
+// GetUnreadCount returns the number of unread notifications
+// GET /api/v1/user/notifications/unread-count
+func (h *NotificationHandler) GetUnreadCount(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"unread_count": h.unreadCount(userID)})
+}
+
+// MarkRead marks one of the user's notifications as read
+// PUT /api/v1/user/notifications/:id/read
+func (h *NotificationHandler) MarkRead(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	notificationIDStr := c.Param("id")
+	notificationID, err := uuid.Parse(notificationIDStr)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid notification ID")
+		return
+	}
+
+	var notification database.Notification
+	if err := h.db.First(&notification, "id = ? AND user_id = ?", notificationID, userID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Notification not found")
+		return
+	}
+
+	if notification.ReadAt == nil {
+		now := time.Now()
+		if err := h.db.Model(&notification).Update("read_at", now).Error; err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update notification")
+			return
+		}
+		notification.ReadAt = &now
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, notification)
+}
+
+// MarkAllRead marks every unread notification of the user as read
+// PUT /api/v1/user/notifications/read-all
+func (h *NotificationHandler) MarkAllRead(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	result := h.db.Model(&database.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update notifications")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"marked_read": result.RowsAffected})
+}
+
+// GetPreferences returns which notification types the user receives
+// GET /api/v1/user/notifications/preferences
+func (h *NotificationHandler) GetPreferences(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var user database.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, user.NotificationPreferences)
+}
+
+// UpdatePreferences turns notification types on or off. Omitted types are
+// left unchanged.
+// PUT /api/v1/user/notifications/preferences
+func (h *NotificationHandler) UpdatePreferences(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req struct {
+		Comment  *bool `json:"comment"`
+		Reply    *bool `json:"reply"`
+		Favorite *bool `json:"favorite"`
+		NewChat  *bool `json:"new_chat"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	var user database.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Comment != nil {
+		updates["notify_comment"] = *req.Comment
+	}
+	if req.Reply != nil {
+		updates["notify_reply"] = *req.Reply
+	}
+	if req.Favorite != nil {
+		updates["notify_favorite"] = *req.Favorite
+	}
+	if req.NewChat != nil {
+		updates["notify_new_chat"] = *req.NewChat
+	}
+
+	if len(updates) > 0 {
+		if err := h.db.Model(&user).Updates(updates).Error; err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update preferences")
+			return
+		}
+		h.db.First(&user, "id = ?", userID)
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, user.NotificationPreferences)
+}
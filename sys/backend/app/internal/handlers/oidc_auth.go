@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/chatshare/backend/internal/oidc"
+	"github.com/chatshare/backend/internal/userstate"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// builtinLoginProviders have their own routes and cannot be configured as
+// OIDC providers
+var builtinLoginProviders = map[string]bool{
+	"google":   true,
+	"line":     true,
+	"firebase": true,
+}
+
+// ListLoginProviders returns the providers users can sign in with, for
+// building the login page
+// GET /api/v1/auth/providers
+func (h *AuthHandler) ListLoginProviders(c *gin.Context) {
+	type loginProvider struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+	}
+
+	providers := []loginProvider{}
+	if h.cfg.GoogleClientID != "" {
+		providers = append(providers, loginProvider{Name: "google", DisplayName: "Google"})
+	}
+	if h.cfg.LINEChannelID != "" {
+		providers = append(providers, loginProvider{Name: "line", DisplayName: "LINE"})
+	}
+
+	var names []string
+	for name := range h.oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		providers = append(providers, loginProvider{Name: name, DisplayName: h.oidcProviders[name].DisplayName()})
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, providers)
+}
+
+// OIDCLogin starts a sign-in with a provider configured in OIDC_PROVIDERS
+// and redirects to it
+// GET /api/v1/auth/:provider/redirect
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	provider := h.oidcProviders[c.Param("provider")]
+	if provider == nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Unknown login provider")
+		return
+	}
+
+	// Generate state token and the nonce that ties the ID token to it
+	state, err := utils.GenerateRandomString(32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate state token")
+		return
+	}
+	nonce, err := utils.GenerateRandomString(32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate nonce")
+		return
+	}
+
+	stateData := &utils.OAuthState{
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: oauth2.GenerateVerifier(),
+	}
+
+	ctx := context.Background()
+	if err := h.sessionStore.StoreStateData(ctx, state, stateData); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to store state")
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state,
+		oauth2.SetAuthURLParam("nonce", nonce),
+		oauth2.S256ChallengeOption(stateData.CodeVerifier),
+	)
+	if err != nil {
+		log.Printf("OIDC provider %s unavailable: %v", provider.Name(), err)
+		utils.ErrorResponse(c, http.StatusBadGateway, "Login provider is unavailable")
+		return
+	}
+
+	utils.SetOAuthStateCookie(c, state, oidcCallbackPath(provider), h.cfg.Environment == "production")
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// oidcCallbackPath is the path the state cookie of a provider is scoped to
+func oidcCallbackPath(provider *oidc.Provider) string {
+	return "/api/v1/auth/" + provider.Name() + "/callback"
+}
+
+// OIDCCallback completes a sign-in with a provider configured in
+// OIDC_PROVIDERS. A JSON request (the frontend relaying code and state)
+// gets the tokens in the response like the other callbacks. A browser sent
+// here by the provider, by redirect or form post, gets the tokens as
+// cookies and is sent on to the frontend.
+// GET /api/v1/auth/:provider/callback
+// POST /api/v1/auth/:provider/callback
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	provider := h.oidcProviders[c.Param("provider")]
+	if provider == nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Unknown login provider")
+		return
+	}
+
+	browser := c.ContentType() != "application/json"
+
+	fail := func(status int, message string) {
+		if browser {
+			query := url.Values{"provider": {provider.Name()}, "error": {message}}
+			c.Redirect(http.StatusFound, h.cfg.FrontendURL+"/login?"+query.Encode())
+			return
+		}
+		utils.ErrorResponse(c, status, message)
+	}
+
+	var req struct {
+		Code  string `json:"code" form:"code"`
+		State string `json:"state" form:"state"`
+		Error string `json:"error" form:"error"`
+	}
+
+	if err := c.ShouldBind(&req); err != nil {
+		fail(http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Error != "" {
+		fail(http.StatusBadRequest, "Sign-in was cancelled or refused by the provider")
+		return
+	}
+	if req.Code == "" || req.State == "" {
+		fail(http.StatusBadRequest, "Missing code or state")
+		return
+	}
+
+	// A browser must be the one that started the sign-in, or a link to the
+	// callback of someone else's sign-in would log it into their account
+	if browser {
+		cookie, _ := c.Cookie(utils.OAuthStateCookieName)
+		utils.ClearOAuthStateCookie(c, oidcCallbackPath(provider))
+		if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(req.State)) != 1 {
+			fail(http.StatusBadRequest, "Invalid or expired state token")
+			return
+		}
+	}
+
+	ctx := context.Background()
+	profile, err := h.oidcProfile(ctx, provider, req.Code, req.State)
+	if err != nil {
+		var oauthErr *oauthError
+		if errors.As(err, &oauthErr) {
+			fail(oauthErr.status, oauthErr.message)
+			return
+		}
+		fail(http.StatusInternalServerError, "Failed to process user")
+		return
+	}
+
+	user, err := h.findOrCreateUser(ctx, profile)
+	if err != nil {
+		fail(http.StatusInternalServerError, "Failed to process user")
+		return
+	}
+
+	if !browser {
+		h.respondWithSession(c, user, provider.Name())
+		return
+	}
+
+	tokens, err := h.startSession(c, user, provider.Name())
+	if err != nil {
+		var accountErr *userstate.AccountError
+		if errors.As(err, &accountErr) {
+			fail(http.StatusForbidden, accountErr.Message)
+			return
+		}
+		fail(http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+	if _, err := h.setAuthCookies(c, tokens); err != nil {
+		fail(http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+
+	c.Redirect(http.StatusFound, h.cfg.FrontendURL)
+}
+
+// oidcProfile completes a sign-in with a generic provider and returns the
+// signed-in account
+func (h *AuthHandler) oidcProfile(ctx context.Context, provider *oidc.Provider, code, state string) (*oauthProfile, error) {
+	stateData, err := h.sessionStore.ConsumeState(ctx, state)
+	if err != nil {
+		return nil, &oauthError{http.StatusInternalServerError, "Failed to validate state"}
+	}
+	if stateData == nil || stateData.Provider != provider.Name() {
+		return nil, &oauthError{http.StatusBadRequest, "Invalid or expired state token"}
+	}
+
+	identity, err := provider.Exchange(ctx, code, stateData.Nonce, oauth2.VerifierOption(stateData.CodeVerifier))
+	if err != nil {
+		log.Printf("OIDC sign-in with %s failed: %v", provider.Name(), err)
+		return nil, &oauthError{http.StatusUnauthorized, "Failed to sign in with " + provider.DisplayName()}
+	}
+
+	profile := &oauthProfile{
+		Provider:      provider.Name(),
+		ProviderID:    identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Name:          identity.Name,
+		Avatar:        identity.Picture,
+	}
+	if profile.Email == "" {
+		profile.Email = placeholderEmail(profile.Provider, profile.ProviderID)
+		profile.EmailVerified = false
+	}
+
+	return profile, nil
+}
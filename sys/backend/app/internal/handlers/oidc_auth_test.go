@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// newOIDCTest serves the OIDC routes for a provider named mock whose token
+// endpoint refuses every code
+func newOIDCTest(t *testing.T) (*gin.Engine, *miniredis.Miniredis) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+	}))
+	t.Cleanup(issuer.Close)
+
+	redisServer := miniredis.RunT(t)
+	sessions := utils.NewSessionStore(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}))
+
+	cfg := &config.Config{
+		FrontendURL: "https://chatshare.test",
+		OIDCProviders: []config.OIDCProviderConfig{{
+			Name:        "mock",
+			DisplayName: "Mock",
+			ClientID:    "client-id",
+			AuthURL:     issuer.URL + "/authorize",
+			TokenURL:    issuer.URL + "/token",
+			UserInfoURL: issuer.URL + "/userinfo",
+		}},
+	}
+	handler := NewAuthHandler(nil, cfg, sessions, nil, nil)
+
+	router := gin.New()
+	router.GET("/api/v1/auth/:provider/redirect", handler.OIDCLogin)
+	router.GET("/api/v1/auth/:provider/callback", handler.OIDCCallback)
+	return router, redisServer
+}
+
+// startOIDCLogin starts a sign-in and returns its state and state cookie
+func startOIDCLogin(t *testing.T, router *gin.Engine) (string, *http.Cookie) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/auth/mock/redirect", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("redirect status = %d, want 302", w.Code)
+	}
+
+	location, _ := url.Parse(w.Header().Get("Location"))
+	state := location.Query().Get("state")
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == utils.OAuthStateCookieName {
+			if cookie.Value != state || !cookie.HttpOnly || cookie.Path != "/api/v1/auth/mock/callback" {
+				t.Errorf("state cookie = %+v", cookie)
+			}
+			return state, cookie
+		}
+	}
+	t.Fatal("sign-in set no state cookie")
+	return "", nil
+}
+
+func oidcCallbackError(t *testing.T, router *gin.Engine, state string, cookie *http.Cookie) string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/mock/callback?code=code&state="+url.QueryEscape(state), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusFound || !strings.HasPrefix(location, "https://chatshare.test/login?") {
+		t.Fatalf("callback answered %d, Location %q; want a redirect to the login page", w.Code, location)
+	}
+	redirect, _ := url.Parse(location)
+	return redirect.Query().Get("error")
+}
+
+func TestOIDCCallbackRequiresTheBrowserThatStartedTheSignIn(t *testing.T) {
+	router, redisServer := newOIDCTest(t)
+	state, _ := startOIDCLogin(t, router)
+
+	// The attacker's own callback link, opened in the victim's browser
+	if msg := oidcCallbackError(t, router, state, nil); msg != "Invalid or expired state token" {
+		t.Errorf("error = %q", msg)
+	}
+	if msg := oidcCallbackError(t, router, state, &http.Cookie{Name: utils.OAuthStateCookieName, Value: "other-state"}); msg != "Invalid or expired state token" {
+		t.Errorf("error with another sign-in's cookie = %q", msg)
+	}
+	if !redisServer.Exists(utils.StateTokenPrefix + state) {
+		t.Error("a refused callback used up the state")
+	}
+}
+
+func TestOIDCCallbackWithStateCookie(t *testing.T) {
+	router, redisServer := newOIDCTest(t)
+	state, cookie := startOIDCLogin(t, router)
+
+	// The state matches, so the code is redeemed, which the provider refuses
+	if msg := oidcCallbackError(t, router, state, cookie); msg != "Failed to sign in with Mock" {
+		t.Errorf("error = %q", msg)
+	}
+	if redisServer.Exists(utils.StateTokenPrefix + state) {
+		t.Error("state was not used up")
+	}
+}
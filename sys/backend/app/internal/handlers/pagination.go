@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// listParams holds the pagination options of a list request. Clients either
+// page by number (page, page_size) or by keyset: passing cursor (empty for the
+// first page) switches to keyset mode, where next_cursor is returned instead
+// of page numbers and the total is only counted with include_total=true.
+type listParams struct {
+	page         int
+	pageSize     int
+	useCursor    bool
+	cursor       *utils.Cursor
+	includeTotal bool
+}
+
+// keysetColumns names the columns a listing is ordered by, newest first.
+// score is optional and sorts ahead of created_at when set.
+type keysetColumns struct {
+	score     string
+	createdAt string
+	id        string
+}
+
+func parseListParams(c *gin.Context, cfg *config.Config, keys keysetColumns) (listParams, error) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(cfg.DefaultPageSize)))
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = cfg.DefaultPageSize
+	}
+	if pageSize > cfg.MaxPageSize {
+		pageSize = cfg.MaxPageSize
+	}
+
+	params := listParams{page: page, pageSize: pageSize, includeTotal: true}
+
+	rawCursor, useCursor := c.GetQuery("cursor")
+	if !useCursor {
+		return params, nil
+	}
+
+	params.useCursor = true
+	params.includeTotal = c.Query("include_total") == "true"
+
+	if rawCursor != "" {
+		cursor, err := utils.DecodeCursor(rawCursor)
+		if err != nil {
+			return params, err
+		}
+		if (keys.score != "") != (cursor.Score != nil) {
+			return params, utils.ErrInvalidCursor
+		}
+		params.cursor = cursor
+	}
+
+	return params, nil
+}
+
+// apply adds ordering and the page window to query. In keyset mode one extra
+// row is fetched so trimPage can tell whether another page follows.
+func (p listParams) apply(query *gorm.DB, keys keysetColumns) *gorm.DB {
+	order := fmt.Sprintf("%s DESC, %s DESC", keys.createdAt, keys.id)
+	if keys.score != "" {
+		order = fmt.Sprintf("%s DESC, %s", keys.score, order)
+	}
+	query = query.Order(order)
+
+	if !p.useCursor {
+		return query.Offset((p.page - 1) * p.pageSize).Limit(p.pageSize)
+	}
+
+	if p.cursor != nil {
+		if keys.score != "" {
+			query = query.Where(fmt.Sprintf("(%s, %s, %s) < (?, ?, ?)", keys.score, keys.createdAt, keys.id),
+				*p.cursor.Score, p.cursor.CreatedAt, p.cursor.ID)
+		} else {
+			query = query.Where(fmt.Sprintf("(%s, %s) < (?, ?)", keys.createdAt, keys.id),
+				p.cursor.CreatedAt, p.cursor.ID)
+		}
+	}
+
+	return query.Limit(p.pageSize + 1)
+}
+
+// trimPage drops the look-ahead row fetched in keyset mode and reports
+// whether there are more rows after this page
+func trimPage[T any](p listParams, items []T) ([]T, bool) {
+	if p.useCursor && len(items) > p.pageSize {
+		return items[:p.pageSize], true
+	}
+	return items, false
+}
+
+// respondList writes a paginated response in the mode the client asked for.
+// cursorOf returns the keyset position of an item.
+func respondList[T any](c *gin.Context, p listParams, items []T, hasMore bool, total int64, cursorOf func(T) utils.Cursor) {
+	respondListWithMeta(c, p, items, hasMore, total, cursorOf, nil)
+}
+
+// respondListWithMeta is respondList with extra listing-wide data in meta
+func respondListWithMeta[T any](c *gin.Context, p listParams, items []T, hasMore bool, total int64, cursorOf func(T) utils.Cursor, meta interface{}) {
+	var pagination utils.Pagination
+	if !p.useCursor {
+		pagination = utils.OffsetPagination(p.page, p.pageSize, total)
+	} else {
+		nextCursor := ""
+		if hasMore && len(items) > 0 {
+			nextCursor = utils.EncodeCursor(cursorOf(items[len(items)-1]))
+		}
+
+		var totalPtr *int64
+		if p.includeTotal {
+			totalPtr = &total
+		}
+		pagination = utils.CursorPagination(p.pageSize, nextCursor, totalPtr)
+	}
+
+	c.JSON(http.StatusOK, utils.PaginatedResponse{
+		Success:    true,
+		Data:       items,
+		Pagination: pagination,
+		Meta:       meta,
+	})
+}
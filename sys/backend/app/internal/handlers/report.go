@@ -0,0 +1,434 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/chatshare/backend/internal/audit"
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/notifications"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Report targets
+const (
+	ReportTargetChat    = "chat"
+	ReportTargetComment = "comment"
+)
+
+// ReportReasons are the categories users pick from when reporting content
+var ReportReasons = []string{"spam", "harassment", "hate", "sexual", "violence", "misinformation", "copyright", "other"}
+
+// ReportFlagReason is the flag reason of content flagged by reports rather
+// than by screening or a moderator. Dismissing its reports restores it.
+const ReportFlagReason = "Held for review after several reports"
+
+var errAlreadyReported = errors.New("already reported")
+
+type ReportHandler struct {
+	db       *gorm.DB
+	cfg      *config.Config
+	notifier *notifications.Notifier
+}
+
+func NewReportHandler(db *gorm.DB, cfg *config.Config) *ReportHandler {
+	return &ReportHandler{db: db, cfg: cfg, notifier: notifications.NewNotifier(db)}
+}
+
+// reportKeyset orders the moderation queue newest first
+var reportKeyset = keysetColumns{createdAt: "reports.created_at", id: "reports.id"}
+
+// ReportChat reports a chat to the moderators
+// POST /api/v1/chats/:id/report
+func (h *ReportHandler) ReportChat(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	chatID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid chat ID")
+		return
+	}
+
+	var chat database.Chat
+	if err := h.db.First(&chat, "id = ?", chatID).Error; err != nil || chat.Status == "removed" ||
+		(!chat.IsPublic && chat.UserID != userID) {
+		utils.ErrorResponse(c, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if chat.UserID == userID {
+		utils.ErrorResponse(c, http.StatusBadRequest, "You cannot report your own chat")
+		return
+	}
+
+	h.fileReport(c, &database.Report{
+		ReporterID:   userID,
+		TargetType:   ReportTargetChat,
+		TargetID:     chat.ID,
+		TargetUserID: chat.UserID,
+		ChatID:       chat.ID,
+	})
+}
+
+// ReportComment reports a comment to the moderators
+// POST /api/v1/comments/:id/report
+func (h *ReportHandler) ReportComment(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	commentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid comment ID")
+		return
+	}
+
+	var comment database.Comment
+	if err := h.db.First(&comment, "id = ?", commentID).Error; err != nil ||
+		(comment.Status != "active" && comment.Status != "flagged") {
+		utils.ErrorResponse(c, http.StatusNotFound, "Comment not found")
+		return
+	}
+	if comment.UserID == userID {
+		utils.ErrorResponse(c, http.StatusBadRequest, "You cannot report your own comment")
+		return
+	}
+
+	h.fileReport(c, &database.Report{
+		ReporterID:   userID,
+		TargetType:   ReportTargetComment,
+		TargetID:     comment.ID,
+		TargetUserID: comment.UserID,
+		ChatID:       comment.ChatID,
+		CommentID:    &comment.ID,
+	})
+}
+
+// fileReport records a report with the reason from the request body and
+// flags the content once enough users have reported it
+func (h *ReportHandler) fileReport(c *gin.Context, report *database.Report) {
+	var req struct {
+		Reason  string `json:"reason" binding:"required"`
+		Details string `json:"details" binding:"max=1000"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || !validReportReason(req.Reason) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request: reason must be one of the report reasons")
+		return
+	}
+	report.Reason = req.Reason
+	report.Details = req.Details
+	report.Status = "open"
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var existing database.Report
+		err := tx.Where("reporter_id = ? AND target_type = ? AND target_id = ?", report.ReporterID, report.TargetType, report.TargetID).
+			First(&existing).Error
+		switch {
+		case err == nil && existing.Status == "open":
+			return errAlreadyReported
+		case err == nil:
+			// Reporting again after a report was closed reopens it
+			if err := tx.Model(&existing).Updates(map[string]interface{}{
+				"reason":         report.Reason,
+				"details":        report.Details,
+				"status":         "open",
+				"resolved_by_id": nil,
+				"resolved_at":    nil,
+				"resolution":     "",
+			}).Error; err != nil {
+				return err
+			}
+			report.ID = existing.ID
+			report.CreatedAt = existing.CreatedAt
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(report)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errAlreadyReported
+			}
+		default:
+			return err
+		}
+
+		return h.flagIfReported(tx, report)
+	})
+	if errors.Is(err, errAlreadyReported) {
+		utils.ErrorResponse(c, http.StatusConflict, "You have already reported this")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to submit report")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusCreated, report)
+}
+
+// flagIfReported flags active content that has reached the report threshold,
+// hiding it until a moderator reviews it
+func (h *ReportHandler) flagIfReported(tx *gorm.DB, report *database.Report) error {
+	var open int64
+	if err := tx.Model(&database.Report{}).
+		Where("target_type = ? AND target_id = ? AND status = ?", report.TargetType, report.TargetID, "open").
+		Count(&open).Error; err != nil {
+		return err
+	}
+	if open < int64(h.cfg.ReportFlagThreshold) {
+		return nil
+	}
+
+	return updateReportedContent(tx, report, map[string]interface{}{
+		"status":      "flagged",
+		"flag_reason": ReportFlagReason,
+	}, "status = ?", "active")
+}
+
+// updateReportedContent applies updates to the content a report is about,
+// if it matches the condition query
+func updateReportedContent(tx *gorm.DB, report *database.Report, updates map[string]interface{}, query string, args ...interface{}) error {
+	if report.TargetType == ReportTargetChat {
+		return tx.Model(&database.Chat{}).
+			Where("id = ?", report.TargetID).Where(query, args...).
+			Updates(updates).Error
+	}
+
+	result := tx.Model(&database.Comment{}).
+		Where("id = ?", report.TargetID).Where(query, args...).
+		Updates(updates)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	return syncCommentCount(tx, report.ChatID)
+}
+
+// clearReportFlagReason is the flag_reason of content once a moderator has
+// decided on it: a flag left by reports is now the moderator's own
+func clearReportFlagReason() clause.Expr {
+	return gorm.Expr("CASE WHEN flag_reason = ? THEN '' ELSE flag_reason END", ReportFlagReason)
+}
+
+func validReportReason(reason string) bool {
+	for _, r := range ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// ListReports is the moderation queue. It shows open reports unless status
+// is given, with the reported content and how many open reports it has.
+// GET /api/v1/admin/reports
+func (h *ReportHandler) ListReports(c *gin.Context) {
+	params, err := parseListParams(c, h.cfg, reportKeyset)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	query := h.db.Model(&database.Report{})
+
+	if status := c.DefaultQuery("status", "open"); status != "all" {
+		query = query.Where("reports.status = ?", status)
+	}
+	if targetType := c.Query("target_type"); targetType != "" {
+		query = query.Where("reports.target_type = ?", targetType)
+	}
+	if reason := c.Query("reason"); reason != "" {
+		query = query.Where("reports.reason = ?", reason)
+	}
+	if targetID := c.Query("target_id"); targetID != "" {
+		query = query.Where("reports.target_id = ?", targetID)
+	}
+
+	var total int64
+	if params.includeTotal {
+		query.Count(&total)
+	}
+
+	var reports []database.Report
+	if err := params.apply(query.Preload("Reporter").Preload("Chat").Preload("Comment"), reportKeyset).
+		Find(&reports).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch reports")
+		return
+	}
+	reports, hasMore := trimPage(params, reports)
+
+	if err := h.countOpenReports(reports); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch reports")
+		return
+	}
+
+	respondList(c, params, reports, hasMore, total, func(report database.Report) utils.Cursor {
+		return utils.Cursor{CreatedAt: report.CreatedAt, ID: report.ID}
+	})
+}
+
+// countOpenReports fills in OpenReports for each report's content
+func (h *ReportHandler) countOpenReports(reports []database.Report) error {
+	if len(reports) == 0 {
+		return nil
+	}
+
+	targetIDs := make([]uuid.UUID, 0, len(reports))
+	for _, report := range reports {
+		targetIDs = append(targetIDs, report.TargetID)
+	}
+
+	var counts []struct {
+		TargetID uuid.UUID
+		Count    int64
+	}
+	if err := h.db.Model(&database.Report{}).
+		Select("target_id, COUNT(*) AS count").
+		Where("target_id IN ? AND status = ?", targetIDs, "open").
+		Group("target_id").
+		Scan(&counts).Error; err != nil {
+		return err
+	}
+
+	byTarget := make(map[uuid.UUID]int64, len(counts))
+	for _, count := range counts {
+		byTarget[count.TargetID] = count.Count
+	}
+	for i := range reports {
+		reports[i].OpenReports = byTarget[reports[i].TargetID]
+	}
+	return nil
+}
+
+// BulkUpdateReports closes reports. A decision is about the content, so it
+// closes every open report on the same chat or comment, and each reporter
+// is told the outcome. Resolving removes the content (or keeps it flagged
+// with content_status "flagged"); dismissing restores content that the
+// reports flagged, but not content flagged by screening or a moderator.
+// Content without open reports is left alone.
+// POST /api/v1/admin/reports/bulk
+func (h *ReportHandler) BulkUpdateReports(c *gin.Context) {
+	moderatorID := c.MustGet("user_id").(uuid.UUID)
+
+	var req struct {
+		ReportIDs     []uuid.UUID `json:"report_ids" binding:"required,min=1,max=100"`
+		Action        string      `json:"action" binding:"required,oneof=resolve dismiss"`
+		ContentStatus string      `json:"content_status" binding:"omitempty,oneof=removed flagged"`
+		Note          string      `json:"note" binding:"max=500"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	reportStatus := "dismissed"
+	contentStatus := "active"
+	if req.Action == "resolve" {
+		reportStatus = "resolved"
+		contentStatus = req.ContentStatus
+		if contentStatus == "" {
+			contentStatus = "removed"
+		}
+	}
+
+	auditAction := audit.ActionReportDismiss
+	if req.Action == "resolve" {
+		auditAction = audit.ActionReportResolve
+	}
+	actor := auditActor(c)
+
+	var closed []database.Report
+	var targets int
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var selected []database.Report
+		if err := tx.Where("id IN ?", req.ReportIDs).Find(&selected).Error; err != nil {
+			return err
+		}
+
+		seen := make(map[uuid.UUID]bool)
+		now := time.Now()
+		for i := range selected {
+			report := &selected[i]
+			if seen[report.TargetID] {
+				continue
+			}
+			seen[report.TargetID] = true
+
+			var open []database.Report
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("target_type = ? AND target_id = ? AND status = ?", report.TargetType, report.TargetID, "open").
+				Find(&open).Error; err != nil {
+				return err
+			}
+			// Already decided, by this request or an earlier one
+			if len(open) == 0 {
+				continue
+			}
+			targets++
+
+			ids := make([]uuid.UUID, 0, len(open))
+			for j := range open {
+				ids = append(ids, open[j].ID)
+				open[j].Status = reportStatus
+			}
+			if err := tx.Model(&database.Report{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+				"status":         reportStatus,
+				"resolved_by_id": moderatorID,
+				"resolved_at":    now,
+				"resolution":     req.Note,
+			}).Error; err != nil {
+				return err
+			}
+			closed = append(closed, open...)
+
+			// Dismissing only undoes the reports' own flag; resolving acts
+			// on live content
+			var err error
+			if req.Action == "resolve" {
+				err = updateReportedContent(tx, report, map[string]interface{}{
+					"status":      contentStatus,
+					"flag_reason": clearReportFlagReason(),
+				}, "status IN ?", []string{"active", "flagged"})
+			} else {
+				err = updateReportedContent(tx, report, map[string]interface{}{
+					"status":      contentStatus,
+					"flag_reason": "",
+				}, "status = ? AND flag_reason = ?", "flagged", ReportFlagReason)
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := audit.Record(tx, actor, audit.Entry{
+				Action:       auditAction,
+				TargetType:   report.TargetType,
+				TargetID:     report.TargetID,
+				TargetUserID: &report.TargetUserID,
+				After: gin.H{
+					"content_status": contentStatus,
+					"reports_closed": len(open),
+				},
+				Reason: req.Note,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update reports")
+		return
+	}
+
+	h.notifier.ReportsClosed(closed, moderatorID)
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{
+		"targets":        targets,
+		"reports_closed": len(closed),
+	})
+}
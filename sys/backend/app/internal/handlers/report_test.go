@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chatshare/backend/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// bulkUpdateReports runs BulkUpdateReports on one chat report with the
+// given open reports on the chat
+func bulkUpdateReports(t *testing.T, action string, openReports int, expect func(sqlmock.Sqlmock)) gin.H {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, mock := newMockDB(t)
+	handler := NewReportHandler(db, &config.Config{})
+
+	reportID, chatID := uuid.New(), uuid.New()
+	reportColumns := []string{"id", "reporter_id", "target_type", "target_id", "target_user_id", "chat_id", "status"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "reports" WHERE id IN \(\$1\)`).
+		WithArgs(reportID).
+		WillReturnRows(sqlmock.NewRows(reportColumns).
+			AddRow(reportID, uuid.New(), ReportTargetChat, chatID, uuid.New(), chatID, "dismissed"))
+	open := sqlmock.NewRows(reportColumns)
+	for i := 0; i < openReports; i++ {
+		open.AddRow(uuid.New(), uuid.New(), ReportTargetChat, chatID, uuid.New(), chatID, "open")
+	}
+	mock.ExpectQuery(`SELECT \* FROM "reports" WHERE target_type = \$1 AND target_id = \$2 AND status = \$3 FOR UPDATE`).
+		WithArgs(ReportTargetChat, chatID, "open").
+		WillReturnRows(open)
+	expect(mock)
+	mock.ExpectCommit()
+	if openReports > 0 {
+		mock.ExpectQuery(`INSERT INTO "notifications"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(
+		`{"report_ids":["`+reportID.String()+`"],"action":"`+action+`"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", uuid.New())
+
+	handler.BulkUpdateReports(c)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	var body struct {
+		Data gin.H `json:"data"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &body) != nil {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	return body.Data
+}
+
+func TestDismissRestoresOnlyContentFlaggedByReports(t *testing.T) {
+	data := bulkUpdateReports(t, "dismiss", 2, func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec(`UPDATE "reports" SET`).WillReturnResult(sqlmock.NewResult(0, 2))
+		// Screening and moderator flags have another reason and stay
+		mock.ExpectExec(`UPDATE "chats" SET "flag_reason"=\$1,"status"=\$2,"updated_at"=\$3 WHERE id = \$4 AND \(status = \$5 AND flag_reason = \$6\)`).
+			WithArgs("", "active", sqlmock.AnyArg(), sqlmock.AnyArg(), "flagged", ReportFlagReason).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO "audit_logs"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	})
+
+	if data["targets"] != 1.0 || data["reports_closed"] != 2.0 {
+		t.Errorf("response = %v", data)
+	}
+}
+
+func TestBulkUpdateReportsLeavesDecidedContentAlone(t *testing.T) {
+	for _, action := range []string{"dismiss", "resolve"} {
+		// No open reports: nothing to close, restore, remove or audit
+		data := bulkUpdateReports(t, action, 0, func(sqlmock.Sqlmock) {})
+
+		if data["targets"] != 0.0 || data["reports_closed"] != 0.0 {
+			t.Errorf("%s: response = %v", action, data)
+		}
+	}
+}
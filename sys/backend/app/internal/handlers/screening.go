@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/chatshare/backend/internal/audit"
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/screening"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CodeContentRejected is the error code of content refused by screening
+const CodeContentRejected = "content_rejected"
+
+// screenContent runs content through the screening rules before it is
+// saved. Rejected content gets a 422 response and ok is false; otherwise
+// status is what to save the content with ("active" or "flagged") and
+// flagReason says why it was flagged.
+func screenContent(c *gin.Context, screener *screening.Screener, content screening.Content) (status, flagReason string, ok bool) {
+	result := screener.Screen(c.Request.Context(), content)
+
+	switch result.Verdict {
+	case screening.Reject:
+		utils.ErrorResponseWithCode(c, http.StatusUnprocessableEntity, CodeContentRejected, result.Reason, nil)
+		return "", "", false
+	case screening.Flag:
+		return "flagged", result.Reason, true
+	default:
+		return "active", "", true
+	}
+}
+
+type ScreeningHandler struct {
+	db       *gorm.DB
+	cfg      *config.Config
+	screener *screening.Screener
+}
+
+func NewScreeningHandler(db *gorm.DB, cfg *config.Config, screener *screening.Screener) *ScreeningHandler {
+	return &ScreeningHandler{db: db, cfg: cfg, screener: screener}
+}
+
+// screeningRuleRequest is the body of creating or replacing a rule
+type screeningRuleRequest struct {
+	Type            string `json:"type" binding:"required,oneof=word regex links duplicate new_account"`
+	Pattern         string `json:"pattern" binding:"max=500"`
+	Threshold       int    `json:"threshold" binding:"min=0"`
+	WindowMinutes   int    `json:"window_minutes" binding:"min=0"`
+	AccountAgeHours int    `json:"account_age_hours" binding:"min=0"`
+	Action          string `json:"action" binding:"required,oneof=flag reject"`
+	AppliesTo       string `json:"applies_to" binding:"omitempty,oneof=all chat comment"`
+	IsActive        *bool  `json:"is_active"` // defaults to true
+	Note            string `json:"note" binding:"max=500"`
+}
+
+// apply copies the request onto rule and checks that the rule can run
+func (req *screeningRuleRequest) apply(rule *database.ScreeningRule) error {
+	rule.Type = req.Type
+	rule.Pattern = req.Pattern
+	rule.Threshold = req.Threshold
+	rule.WindowMinutes = req.WindowMinutes
+	rule.AccountAgeHours = req.AccountAgeHours
+	rule.Action = req.Action
+	rule.AppliesTo = req.AppliesTo
+	if rule.AppliesTo == "" {
+		rule.AppliesTo = screening.AppliesToAll
+	}
+	rule.IsActive = req.IsActive == nil || *req.IsActive
+	rule.Note = req.Note
+
+	_, err := screening.Compile(*rule)
+	return err
+}
+
+// ListScreeningRules lists every screening rule, including inactive ones
+// GET /api/v1/admin/screening/rules
+func (h *ScreeningHandler) ListScreeningRules(c *gin.Context) {
+	query := h.db.Model(&database.ScreeningRule{})
+	if ruleType := c.Query("type"); ruleType != "" {
+		query = query.Where("type = ?", ruleType)
+	}
+
+	var rules []database.ScreeningRule
+	if err := query.Order("type, created_at").Find(&rules).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch screening rules")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, rules)
+}
+
+// CreateScreeningRule adds a screening rule
+// POST /api/v1/admin/screening/rules
+func (h *ScreeningHandler) CreateScreeningRule(c *gin.Context) {
+	var req screeningRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	actorID := c.MustGet("user_id").(uuid.UUID)
+	rule := database.ScreeningRule{ID: uuid.New(), CreatedByID: &actorID}
+	if err := req.apply(&rule); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid rule: "+err.Error())
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&rule).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, auditActor(c), audit.Entry{
+			Action:     audit.ActionScreeningRuleCreate,
+			TargetType: audit.TargetScreeningRule,
+			TargetID:   rule.ID,
+			After:      rule,
+		})
+	}); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create screening rule")
+		return
+	}
+	h.screener.Invalidate()
+
+	utils.SuccessResponse(c, http.StatusCreated, rule)
+}
+
+// UpdateScreeningRule replaces a screening rule's settings
+// PUT /api/v1/admin/screening/rules/:id
+func (h *ScreeningHandler) UpdateScreeningRule(c *gin.Context) {
+	ruleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid rule ID")
+		return
+	}
+
+	var req screeningRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	var rule database.ScreeningRule
+	if err := h.db.First(&rule, "id = ?", ruleID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Screening rule not found")
+		return
+	}
+
+	before := rule
+	if err := req.apply(&rule); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid rule: "+err.Error())
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&rule).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, auditActor(c), audit.Entry{
+			Action:     audit.ActionScreeningRuleUpdate,
+			TargetType: audit.TargetScreeningRule,
+			TargetID:   rule.ID,
+			Before:     before,
+			After:      rule,
+		})
+	}); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update screening rule")
+		return
+	}
+	h.screener.Invalidate()
+
+	utils.SuccessResponse(c, http.StatusOK, rule)
+}
+
+// DeleteScreeningRule removes a screening rule
+// DELETE /api/v1/admin/screening/rules/:id
+func (h *ScreeningHandler) DeleteScreeningRule(c *gin.Context) {
+	ruleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid rule ID")
+		return
+	}
+
+	var rule database.ScreeningRule
+	if err := h.db.First(&rule, "id = ?", ruleID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Screening rule not found")
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&rule).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, auditActor(c), audit.Entry{
+			Action:     audit.ActionScreeningRuleDelete,
+			TargetType: audit.TargetScreeningRule,
+			TargetID:   rule.ID,
+			Before:     rule,
+		})
+	}); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to delete screening rule")
+		return
+	}
+	h.screener.Invalidate()
+
+	utils.MessageResponse(c, http.StatusOK, "Screening rule deleted successfully")
+}
+
+// TestScreening runs content through the rules without saving it, as if
+// the current user posted it
+// POST /api/v1/admin/screening/test
+func (h *ScreeningHandler) TestScreening(c *gin.Context) {
+	var req struct {
+		Kind  string `json:"kind" binding:"required,oneof=chat comment"`
+		Title string `json:"title"`
+		Body  string `json:"body"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	result := h.screener.Screen(c.Request.Context(), screening.Content{
+		Kind:   req.Kind,
+		UserID: c.MustGet("user_id").(uuid.UUID),
+		Title:  req.Title,
+		Body:   req.Body,
+	})
+
+	data := gin.H{"verdict": result.Verdict.String()}
+	if result.Verdict != screening.Allow {
+		data["rule_id"] = result.RuleID
+		data["reason"] = result.Reason
+	}
+	utils.SuccessResponse(c, http.StatusOK, data)
+}
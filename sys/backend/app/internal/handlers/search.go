@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -20,6 +21,24 @@ func NewSearchHandler(db *gorm.DB, cfg *config.Config) *SearchHandler {
 	return &SearchHandler{db: db, cfg: cfg}
 }
 
+// searchScoreExpr is the text relevance of a chat. Popularity is left out:
+// view counts change with every visit, and a score that moves between pages
+// makes the cursor skip or repeat results.
+const searchScoreExpr = "ts_rank(chats.search_vector, search_query)"
+
+// searchKeyset orders search results by relevance, then newest first
+var searchKeyset = keysetColumns{score: searchScoreExpr, createdAt: "chats.created_at", id: "chats.id"}
+
+// headlineExpr highlights matched terms in a column with <mark> tags. The
+// column is HTML-escaped first so the tags are the only markup in the result.
+func headlineExpr(column, options string) string {
+	escaped := fmt.Sprintf("replace(replace(replace(coalesce(%s, ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;')", column)
+	return fmt.Sprintf("ts_headline('%s', %s, search_query, 'StartSel=<mark>, StopSel=</mark>, %s')",
+		database.SearchConfig, escaped, options)
+}
+
+// SearchChats runs a full-text search over chat titles, descriptions and
+// keywords. q uses web search syntax: "quoted phrases", -exclusions and OR.
 func (h *SearchHandler) SearchChats(c *gin.Context) {
 	query := c.Query("q")
 	if query == "" {
@@ -27,42 +46,50 @@ func (h *SearchHandler) SearchChats(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(h.cfg.DefaultPageSize)))
-
-	if pageSize > h.cfg.MaxPageSize {
-		pageSize = h.cfg.MaxPageSize
+	params, err := parseListParams(c, h.cfg, searchKeyset)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid cursor")
+		return
 	}
 
 	dbQuery := h.db.Model(&database.Chat{}).
-		Where("is_public = ? AND status = ?", true, "active").
-		Where("title ILIKE ? OR description ILIKE ?", "%"+query+"%", "%"+query+"%")
+		Joins("CROSS JOIN websearch_to_tsquery(?, ?) AS search_query", database.SearchConfig, query).
+		Where("chats.is_public = ? AND chats.status = ?", true, "active").
+		Where("chats.search_vector @@ search_query")
 
 	// Additional filters
 	if categoryID := c.Query("category_id"); categoryID != "" {
-		dbQuery = dbQuery.Where("category_id = ?", categoryID)
+		dbQuery = dbQuery.Where("chats.category_id = ?", categoryID)
 	}
 
 	if userID := c.Query("user_id"); userID != "" {
-		dbQuery = dbQuery.Where("user_id = ?", userID)
+		dbQuery = dbQuery.Where("chats.user_id = ?", userID)
 	}
 
 	// Count total
 	var total int64
-	dbQuery.Count(&total)
+	if params.includeTotal {
+		dbQuery.Count(&total)
+	}
 
 	// Pagination
-	offset := (page - 1) * pageSize
 	var chats []database.Chat
-	if err := dbQuery.Preload("User").Preload("Category").Preload("Keywords.Keyword").
-		Offset(offset).Limit(pageSize).
-		Order("view_count DESC, created_at DESC").
+	if err := params.apply(dbQuery.
+		Select("chats.*, "+
+			searchScoreExpr+" AS search_rank, "+
+			headlineExpr("chats.title", "HighlightAll=true")+" AS title_highlight, "+
+			headlineExpr("chats.description", "MaxWords=35, MinWords=15, MaxFragments=2")+" AS description_highlight").
+		Preload("User").Preload("Category").Preload("Keywords.Keyword"), searchKeyset).
 		Find(&chats).Error; err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to search chats")
 		return
 	}
+	chats, hasMore := trimPage(params, chats)
 
-	utils.PaginatedSuccessResponse(c, http.StatusOK, chats, page, pageSize, total)
+	respondList(c, params, chats, hasMore, total, func(chat database.Chat) utils.Cursor {
+		score := chat.SearchRank
+		return utils.Cursor{Score: &score, CreatedAt: chat.CreatedAt, ID: chat.ID}
+	})
 }
 
 func (h *SearchHandler) GetRankingByFavorites(c *gin.Context) {
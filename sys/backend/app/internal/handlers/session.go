@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SessionHandler struct {
+	db           *gorm.DB
+	cfg          *config.Config
+	sessionStore *utils.SessionStore
+}
+
+func NewSessionHandler(db *gorm.DB, cfg *config.Config, sessionStore *utils.SessionStore) *SessionHandler {
+	return &SessionHandler{db: db, cfg: cfg, sessionStore: sessionStore}
+}
+
+// ListSessions returns the devices the user is signed in on, most recently
+// used first. The session of the calling token has current set.
+// GET /api/v1/user/sessions
+func (h *SessionHandler) ListSessions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	sessions, err := h.sessionStore.ListUserSessions(context.Background(), userID.(uuid.UUID))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch sessions")
+		return
+	}
+
+	currentID := c.GetString("session_id")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, sessions)
+}
+
+// RevokeSession signs the user out on one device. Its refresh token stops
+// working and its access tokens are rejected by the auth middleware.
+// DELETE /api/v1/user/sessions/:id
+func (h *SessionHandler) RevokeSession(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sessionID := c.Param("id")
+
+	ctx := context.Background()
+	session, err := h.sessionStore.GetUserSession(ctx, sessionID)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch session")
+		return
+	}
+	if session == nil || session.UserID != userID.(uuid.UUID) {
+		utils.ErrorResponse(c, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	if err := h.sessionStore.RevokeSession(ctx, session.UserID, session.ID, h.cfg.JWTExpiration); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	if session.ID == c.GetString("session_id") {
+		utils.ClearAuthCookies(c)
+	}
+
+	utils.MessageResponse(c, http.StatusOK, "Session revoked successfully")
+}
+
+// RevokeAllSessions signs the user out everywhere. Pass keep_current=true to
+// stay signed in on the calling device.
+// DELETE /api/v1/user/sessions
+func (h *SessionHandler) RevokeAllSessions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	keep := ""
+	if c.Query("keep_current") == "true" {
+		keep = c.GetString("session_id")
+	}
+
+	revoked, err := h.sessionStore.RevokeAllSessions(context.Background(), userID.(uuid.UUID), keep, h.cfg.JWTExpiration)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	if keep == "" {
+		utils.ClearAuthCookies(c)
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"revoked": revoked})
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/notifications"
+	"github.com/chatshare/backend/internal/providers"
+	"github.com/chatshare/backend/internal/screening"
+	"github.com/chatshare/backend/internal/transcript"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxUploadMessages caps the number of turns stored for one uploaded chat
+const maxUploadMessages = 2000
+
+type TranscriptHandler struct {
+	db       *gorm.DB
+	cfg      *config.Config
+	importer *transcript.Importer
+	notifier *notifications.Notifier
+	screener *screening.Screener
+}
+
+func NewTranscriptHandler(db *gorm.DB, cfg *config.Config, importer *transcript.Importer, screener *screening.Screener) *TranscriptHandler {
+	return &TranscriptHandler{db: db, cfg: cfg, importer: importer, notifier: notifications.NewNotifier(db), screener: screener}
+}
+
+// ListMessages returns the imported conversation of a chat in order.
+// Private or moderated chats are only visible to their owner.
+// GET /api/v1/chats/:id/messages
+func (h *TranscriptHandler) ListMessages(c *gin.Context) {
+	chatIDStr := c.Param("id")
+	chatID, err := uuid.Parse(chatIDStr)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid chat ID")
+		return
+	}
+
+	var chat database.Chat
+	if err := h.db.First(&chat, "id = ?", chatID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Chat not found")
+		return
+	}
+
+	if !chat.IsPublic || chat.Status != "active" {
+		userID, exists := c.Get("user_id")
+		if !exists || userID.(uuid.UUID) != chat.UserID {
+			utils.ErrorResponse(c, http.StatusNotFound, "Chat not found")
+			return
+		}
+	}
+
+	var messages []database.ChatMessage
+	if err := h.db.Where("chat_id = ?", chatID).Order("position ASC").Find(&messages).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch messages")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, messages)
+}
+
+// ImportMessages re-fetches the chat's share page and replaces its stored
+// conversation. Only the chat owner can trigger it, and only for chats
+// created from a share link.
+// POST /api/v1/chats/:id/messages/import
+func (h *TranscriptHandler) ImportMessages(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	chatIDStr := c.Param("id")
+	chatID, err := uuid.Parse(chatIDStr)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid chat ID")
+		return
+	}
+
+	var chat database.Chat
+	if err := h.db.First(&chat, "id = ?", chatID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Chat not found")
+		return
+	}
+
+	if chat.UserID != userID.(uuid.UUID) {
+		utils.ErrorResponse(c, http.StatusForbidden, "Not authorized to import this chat")
+		return
+	}
+
+	// An uploaded conversation has no share page to fetch it from again
+	if chat.Source == "upload" {
+		utils.ErrorResponse(c, http.StatusConflict, "Uploaded chats cannot be re-imported")
+		return
+	}
+
+	messages, err := h.importer.Import(c.Request.Context(), &chat)
+	if err != nil {
+		switch {
+		case errors.Is(err, transcript.ErrNoParser):
+			utils.ErrorResponse(c, http.StatusBadRequest, "Transcript import is not supported for this provider")
+		case errors.Is(err, transcript.ErrNoMessages), errors.Is(err, transcript.ErrPageChanged):
+			utils.ErrorResponse(c, http.StatusUnprocessableEntity, "Could not read the conversation from the share page")
+		default:
+			utils.ErrorResponse(c, http.StatusBadGateway, "Failed to fetch the share page")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, messages)
+}
+
+// UploadTranscript creates a chat from an uploaded conversation instead of a
+// share link. The multipart form carries the conversation in a "file" part
+// or a "content" field: a ChatGPT conversations.json entry, a Claude export
+// or Markdown with role markers. The chat's public link points at its page
+// on ChatShare.
+// POST /api/v1/chats/import
+func (h *TranscriptHandler) UploadTranscript(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	// Leave room for the other form fields around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.cfg.TranscriptUploadMaxBytes+64*1024)
+
+	var req struct {
+		Title       string   `form:"title"`
+		Description string   `form:"description"`
+		CategoryID  string   `form:"category_id"`
+		Keywords    []string `form:"keywords"`
+		IsPublic    bool     `form:"is_public"`
+		Format      string   `form:"format"`
+		ChatType    string   `form:"chat_type"`
+		Content     string   `form:"content"`
+	}
+
+	err := c.ShouldBind(&req)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.ErrorResponse(c, http.StatusRequestEntityTooLarge, "Upload is too large")
+			return
+		}
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	var categoryID uuid.UUID
+	if req.CategoryID != "" {
+		if categoryID, err = uuid.Parse(req.CategoryID); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid category ID")
+			return
+		}
+	}
+
+	data := []byte(req.Content)
+	if fileHeader, err := c.FormFile("file"); err == nil {
+		if fileHeader.Size > h.cfg.TranscriptUploadMaxBytes {
+			utils.ErrorResponse(c, http.StatusRequestEntityTooLarge, "Upload is too large")
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Failed to read uploaded file")
+			return
+		}
+		data, err = io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Failed to read uploaded file")
+			return
+		}
+	}
+
+	if len(data) == 0 {
+		utils.ErrorResponse(c, http.StatusBadRequest, "A file or content is required")
+		return
+	}
+	if int64(len(data)) > h.cfg.TranscriptUploadMaxBytes {
+		utils.ErrorResponse(c, http.StatusRequestEntityTooLarge, "Upload is too large")
+		return
+	}
+
+	upload, err := transcript.ParseUpload(data, req.Format)
+	if err != nil {
+		var parseErrs transcript.ParseErrors
+		if errors.As(err, &parseErrs) {
+			utils.ErrorResponseWithData(c, http.StatusBadRequest, "Malformed transcript", gin.H{
+				"errors": parseErrs,
+			})
+			return
+		}
+		utils.ErrorResponse(c, http.StatusBadRequest, "Malformed transcript")
+		return
+	}
+	if len(upload.Messages) > maxUploadMessages {
+		utils.ErrorResponse(c, http.StatusRequestEntityTooLarge, "Transcript has too many messages")
+		return
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = strings.TrimSpace(upload.Title)
+	}
+	if title == "" {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Title is required")
+		return
+	}
+	title = utils.TruncateRunes(title, 255)
+
+	// JSON exports name their provider; Markdown may say which one it came from
+	chatType := upload.Format
+	if upload.Format == transcript.FormatMarkdown {
+		chatType = "other"
+		if providers.Default.Get(req.ChatType) != nil {
+			chatType = req.ChatType
+		}
+	}
+
+	status, flagReason, ok := screenContent(c, h.screener, screening.Content{
+		Kind:   screening.KindChat,
+		UserID: userID.(uuid.UUID),
+		Title:  title,
+		Body:   req.Description,
+	})
+	if !ok {
+		return
+	}
+
+	chatID := uuid.New()
+	chat := database.Chat{
+		ID:          chatID,
+		UserID:      userID.(uuid.UUID),
+		CategoryID:  categoryID,
+		Title:       title,
+		Description: req.Description,
+		PublicLink:  strings.TrimRight(h.cfg.FrontendURL, "/") + "/chats/" + chatID.String(),
+		ChatType:    chatType,
+		Source:      "upload",
+		IsPublic:    req.IsPublic,
+		IsLinkValid: true,
+		Status:      status,
+		FlagReason:  flagReason,
+	}
+
+	if err := h.db.Create(&chat).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create chat")
+		return
+	}
+
+	// Add keywords before storing the transcript, which indexes the chat
+	addChatKeywords(h.db, chat.ID, req.Keywords)
+
+	messages, err := h.importer.Store(context.Background(), chat.ID, upload.Messages)
+	if err != nil {
+		log.Printf("Failed to store uploaded transcript of chat %s: %v", chat.ID, err)
+		h.db.Delete(&chat)
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to store transcript")
+		return
+	}
+
+	if chat.Status == "active" {
+		h.notifier.ChatPublished(&chat)
+	}
+
+	chat.Messages = messages
+	chat.MessageCount = len(messages)
+	utils.SuccessResponse(c, http.StatusCreated, chat)
+}
@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"log"
 	"net/http"
 
 	"github.com/chatshare/backend/internal/config"
 	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/mailer"
+	"github.com/chatshare/backend/internal/userstate"
 	"github.com/chatshare/backend/internal/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -13,12 +16,14 @@ import (
 )
 
 type UserHandler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db         *gorm.DB
+	cfg        *config.Config
+	emails     *mailer.Sender
+	userStates *userstate.Store
 }
 
-func NewUserHandler(db *gorm.DB, cfg *config.Config) *UserHandler {
-	return &UserHandler{db: db, cfg: cfg}
+func NewUserHandler(db *gorm.DB, cfg *config.Config, emails *mailer.Sender, userStates *userstate.Store) *UserHandler {
+	return &UserHandler{db: db, cfg: cfg, emails: emails, userStates: userStates}
 }
 
 func (h *UserHandler) GetProfile(c *gin.Context) {
@@ -166,8 +171,23 @@ func (h *UserHandler) DeleteAccount(c *gin.Context) {
 		return
 	}
 
+	// Keep the address for the confirmation email
+	var user database.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
 	// Hard-delete user and related records for privacy compliance
 	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Remove reports by the user, about their content, or about comments
+		// on their chats, before the content they point to
+		if err := tx.Where("reporter_id = ? OR target_user_id = ? OR chat_id IN (?)", userID, userID,
+			tx.Model(&database.Chat{}).Unscoped().Select("id").Where("user_id = ?", userID)).
+			Delete(&database.Report{}).Error; err != nil {
+			return err
+		}
+
 		// Remove favorites made by the user (favorites of chats)
 		if err := tx.Where("user_id = ?", userID).Delete(&database.Favorite{}).Error; err != nil {
 			return err
@@ -178,6 +198,21 @@ func (h *UserHandler) DeleteAccount(c *gin.Context) {
 			return err
 		}
 
+		// Remove notifications sent to or caused by the user
+		if err := tx.Where("user_id = ? OR actor_id = ?", userID, userID).Delete(&database.Notification{}).Error; err != nil {
+			return err
+		}
+
+		// Detach other users' replies to the user's comments so the parent
+		// foreign key does not block the delete; they are shown as top-level
+		// comments, like replies to a hidden comment
+		if err := tx.Unscoped().Model(&database.Comment{}).
+			Where("parent_id IN (?) AND user_id <> ?",
+				tx.Unscoped().Model(&database.Comment{}).Select("id").Where("user_id = ?", userID), userID).
+			Update("parent_id", nil).Error; err != nil {
+			return err
+		}
+
 		// Remove comments made by the user (hard delete)
 		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&database.Comment{}).Error; err != nil {
 			return err
@@ -191,15 +226,22 @@ func (h *UserHandler) DeleteAccount(c *gin.Context) {
 			return err
 		}
 
-		// Find chats created by the user and remove associated chat data
+		// Find chats created by the user, soft-deleted ones included, and
+		// remove associated chat data
 		var chatIDs []uuid.UUID
-		if err := tx.Model(&database.Chat{}).Where("user_id = ?", userID).Pluck("id", &chatIDs).Error; err != nil {
+		if err := tx.Unscoped().Model(&database.Chat{}).Where("user_id = ?", userID).Pluck("id", &chatIDs).Error; err != nil {
 			return err
 		}
 		if len(chatIDs) > 0 {
 			if err := tx.Where("chat_id IN ?", chatIDs).Delete(&database.ChatKeyword{}).Error; err != nil {
 				return err
 			}
+			if err := tx.Where("chat_id IN ?", chatIDs).Delete(&database.ChatMessage{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("chat_id IN ?", chatIDs).Delete(&database.Notification{}).Error; err != nil {
+				return err
+			}
 			if err := tx.Where("chat_id IN ?", chatIDs).Delete(&database.Favorite{}).Error; err != nil {
 				return err
 			}
@@ -224,6 +266,16 @@ func (h *UserHandler) DeleteAccount(c *gin.Context) {
 			return err
 		}
 
+		// Remove the user's API tokens
+		if err := tx.Where("user_id = ?", userID).Delete(&database.APIToken{}).Error; err != nil {
+			return err
+		}
+
+		// Remove the provider accounts linked to the user
+		if err := tx.Where("user_id = ?", userID).Delete(&database.UserIdentity{}).Error; err != nil {
+			return err
+		}
+
 		// Finally remove the user record permanently
 		if err := tx.Unscoped().Where("id = ?", userID).Delete(&database.User{}).Error; err != nil {
 			return err
@@ -237,5 +289,12 @@ func (h *UserHandler) DeleteAccount(c *gin.Context) {
 		return
 	}
 
+	// Refuse the account's remaining access tokens right away
+	if err := h.userStates.Invalidate(context.Background(), user.ID); err != nil {
+		log.Printf("Failed to clear cached state of user %s: %v", user.ID, err)
+	}
+
+	go h.emails.AccountDeleted(context.Background(), user)
+
 	utils.MessageResponse(c, http.StatusOK, "Account and related data deleted successfully")
 }
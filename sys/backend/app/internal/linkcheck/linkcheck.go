@@ -0,0 +1,254 @@
+package linkcheck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/providers"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	userAgent = "ChatShareLinkChecker/1.0 (+https://chatshare.dev)"
+
+	// Only the start of a page is needed to spot a "not found" notice
+	maxBodyBytes = 512 * 1024
+
+	// Longest a host is skipped after repeated inconclusive responses
+	maxHostBackoff = 6 * time.Hour
+)
+
+// Result is the outcome of checking one link. Inconclusive results (rate
+// limits, server errors, timeouts) leave the chat's status unchanged.
+type Result struct {
+	Valid        bool
+	Inconclusive bool
+	Reason       string
+}
+
+type hostState struct {
+	nextAllowed time.Time
+	failures    int
+}
+
+// Checker periodically fetches chat public links and keeps Chat.IsLinkValid
+// up to date. Requests to the same host are spaced out, and hosts that keep
+// answering inconclusively are backed off exponentially.
+type Checker struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	client *http.Client
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+func NewChecker(db *gorm.DB, cfg *config.Config) *Checker {
+	return &Checker{
+		db:  db,
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+		hosts: make(map[string]*hostState),
+	}
+}
+
+// SetHTTPClient replaces the client used to fetch links
+func (c *Checker) SetHTTPClient(client *http.Client) {
+	c.client = client
+}
+
+// Start runs a check round immediately and then every LinkCheckInterval
+// until ctx is cancelled
+func (c *Checker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(c.cfg.LinkCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := c.RunOnce(ctx); err != nil {
+				log.Printf("Link check round failed: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunOnce checks the batch of chats whose links are most overdue
+func (c *Checker) RunOnce(ctx context.Context) error {
+	var chats []database.Chat
+	if err := c.db.WithContext(ctx).
+		Select("id", "public_link", "is_link_valid").
+		Where("source = ?", "link").
+		Where("link_attempted_at IS NULL OR link_attempted_at < ?", time.Now().Add(-c.cfg.LinkRecheckAfter)).
+		Order("link_attempted_at ASC NULLS FIRST").
+		Limit(c.cfg.LinkCheckBatchSize).
+		Find(&chats).Error; err != nil {
+		return err
+	}
+
+	for _, chat := range chats {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Every chat of the batch is marked as attempted, so chats that were
+		// skipped or got an inconclusive answer go to the back of the queue
+		// instead of being picked again next round
+		host := hostOf(chat.PublicLink)
+		if !c.waitForHost(ctx, host) {
+			c.recordAttempt(ctx, chat.ID)
+			continue
+		}
+
+		result := c.CheckLink(ctx, chat.PublicLink)
+		c.recordHostResult(host, result)
+
+		if result.Inconclusive {
+			c.recordAttempt(ctx, chat.ID)
+			continue
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{
+			"is_link_valid":     result.Valid,
+			"link_checked_at":   now,
+			"link_attempted_at": now,
+			"link_error":        result.Reason,
+		}
+		if err := c.db.WithContext(ctx).Model(&database.Chat{}).Where("id = ?", chat.ID).
+			UpdateColumns(updates).Error; err != nil {
+			log.Printf("Failed to record link check for chat %s: %v", chat.ID, err)
+			continue
+		}
+
+		if chat.IsLinkValid && !result.Valid {
+			log.Printf("Chat %s link marked invalid: %s", chat.ID, result.Reason)
+		}
+	}
+
+	return nil
+}
+
+// recordAttempt notes that a chat's link was due but gave no conclusive answer
+func (c *Checker) recordAttempt(ctx context.Context, chatID uuid.UUID) {
+	if err := c.db.WithContext(ctx).Model(&database.Chat{}).Where("id = ?", chatID).
+		UpdateColumn("link_attempted_at", time.Now()).Error; err != nil {
+		log.Printf("Failed to record link check attempt for chat %s: %v", chatID, err)
+	}
+}
+
+// CheckLink fetches a link and decides whether the shared conversation is
+// still available
+func (c *Checker) CheckLink(ctx context.Context, link string) Result {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return Result{Reason: "malformed link"}
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Result{Inconclusive: true, Reason: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return Result{Reason: fmt.Sprintf("HTTP %d", resp.StatusCode)}
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		// Checked below
+	default:
+		// 403/429/5xx usually mean bot protection or an outage, not a dead link
+		return Result{Inconclusive: true, Reason: fmt.Sprintf("HTTP %d", resp.StatusCode)}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	if err != nil {
+		return Result{Inconclusive: true, Reason: err.Error()}
+	}
+
+	provider := providers.Lookup(resp.Request.URL.Hostname())
+	if provider != nil {
+		page := strings.ToLower(string(body))
+		for _, marker := range provider.NotFoundMarkers {
+			if strings.Contains(page, marker) {
+				return Result{Reason: fmt.Sprintf("%s page says %q", provider.DisplayName, marker)}
+			}
+		}
+	}
+
+	return Result{Valid: true}
+}
+
+// waitForHost blocks until a request to host is allowed. It returns false
+// without waiting when the host is backing off after failures.
+func (c *Checker) waitForHost(ctx context.Context, host string) bool {
+	c.mu.Lock()
+	state, ok := c.hosts[host]
+	if !ok {
+		state = &hostState{}
+		c.hosts[host] = state
+	}
+	wait := time.Until(state.nextAllowed)
+	backingOff := state.failures > 0
+	c.mu.Unlock()
+
+	if wait <= 0 {
+		return true
+	}
+	if backingOff {
+		return false
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(wait):
+		return true
+	}
+}
+
+func (c *Checker) recordHostResult(host string, result Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.hosts[host]
+	if result.Inconclusive {
+		state.failures++
+		backoff := c.cfg.LinkCheckHostDelay << state.failures
+		if backoff <= 0 || backoff > maxHostBackoff {
+			backoff = maxHostBackoff
+		}
+		state.nextAllowed = time.Now().Add(backoff)
+		return
+	}
+
+	state.failures = 0
+	state.nextAllowed = time.Now().Add(c.cfg.LinkCheckHostDelay)
+}
+
+func hostOf(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,116 @@
+package linkcheck
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/chatshare/backend/internal/config"
+)
+
+// providerTransport sends every request to a local test server while
+// keeping the original URL on the response, so provider lookups still see
+// the provider's host
+type providerTransport struct {
+	target *url.URL
+}
+
+func (t providerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sent := req.Clone(req.Context())
+	sent.URL.Scheme = t.target.Scheme
+	sent.URL.Host = t.target.Host
+	sent.Host = t.target.Host
+
+	resp, err := http.DefaultTransport.RoundTrip(sent)
+	if resp != nil {
+		resp.Request = req
+	}
+	return resp, err
+}
+
+func newTestChecker(t *testing.T) *Checker {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/share/live":
+			fmt.Fprint(w, "<html><body><h1>How to bake bread</h1></body></html>")
+		case "/share/unshared":
+			fmt.Fprint(w, "<html><body><p>This shared link has been disabled by its owner.</p></body></html>")
+		case "/share/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/share/limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/share/outage":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	target, _ := url.Parse(server.URL)
+	checker := NewChecker(nil, &config.Config{LinkCheckHostDelay: time.Second})
+	checker.SetHTTPClient(&http.Client{Transport: providerTransport{target: target}, Timeout: 5 * time.Second})
+	return checker
+}
+
+func TestCheckLink(t *testing.T) {
+	checker := newTestChecker(t)
+
+	tests := []struct {
+		link         string
+		valid        bool
+		inconclusive bool
+	}{
+		{link: "https://chatgpt.com/share/live", valid: true},
+		{link: "https://chatgpt.com/share/missing"},
+		{link: "https://chatgpt.com/share/gone"},
+		{link: "https://chatgpt.com/share/unshared"},
+		{link: "https://chatgpt.com/share/limited", inconclusive: true},
+		{link: "https://chatgpt.com/share/outage", inconclusive: true},
+		// Only ChatGPT's own not-found phrases count on a ChatGPT page
+		{link: "https://claude.ai/share/unshared", valid: true},
+	}
+
+	for _, tt := range tests {
+		result := checker.CheckLink(context.Background(), tt.link)
+		if result.Valid != tt.valid || result.Inconclusive != tt.inconclusive {
+			t.Errorf("CheckLink(%s) = %+v, want valid=%v inconclusive=%v", tt.link, result, tt.valid, tt.inconclusive)
+		}
+		if !result.Valid && result.Reason == "" {
+			t.Errorf("CheckLink(%s) gave no reason", tt.link)
+		}
+	}
+}
+
+func TestHostBackoff(t *testing.T) {
+	checker := newTestChecker(t)
+	ctx := context.Background()
+
+	if !checker.waitForHost(ctx, "chatgpt.com") {
+		t.Fatal("first request to a host was not allowed")
+	}
+
+	checker.recordHostResult("chatgpt.com", Result{Inconclusive: true, Reason: "HTTP 429"})
+	if checker.waitForHost(ctx, "chatgpt.com") {
+		t.Error("host was not backed off after an inconclusive result")
+	}
+	if !checker.waitForHost(ctx, "claude.ai") {
+		t.Error("backing off one host held up another")
+	}
+
+	checker.recordHostResult("chatgpt.com", Result{Inconclusive: true, Reason: "HTTP 429"})
+	if got := checker.hosts["chatgpt.com"].failures; got != 2 {
+		t.Errorf("failures = %d, want 2", got)
+	}
+
+	checker.recordHostResult("chatgpt.com", Result{Valid: true})
+	if got := checker.hosts["chatgpt.com"].failures; got != 0 {
+		t.Errorf("failures after a conclusive result = %d, want 0", got)
+	}
+}
@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"context"
+
+	"github.com/chatshare/backend/internal/config"
+)
+
+// Message is a single outgoing email
+type Message struct {
+	To       string
+	ToName   string
+	Subject  string
+	Text     string
+	HTML     string
+	Category string            // email type, used for provider analytics
+	Headers  map[string]string // extra headers such as List-Unsubscribe
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// New returns a SendGrid mailer when an API key is configured, and otherwise
+// a Memory mailer that logs messages instead of delivering them
+func New(cfg *config.Config) Mailer {
+	if cfg.SendGridAPIKey == "" {
+		return NewMemory(true)
+	}
+	return NewSendGrid(cfg.SendGridAPIKey, cfg.FromEmail, cfg.FromName)
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Memory keeps sent messages in memory instead of delivering them. It stands
+// in for SendGrid in development and tests.
+type Memory struct {
+	mu   sync.Mutex
+	sent []Message
+	log  bool
+}
+
+// NewMemory returns a Memory mailer. With logSends set, messages are written
+// to the log rather than kept, so a long-running server does not grow.
+func NewMemory(logSends bool) *Memory {
+	return &Memory{log: logSends}
+}
+
+func (m *Memory) Send(ctx context.Context, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.log {
+		log.Printf("Email not sent (no SendGrid API key): to=%s subject=%q", msg.To, msg.Subject)
+		return nil
+	}
+	m.sent = append(m.sent, *msg)
+	return nil
+}
+
+// Sent returns a copy of the messages sent so far
+func (m *Memory) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.sent...)
+}
+
+// Reset forgets the messages sent so far
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = nil
+}
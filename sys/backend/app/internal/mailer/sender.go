@@ -0,0 +1,225 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/database"
+	"gorm.io/gorm"
+)
+
+const (
+	// DigestPeriod is how often each user receives the digest, and how far
+	// back it looks for chats
+	DigestPeriod = 7 * 24 * time.Hour
+
+	digestChatCount = 10
+	digestBatchSize = 100
+
+	// Longest comment quoted in a comment email
+	commentExcerptLength = 500
+)
+
+// Sender composes ChatShare's emails and hands them to a Mailer. Failures are
+// logged rather than returned, so callers can send from a goroutine.
+type Sender struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	mailer Mailer
+}
+
+func NewSender(db *gorm.DB, cfg *config.Config, mailer Mailer) *Sender {
+	return &Sender{db: db, cfg: cfg, mailer: mailer}
+}
+
+// CommentPosted emails the chat owner about a comment someone else left
+func (s *Sender) CommentPosted(ctx context.Context, chat database.Chat, comment database.Comment) {
+	if comment.UserID == chat.UserID {
+		return
+	}
+
+	var owner, commenter database.User
+	if err := s.db.WithContext(ctx).First(&owner, "id = ?", chat.UserID).Error; err != nil {
+		return
+	}
+	if err := s.db.WithContext(ctx).First(&commenter, "id = ?", comment.UserID).Error; err != nil {
+		return
+	}
+
+	excerpt := comment.Content
+	if runes := []rune(excerpt); len(runes) > commentExcerptLength {
+		excerpt = string(runes[:commentExcerptLength]) + "…"
+	}
+
+	commenterName := commenter.Name
+	if commenterName == "" {
+		commenterName = "Someone"
+	}
+
+	s.send(ctx, &owner, ListComments, TemplateComment, struct {
+		ChatTitle     string
+		ChatURL       string
+		CommenterName string
+		Excerpt       string
+	}{
+		ChatTitle:     chat.Title,
+		ChatURL:       s.chatURL(chat),
+		CommenterName: commenterName,
+		Excerpt:       excerpt,
+	})
+}
+
+// AccountDeleted confirms to a user that their account was deleted. It takes
+// a copy of the user because the row is already gone.
+func (s *Sender) AccountDeleted(ctx context.Context, user database.User) {
+	s.send(ctx, &user, ListAll, TemplateAccountDeleted, struct {
+		DeletedAt string
+	}{
+		DeletedAt: time.Now().UTC().Format("January 2, 2006"),
+	})
+}
+
+// Start sends the weekly digest to users who are due for it, immediately and
+// then every EmailDigestInterval until ctx is cancelled
+func (s *Sender) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.cfg.EmailDigestInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := s.RunOnce(ctx); err != nil {
+				log.Printf("Email digest round failed: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// DigestChat is one entry of the weekly digest
+type DigestChat struct {
+	Title         string
+	Description   string
+	URL           string
+	FavoriteCount int
+	ViewCount     int
+}
+
+// RunOnce sends the digest of the past DigestPeriod's top chats to every
+// user who has not received one within DigestPeriod
+func (s *Sender) RunOnce(ctx context.Context) error {
+	since := time.Now().Add(-DigestPeriod)
+
+	var chats []database.Chat
+	if err := s.db.WithContext(ctx).
+		Where("is_public = ? AND status = ? AND created_at >= ?", true, "active", since).
+		Order("favorite_count * 2 + view_count DESC, created_at DESC").
+		Limit(digestChatCount).
+		Find(&chats).Error; err != nil {
+		return err
+	}
+	if len(chats) == 0 {
+		return nil
+	}
+
+	digest := make([]DigestChat, len(chats))
+	for i, chat := range chats {
+		digest[i] = DigestChat{
+			Title:         chat.Title,
+			Description:   chat.Description,
+			URL:           s.chatURL(chat),
+			FavoriteCount: chat.FavoriteCount,
+			ViewCount:     chat.ViewCount,
+		}
+	}
+	content := struct{ Chats []DigestChat }{Chats: digest}
+
+	for {
+		var users []database.User
+		if err := s.db.WithContext(ctx).
+			Where("status = ?", "active").
+			Where("last_digest_at IS NULL OR last_digest_at < ?", since).
+			Where("LOWER(email) NOT IN (?)", s.db.Model(&database.EmailSuppression{}).
+				Select("email").Where("list IN ?", []string{ListDigest, ListAll})).
+			Order("id").
+			Limit(digestBatchSize).
+			Find(&users).Error; err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return nil
+		}
+
+		for _, user := range users {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			s.send(ctx, &user, ListDigest, TemplateDigest, content)
+
+			// Mark the user even when sending failed, so one bad address does
+			// not stall the digest for everyone else
+			if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", user.ID).
+				UpdateColumn("last_digest_at", time.Now()).Error; err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Suppressed reports whether email unsubscribed from list
+func (s *Sender) Suppressed(ctx context.Context, email, list string) bool {
+	var count int64
+	s.db.WithContext(ctx).Model(&database.EmailSuppression{}).
+		Where("email = ? AND list IN ?", strings.ToLower(email), []string{list, ListAll}).
+		Count(&count)
+	return count > 0
+}
+
+// send renders a template for user and delivers it, unless the user
+// unsubscribed from list
+func (s *Sender) send(ctx context.Context, user *database.User, list, name string, content interface{}) {
+	if user.Email == "" || (list != ListAll && s.Suppressed(ctx, user.Email, list)) {
+		return
+	}
+
+	token := UnsubscribeToken(s.cfg.UnsubscribeSecret, user.Email, list)
+	msg := &Message{
+		To:       user.Email,
+		ToName:   user.Name,
+		Category: name,
+		Headers: map[string]string{
+			// One-click unsubscribe (RFC 8058) goes straight to the API
+			"List-Unsubscribe":      "<" + strings.TrimRight(s.cfg.APIBaseURL, "/") + "/api/v1/email/unsubscribe?token=" + url.QueryEscape(token) + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}
+
+	data := templateData{
+		RecipientName:  user.Name,
+		SiteURL:        s.cfg.FrontendURL,
+		UnsubscribeURL: strings.TrimRight(s.cfg.FrontendURL, "/") + "/unsubscribe?token=" + url.QueryEscape(token),
+		AccountClosed:  name == TemplateAccountDeleted,
+		Content:        content,
+	}
+	if err := render(msg, name, data); err != nil {
+		log.Printf("Failed to render %s email: %v", name, err)
+		return
+	}
+
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		log.Printf("Failed to send %s email to user %s: %v", name, user.ID, err)
+	}
+}
+
+func (s *Sender) chatURL(chat database.Chat) string {
+	return strings.TrimRight(s.cfg.FrontendURL, "/") + "/chats/" + chat.ID.String()
+}
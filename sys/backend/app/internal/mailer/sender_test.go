@@ -0,0 +1,242 @@
+package mailer
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestSender(t *testing.T) (*Sender, sqlmock.Sqlmock, *Memory) {
+	t.Helper()
+
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger:                 logger.Default.LogMode(logger.Silent),
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		UnsubscribeSecret: "unsubscribe-secret",
+		APIBaseURL:        "https://api.chatshare.test",
+		FrontendURL:       "https://chatshare.test/",
+	}
+	memory := NewMemory(false)
+	return NewSender(db, cfg, memory), mock, memory
+}
+
+func userRows(users ...database.User) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "email", "name", "status"})
+	for _, user := range users {
+		rows.AddRow(user.ID, user.Email, user.Name, "active")
+	}
+	return rows
+}
+
+func countRows(count int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"count"}).AddRow(count)
+}
+
+// unsubscribeList returns the list the unsubscribe link of msg is for
+func unsubscribeList(t *testing.T, cfg *config.Config, msg Message) string {
+	t.Helper()
+
+	header := strings.Trim(msg.Headers["List-Unsubscribe"], "<>")
+	link, err := url.Parse(header)
+	if err != nil {
+		t.Fatalf("List-Unsubscribe header %q: %v", header, err)
+	}
+	if !strings.HasPrefix(header, cfg.APIBaseURL+"/api/v1/email/unsubscribe?") {
+		t.Errorf("List-Unsubscribe header %q does not point at the API", header)
+	}
+
+	email, list, err := ParseUnsubscribeToken(cfg.UnsubscribeSecret, link.Query().Get("token"))
+	if err != nil {
+		t.Fatalf("unsubscribe token: %v", err)
+	}
+	if email != strings.ToLower(msg.To) {
+		t.Errorf("unsubscribe token is for %q, message went to %q", email, msg.To)
+	}
+	return list
+}
+
+var (
+	owner     = database.User{ID: uuid.New(), Email: "owner@example.com", Name: "Owner"}
+	commenter = database.User{ID: uuid.New(), Email: "commenter@example.com", Name: "Commenter"}
+)
+
+func TestCommentPosted(t *testing.T) {
+	sender, mock, memory := newTestSender(t)
+
+	chat := database.Chat{ID: uuid.New(), UserID: owner.ID, Title: "Bread baking"}
+	comment := database.Comment{UserID: commenter.ID, Content: strings.Repeat("Great chat! ", 100)}
+
+	mock.ExpectQuery(`FROM "users" WHERE id = \$1`).WillReturnRows(userRows(owner))
+	mock.ExpectQuery(`FROM "users" WHERE id = \$1`).WillReturnRows(userRows(commenter))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "email_suppressions"`).
+		WithArgs("owner@example.com", ListComments, ListAll).
+		WillReturnRows(countRows(0))
+
+	sender.CommentPosted(context.Background(), chat, comment)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	sent := memory.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sent))
+	}
+	msg := sent[0]
+	if msg.To != owner.Email || msg.Category != TemplateComment {
+		t.Errorf("sent %s email to %s, want comment email to %s", msg.Category, msg.To, owner.Email)
+	}
+	if msg.Subject != `Commenter commented on "Bread baking"` {
+		t.Errorf("subject = %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "https://chatshare.test/chats/"+chat.ID.String()) {
+		t.Error("email does not link to the chat")
+	}
+	if !strings.Contains(msg.Text, "…") || strings.Count(msg.Text, "Great chat!") >= 100 {
+		t.Error("long comment was not shortened")
+	}
+	if list := unsubscribeList(t, sender.cfg, msg); list != ListComments {
+		t.Errorf("unsubscribe link is for %q, want comments", list)
+	}
+}
+
+func TestCommentPostedOnOwnChat(t *testing.T) {
+	sender, mock, memory := newTestSender(t)
+
+	chat := database.Chat{ID: uuid.New(), UserID: owner.ID, Title: "Bread baking"}
+	sender.CommentPosted(context.Background(), chat, database.Comment{UserID: owner.ID, Content: "Update"})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if len(memory.Sent()) != 0 {
+		t.Error("owner was emailed about their own comment")
+	}
+}
+
+func TestCommentPostedSuppressed(t *testing.T) {
+	sender, mock, memory := newTestSender(t)
+
+	chat := database.Chat{ID: uuid.New(), UserID: owner.ID, Title: "Bread baking"}
+
+	mock.ExpectQuery(`FROM "users" WHERE id = \$1`).WillReturnRows(userRows(owner))
+	mock.ExpectQuery(`FROM "users" WHERE id = \$1`).WillReturnRows(userRows(commenter))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "email_suppressions"`).
+		WithArgs("owner@example.com", ListComments, ListAll).
+		WillReturnRows(countRows(1))
+
+	sender.CommentPosted(context.Background(), chat, database.Comment{UserID: commenter.ID, Content: "Nice"})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if len(memory.Sent()) != 0 {
+		t.Error("email was sent to an address that unsubscribed")
+	}
+}
+
+func TestAccountDeleted(t *testing.T) {
+	sender, mock, memory := newTestSender(t)
+
+	// The confirmation is required, so suppressions are not looked up
+	sender.AccountDeleted(context.Background(), owner)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	sent := memory.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sent))
+	}
+	if sent[0].To != owner.Email || sent[0].Category != TemplateAccountDeleted {
+		t.Errorf("sent %s email to %s, want account_deleted email to %s", sent[0].Category, sent[0].To, owner.Email)
+	}
+	if sent[0].Subject == "" || sent[0].HTML == "" {
+		t.Error("email was not rendered")
+	}
+}
+
+func TestDigestRunOnce(t *testing.T) {
+	sender, mock, memory := newTestSender(t)
+
+	chatID := uuid.New()
+	mock.ExpectQuery(`SELECT \* FROM "chats" WHERE \(is_public = \$1 AND status = \$2 AND created_at >= \$3\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "favorite_count", "view_count", "created_at"}).
+			AddRow(chatID, "Sourdough starter", "Keeping it alive", 4, 120, time.Now()))
+
+	// Users who unsubscribed from the digest are left out by the query
+	mock.ExpectQuery(`FROM "users" WHERE status = \$1 AND \(last_digest_at IS NULL OR last_digest_at < \$2\) `+
+		`AND LOWER\(email\) NOT IN \(SELECT "email" FROM "email_suppressions" WHERE list IN \(\$3,\$4\)\)`).
+		WithArgs("active", sqlmock.AnyArg(), ListDigest, ListAll).
+		WillReturnRows(userRows(owner))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "email_suppressions"`).
+		WithArgs("owner@example.com", ListDigest, ListAll).
+		WillReturnRows(countRows(0))
+	mock.ExpectExec(`UPDATE "users" SET "last_digest_at"=\$1 WHERE id = \$2`).
+		WithArgs(sqlmock.AnyArg(), owner.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM "users" WHERE status = \$1`).
+		WillReturnRows(userRows())
+
+	if err := sender.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	sent := memory.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sent))
+	}
+	msg := sent[0]
+	if msg.To != owner.Email || msg.Category != TemplateDigest {
+		t.Errorf("sent %s email to %s, want digest to %s", msg.Category, msg.To, owner.Email)
+	}
+	if !strings.Contains(msg.Text, "Sourdough starter") || !strings.Contains(msg.HTML, "/chats/"+chatID.String()) {
+		t.Error("digest does not list the week's chat")
+	}
+	if list := unsubscribeList(t, sender.cfg, msg); list != ListDigest {
+		t.Errorf("unsubscribe link is for %q, want digest", list)
+	}
+}
+
+func TestDigestRunOnceWithoutChats(t *testing.T) {
+	sender, mock, memory := newTestSender(t)
+
+	mock.ExpectQuery(`FROM "chats"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	if err := sender.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if len(memory.Sent()) != 0 {
+		t.Error("an empty digest was sent")
+	}
+}
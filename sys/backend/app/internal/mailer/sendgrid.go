@@ -0,0 +1,107 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const sendGridEndpoint = "https://api.sendgrid.com/v3/mail/send"
+
+// SendGrid delivers mail through the SendGrid v3 Mail Send API
+type SendGrid struct {
+	apiKey    string
+	fromEmail string
+	fromName  string
+	endpoint  string
+	client    *http.Client
+}
+
+func NewSendGrid(apiKey, fromEmail, fromName string) *SendGrid {
+	return &SendGrid{
+		apiKey:    apiKey,
+		fromEmail: fromEmail,
+		fromName:  fromName,
+		endpoint:  sendGridEndpoint,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// SetHTTPClient replaces the client used to call the API
+func (s *SendGrid) SetHTTPClient(client *http.Client) {
+	s.client = client
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridRequest struct {
+	Personalizations []struct {
+		To []sendGridAddress `json:"to"`
+	} `json:"personalizations"`
+	From       sendGridAddress   `json:"from"`
+	Subject    string            `json:"subject"`
+	Content    []sendGridContent `json:"content"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Categories []string          `json:"categories,omitempty"`
+}
+
+func (s *SendGrid) Send(ctx context.Context, msg *Message) error {
+	body := sendGridRequest{
+		From:    sendGridAddress{Email: s.fromEmail, Name: s.fromName},
+		Subject: msg.Subject,
+		Headers: msg.Headers,
+	}
+	body.Personalizations = make([]struct {
+		To []sendGridAddress `json:"to"`
+	}, 1)
+	body.Personalizations[0].To = []sendGridAddress{{Email: msg.To, Name: msg.ToName}}
+
+	// SendGrid requires text/plain to come before text/html
+	if msg.Text != "" {
+		body.Content = append(body.Content, sendGridContent{Type: "text/plain", Value: msg.Text})
+	}
+	if msg.HTML != "" {
+		body.Content = append(body.Content, sendGridContent{Type: "text/html", Value: msg.HTML})
+	}
+	if msg.Category != "" {
+		body.Categories = []string{msg.Category}
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sendgrid returned HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(detail))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Email templates, each with a .html and a .txt version in templates/
+const (
+	TemplateDigest         = "digest"
+	TemplateComment        = "comment"
+	TemplateAccountDeleted = "account_deleted"
+)
+
+// templateData is passed to every template. Content holds the data of the
+// specific email.
+type templateData struct {
+	RecipientName  string
+	SiteURL        string
+	UnsubscribeURL string
+	AccountClosed  bool // the recipient no longer has an account
+	Content        interface{}
+}
+
+var templateFuncs = map[string]interface{}{
+	"inc": func(i int) int { return i + 1 },
+}
+
+var (
+	htmlTemplates = map[string]*htmltemplate.Template{}
+	textTemplates = map[string]*texttemplate.Template{}
+)
+
+func init() {
+	for _, name := range []string{TemplateDigest, TemplateComment, TemplateAccountDeleted} {
+		htmlTemplates[name] = htmltemplate.Must(htmltemplate.New(name).Funcs(templateFuncs).
+			ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html"))
+		textTemplates[name] = texttemplate.Must(texttemplate.New(name).Funcs(templateFuncs).
+			ParseFS(templateFS, "templates/layout.txt", "templates/"+name+".txt"))
+	}
+}
+
+// render fills in the subject and both bodies of msg from a template
+func render(msg *Message, name string, data templateData) error {
+	var subject, text, html bytes.Buffer
+
+	if err := textTemplates[name].ExecuteTemplate(&subject, "subject", data); err != nil {
+		return err
+	}
+	if err := textTemplates[name].ExecuteTemplate(&text, "layout", data); err != nil {
+		return err
+	}
+	if err := htmlTemplates[name].ExecuteTemplate(&html, "layout", data); err != nil {
+		return err
+	}
+
+	msg.Subject = strings.TrimSpace(subject.String())
+	msg.Text = strings.TrimSpace(text.String()) + "\n"
+	msg.HTML = html.String()
+	return nil
+}
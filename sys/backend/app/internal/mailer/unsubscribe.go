@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// Mailing lists a recipient can unsubscribe from
+const (
+	ListDigest   = "digest"   // weekly digest of top chats
+	ListComments = "comments" // new comments on your chats
+	ListAll      = "all"      // every email that is not strictly required
+)
+
+var ErrInvalidUnsubscribeToken = errors.New("invalid unsubscribe token")
+
+// IsList reports whether name is a known mailing list
+func IsList(name string) bool {
+	switch name {
+	case ListDigest, ListComments, ListAll:
+		return true
+	}
+	return false
+}
+
+// UnsubscribeToken signs an email address and list so the recipient can
+// unsubscribe without logging in. Tokens do not expire: links in old emails
+// must keep working.
+func UnsubscribeToken(secret, email, list string) string {
+	payload := strings.ToLower(email) + "\n" + list
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(unsubscribeSignature(secret, payload))
+}
+
+// ParseUnsubscribeToken verifies a token and returns its email and list
+func ParseUnsubscribeToken(secret, token string) (email, list string, err error) {
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", "", ErrInvalidUnsubscribeToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", "", ErrInvalidUnsubscribeToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return "", "", ErrInvalidUnsubscribeToken
+	}
+	if !hmac.Equal(sig, unsubscribeSignature(secret, string(payload))) {
+		return "", "", ErrInvalidUnsubscribeToken
+	}
+
+	email, list, ok = strings.Cut(string(payload), "\n")
+	if !ok || email == "" || !IsList(list) {
+		return "", "", ErrInvalidUnsubscribeToken
+	}
+	return email, list, nil
+}
+
+func unsubscribeSignature(secret, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte("unsubscribe:"+secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUnsubscribeTokenRoundTrip(t *testing.T) {
+	token := UnsubscribeToken("secret", "Ada@Example.com", ListDigest)
+
+	email, list, err := ParseUnsubscribeToken("secret", token)
+	if err != nil {
+		t.Fatalf("ParseUnsubscribeToken: %v", err)
+	}
+	if email != "ada@example.com" || list != ListDigest {
+		t.Errorf("got %q, %q; want ada@example.com, digest", email, list)
+	}
+}
+
+func TestParseUnsubscribeTokenRejectsForgeries(t *testing.T) {
+	token := UnsubscribeToken("secret", "ada@example.com", ListComments)
+	payload, sig, _ := strings.Cut(token, ".")
+	otherPayload, _, _ := strings.Cut(UnsubscribeToken("secret", "bob@example.com", ListComments), ".")
+	badList := UnsubscribeToken("secret", "ada@example.com", "newsletter")
+
+	tests := map[string]string{
+		"other secret":    UnsubscribeToken("other", "ada@example.com", ListComments),
+		"swapped payload": otherPayload + "." + sig,
+		"truncated sig":   payload + "." + sig[:10],
+		"no signature":    payload,
+		"not base64":      "!!!." + sig,
+		"unknown list":    badList,
+		"empty":           "",
+	}
+
+	for name, token := range tests {
+		if _, _, err := ParseUnsubscribeToken("secret", token); !errors.Is(err, ErrInvalidUnsubscribeToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidUnsubscribeToken", name, err)
+		}
+	}
+}
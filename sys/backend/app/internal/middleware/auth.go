@@ -1,31 +1,58 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/chatshare/backend/internal/apitoken"
 	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/userstate"
 	"github.com/chatshare/backend/internal/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
-func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
+func AuthMiddleware(cfg *config.Config, sessions *utils.SessionStore, apiTokens *apitoken.Store, users *userstate.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+		token, fromCookie, errMsg := extractToken(c)
+		if errMsg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": errMsg})
 			c.Abort()
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
-			c.Abort()
+		// Personal API tokens are only accepted as bearer tokens; what they
+		// may do is limited by RequireScope
+		if !fromCookie && apitoken.IsAPIToken(token) {
+			apiToken, err := lookupAPIToken(c, apiTokens, token)
+			if err == apitoken.ErrInvalidToken {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked API token"})
+				c.Abort()
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Token check failed"})
+				c.Abort()
+				return
+			}
+
+			if accountErr := userstate.FromUser(&apiToken.User).Check(time.Now()); accountErr != nil {
+				abortAccountError(c, accountErr)
+				return
+			}
+
+			setAPIToken(c, apiToken)
+
+			tokenID, ip := apiToken.ID, c.ClientIP()
+			go apiTokens.Touch(context.Background(), tokenID, ip)
+
+			c.Next()
 			return
 		}
 
-		token := parts[1]
 		claims, err := utils.ValidateJWT(token, cfg.JWTSecret)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
@@ -33,41 +60,166 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		c.Set("user_id", claims.UserID)
-		c.Set("user_email", claims.Email)
-		c.Set("user_role", claims.Role)
-		c.Next()
-	}
-}
+		// Browsers attach cookies to cross-site requests, so cookie auth
+		// needs CSRF protection; bearer tokens do not
+		if fromCookie && !utils.VerifyCSRF(c, cfg.FrontendURL, cfg.APIBaseURL) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "CSRF token missing or invalid"})
+			c.Abort()
+			return
+		}
 
-func AdminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		role, exists := c.Get("user_role")
-		if !exists || role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		denied, err := isDenied(sessions, claims)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Token check failed"})
+			c.Abort()
+			return
+		}
+		if denied {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
+
+		// Suspensions and role changes apply to tokens issued before them
+		state, err := lookupState(users, claims.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Token check failed"})
 			c.Abort()
 			return
 		}
+		if accountErr := state.Check(time.Now()); accountErr != nil {
+			abortAccountError(c, accountErr)
+			return
+		}
+		claims.Role = state.Role
+
+		setClaims(c, claims)
+
+		if claims.SessionID != "" {
+			sessionID, ip := claims.SessionID, c.ClientIP()
+			go sessions.TouchSession(context.Background(), sessionID, ip)
+		}
+
 		c.Next()
 	}
 }
 
-func OptionalAuthMiddleware(cfg *config.Config) gin.HandlerFunc {
+// extractToken reads the access token from the Authorization header, or
+// from the session cookie when there is no header. errMsg is set when
+// neither holds a usable token.
+func extractToken(c *gin.Context) (token string, fromCookie bool, errMsg string) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return "", false, "Invalid authorization header format"
+		}
+		return parts[1], false, ""
+	}
+
+	cookie, err := utils.GetSessionCookie(c)
+	if err != nil || cookie == "" {
+		return "", false, "Authentication required"
+	}
+	return cookie, true, ""
+}
+
+// isDenied checks the token and its session against the revocation denylist
+func isDenied(sessions *utils.SessionStore, claims *utils.JWTClaims) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	return sessions.IsTokenDenied(ctx, claims.ID, claims.SessionID)
+}
+
+func lookupState(users *userstate.Store, userID uuid.UUID) (*userstate.State, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	return users.Get(ctx, userID)
+}
+
+// abortAccountError refuses a suspended or deleted user with a code clients
+// can show a notice for
+func abortAccountError(c *gin.Context, accountErr *userstate.AccountError) {
+	utils.ErrorResponseWithCode(c, http.StatusForbidden, accountErr.Code, accountErr.Message, accountErr.Details())
+	c.Abort()
+}
+
+// apiTokenLookupKey keeps the result of looking up a request's API token,
+// which both the rate limiter and the auth middleware need
+const apiTokenLookupKey = "api_token_lookup"
+
+type apiTokenLookup struct {
+	token    string
+	apiToken *database.APIToken
+}
+
+func lookupAPIToken(c *gin.Context, apiTokens *apitoken.Store, token string) (*database.APIToken, error) {
+	if cached, ok := c.Get(apiTokenLookupKey); ok && cached.(apiTokenLookup).token == token {
+		return cached.(apiTokenLookup).apiToken, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	apiToken, err := apiTokens.Authenticate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	c.Set(apiTokenLookupKey, apiTokenLookup{token: token, apiToken: apiToken})
+	return apiToken, nil
+}
+
+// setAPIToken sets the same context as setClaims for a request made with an
+// API token, plus the token's ID and scopes
+func setAPIToken(c *gin.Context, token *database.APIToken) {
+	c.Set("user_id", token.UserID)
+	c.Set("user_email", token.User.Email)
+	c.Set("user_role", token.User.Role)
+	c.Set("api_token_id", token.ID)
+	c.Set("token_scopes", apitoken.ScopeList(token))
+}
+
+func setClaims(c *gin.Context, claims *utils.JWTClaims) {
+	c.Set("user_id", claims.UserID)
+	c.Set("user_email", claims.Email)
+	c.Set("user_role", claims.Role)
+	c.Set("token_id", claims.ID)
+	c.Set("session_id", claims.SessionID)
+	if claims.ExpiresAt != nil {
+		c.Set("token_expires_at", claims.ExpiresAt.Time)
+	}
+}
+
+func OptionalAuthMiddleware(cfg *config.Config, sessions *utils.SessionStore, apiTokens *apitoken.Store, users *userstate.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		token, fromCookie, errMsg := extractToken(c)
+		if errMsg != "" {
 			c.Next()
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) == 2 && parts[0] == "Bearer" {
-			token := parts[1]
-			claims, err := utils.ValidateJWT(token, cfg.JWTSecret)
-			if err == nil {
-				c.Set("user_id", claims.UserID)
-				c.Set("user_email", claims.Email)
-				c.Set("user_role", claims.Role)
+		// API tokens without the read scope, or of suspended users, count as
+		// anonymous
+		if !fromCookie && apitoken.IsAPIToken(token) {
+			if apiToken, err := lookupAPIToken(c, apiTokens, token); err == nil && apitoken.HasScope(apiToken, apitoken.ScopeRead) &&
+				userstate.FromUser(&apiToken.User).Check(time.Now()) == nil {
+				setAPIToken(c, apiToken)
+			}
+			c.Next()
+			return
+		}
+
+		// Treat invalid or revoked tokens, cookies failing the CSRF check,
+		// suspended users and failed checks as anonymous
+		claims, err := utils.ValidateJWT(token, cfg.JWTSecret)
+		if err == nil && (!fromCookie || utils.VerifyCSRF(c, cfg.FrontendURL, cfg.APIBaseURL)) {
+			if denied, err := isDenied(sessions, claims); err == nil && !denied {
+				if state, err := lookupState(users, claims.UserID); err == nil && state.Check(time.Now()) == nil {
+					claims.Role = state.Role
+					setClaims(c, claims)
+				}
 			}
 		}
 		c.Next()
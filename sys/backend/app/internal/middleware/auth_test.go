@@ -0,0 +1,231 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/chatshare/backend/internal/apitoken"
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/userstate"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type authTest struct {
+	cfg      *config.Config
+	sessions *utils.SessionStore
+	redis    *miniredis.Miniredis
+	mock     sqlmock.Sqlmock
+	router   *gin.Engine
+}
+
+// newAuthTest serves GET and POST /protected behind AuthMiddleware and any
+// further middleware. The handler answers with the user's role.
+func newAuthTest(t *testing.T, middleware ...gin.HandlerFunc) *authTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger:                 logger.Default.LogMode(logger.Silent),
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+
+	at := &authTest{
+		cfg: &config.Config{
+			JWTSecret:   "jwt-secret",
+			FrontendURL: "https://chatshare.test",
+			APIBaseURL:  "https://api.chatshare.test",
+		},
+		sessions: utils.NewSessionStore(redisClient),
+		redis:    redisServer,
+		mock:     mock,
+		router:   gin.New(),
+	}
+
+	handlers := append([]gin.HandlerFunc{
+		AuthMiddleware(at.cfg, at.sessions, apitoken.NewStore(db), userstate.NewStore(db, redisClient)),
+	}, middleware...)
+	handlers = append(handlers, func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("user_role"))
+	})
+	at.router.GET("/protected", handlers...)
+	at.router.POST("/protected", handlers...)
+	return at
+}
+
+// setState caches a user's state as the userstate store would
+func (at *authTest) setState(userID uuid.UUID, state string) {
+	at.redis.Set(userstate.StatePrefix+userID.String(), state)
+}
+
+// accessToken returns an access token for an active user with role
+func (at *authTest) accessToken(t *testing.T, userID uuid.UUID, role, sessionID string) string {
+	t.Helper()
+	at.setState(userID, `{"status":"active","role":"`+role+`"}`)
+	token, err := utils.GenerateJWT(userID, "ada@example.com", role, sessionID, at.cfg.JWTSecret, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func (at *authTest) do(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	at.router.ServeHTTP(w, req)
+	return w
+}
+
+func bearer(method, token string) *http.Request {
+	req := httptest.NewRequest(method, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestAuthMiddlewareAcceptsAccessToken(t *testing.T) {
+	at := newAuthTest(t)
+	token := at.accessToken(t, uuid.New(), "user", "session-1")
+
+	if w := at.do(bearer(http.MethodPost, token)); w.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+}
+
+func TestAuthMiddlewareRejectsBadTokens(t *testing.T) {
+	at := newAuthTest(t)
+	userID := uuid.New()
+	at.setState(userID, `{"status":"active","role":"user"}`)
+
+	expired, _ := utils.GenerateJWT(userID, "", "user", "", at.cfg.JWTSecret, -time.Minute)
+	forged, _ := utils.GenerateJWT(userID, "", "admin", "", "other-secret", time.Minute)
+
+	tests := map[string]*http.Request{
+		"no credentials": httptest.NewRequest(http.MethodGet, "/protected", nil),
+		"expired":        bearer(http.MethodGet, expired),
+		"wrong secret":   bearer(http.MethodGet, forged),
+		"not a JWT":      bearer(http.MethodGet, "garbage"),
+	}
+	malformed := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	malformed.Header.Set("Authorization", "Token abc")
+	tests["not bearer"] = malformed
+
+	for name, req := range tests {
+		if w := at.do(req); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", name, w.Code)
+		}
+	}
+}
+
+func TestAuthMiddlewareRejectsRevokedTokens(t *testing.T) {
+	at := newAuthTest(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	// Signed out: the token's jti is on the denylist
+	token := at.accessToken(t, userID, "user", "session-1")
+	claims, _ := utils.ValidateJWT(token, at.cfg.JWTSecret)
+	at.sessions.DenyToken(ctx, claims.ID, claims.ExpiresAt.Time)
+	if w := at.do(bearer(http.MethodGet, token)); w.Code != http.StatusUnauthorized {
+		t.Errorf("denied jti: status = %d, want 401", w.Code)
+	}
+
+	// Signed out remotely: the whole session is revoked
+	other := at.accessToken(t, userID, "user", "session-2")
+	at.sessions.RevokeSession(ctx, userID, "session-2", time.Minute)
+	if w := at.do(bearer(http.MethodGet, other)); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked session: status = %d, want 401", w.Code)
+	}
+
+	// Other sessions are unaffected
+	if w := at.do(bearer(http.MethodGet, at.accessToken(t, userID, "user", "session-3"))); w.Code != http.StatusOK {
+		t.Errorf("unrevoked session: status = %d, want 200", w.Code)
+	}
+}
+
+// cookieRequest sends the access token in the session cookie, as the web
+// app does
+func cookieRequest(method, token, origin, csrf string) *http.Request {
+	req := httptest.NewRequest(method, "/protected", nil)
+	req.AddCookie(&http.Cookie{Name: utils.SessionCookieName, Value: token})
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if csrf != "" {
+		req.AddCookie(&http.Cookie{Name: utils.CSRFCookieName, Value: csrf})
+		req.Header.Set(utils.CSRFHeaderName, csrf)
+	}
+	return req
+}
+
+func TestAuthMiddlewareChecksCSRFForCookies(t *testing.T) {
+	at := newAuthTest(t)
+	token := at.accessToken(t, uuid.New(), "user", "session-1")
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"read without CSRF token", cookieRequest(http.MethodGet, token, "", ""), http.StatusOK},
+		{"change with CSRF token", cookieRequest(http.MethodPost, token, "https://chatshare.test", "csrf"), http.StatusOK},
+		{"change without CSRF token", cookieRequest(http.MethodPost, token, "https://chatshare.test", ""), http.StatusForbidden},
+		{"change from another site", cookieRequest(http.MethodPost, token, "https://evil.test", "csrf"), http.StatusForbidden},
+		{"bearer change without CSRF token", bearer(http.MethodPost, token), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if w := at.do(tt.req); w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestAuthMiddlewareIgnoresAPITokenCookies(t *testing.T) {
+	at := newAuthTest(t)
+
+	// API tokens are bearer credentials only; in a cookie they are not JWTs
+	if w := at.do(cookieRequest(http.MethodGet, apitoken.Prefix+"secret", "", "")); w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", w.Code)
+	}
+}
+
+func TestAuthMiddlewareRefusesSuspendedUsers(t *testing.T) {
+	at := newAuthTest(t)
+	userID := uuid.New()
+	token := at.accessToken(t, userID, "user", "session-1")
+
+	// Suspended after the token was issued
+	at.setState(userID, `{"status":"suspended","role":"user","suspension_reason":"spam"}`)
+	w := at.do(bearer(http.MethodGet, token))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"code":"account_suspended"`) {
+		t.Errorf("suspended: status = %d, body %s; want 403 account_suspended", w.Code, w.Body)
+	}
+
+	at.setState(userID, `{"status":"deleted"}`)
+	w = at.do(bearer(http.MethodGet, token))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"code":"account_deleted"`) {
+		t.Errorf("deleted: status = %d, body %s; want 403 account_deleted", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/chatshare/backend/internal/rbac"
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission allows a request only if the user's role grants perm.
+// Requires AuthMiddleware first.
+func RequirePermission(perm rbac.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !rbac.Can(c.GetString("user_role"), perm) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission required: " + string(perm)})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireStaff allows a request only if the user's role grants any
+// permission, keeping regular users out of the admin API entirely
+func RequireStaff() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(rbac.Permissions(c.GetString("user_role"))) == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/chatshare/backend/internal/rbac"
+	"github.com/google/uuid"
+)
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		role string
+		want int
+	}{
+		{rbac.RoleAdmin, http.StatusOK},
+		{rbac.RoleModerator, http.StatusOK},
+		{rbac.RoleCurator, http.StatusForbidden},
+		{rbac.RoleUser, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		at := newAuthTest(t, RequirePermission(rbac.PermReportsReview))
+		token := at.accessToken(t, uuid.New(), tt.role, "session-1")
+
+		if w := at.do(bearer(http.MethodGet, token)); w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.role, w.Code, tt.want)
+		}
+	}
+}
+
+func TestRequireStaff(t *testing.T) {
+	tests := []struct {
+		role string
+		want int
+	}{
+		{rbac.RoleAdmin, http.StatusOK},
+		{rbac.RoleModerator, http.StatusOK},
+		{rbac.RoleCurator, http.StatusOK},
+		{rbac.RoleUser, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		at := newAuthTest(t, RequireStaff())
+		token := at.accessToken(t, uuid.New(), tt.role, "session-1")
+
+		if w := at.do(bearer(http.MethodGet, token)); w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.role, w.Code, tt.want)
+		}
+	}
+}
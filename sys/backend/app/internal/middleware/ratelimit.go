@@ -2,35 +2,88 @@ package middleware
 
 import (
 	"context"
-	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/chatshare/backend/internal/apitoken"
 	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/ratelimit"
+	"github.com/chatshare/backend/internal/utils"
 	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
 )
 
-func RateLimitMiddleware(cfg *config.Config, redisClient *redis.Client) gin.HandlerFunc {
+// RateLimitPolicies chooses the policy a request is limited by
+type RateLimitPolicies struct {
+	Read  ratelimit.Policy // safe methods without a route policy
+	Write ratelimit.Policy // other methods without a route policy
+
+	// Routes maps "METHOD /full/route/path", as registered with the router,
+	// to the policy for that route
+	Routes map[string]ratelimit.Policy
+
+	// Prefixes maps a path prefix to the policy for routes under it that
+	// have no route policy. The longest matching prefix wins.
+	Prefixes map[string]ratelimit.Policy
+}
+
+func (p RateLimitPolicies) forRequest(c *gin.Context) ratelimit.Policy {
+	route := c.FullPath()
+	if policy, ok := p.Routes[c.Request.Method+" "+route]; ok {
+		return policy
+	}
+
+	matched := ""
+	for prefix := range p.Prefixes {
+		if len(prefix) > len(matched) && route != "" && strings.HasPrefix(route, prefix) {
+			matched = prefix
+		}
+	}
+	if matched != "" {
+		return p.Prefixes[matched]
+	}
+
+	if utils.IsSafeMethod(c.Request.Method) {
+		return p.Read
+	}
+	return p.Write
+}
+
+// RateLimitMiddleware limits requests per signed-in user, or per IP address
+// for anonymous clients, so that users sharing an address do not share a
+// limit. It runs before authentication, so it identifies the user from the
+// token itself. Responses carry RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers, plus Retry-After when refused with a 429.
+// If Redis is unavailable requests are let through.
+func RateLimitMiddleware(cfg *config.Config, limiter *ratelimit.Limiter, apiTokens *apitoken.Store, policies RateLimitPolicies) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ip := c.ClientIP()
-		key := fmt.Sprintf("rate_limit:%s", ip)
+		policy := policies.forRequest(c)
+		if policy.Limit <= 0 {
+			c.Next()
+			return
+		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
 
-		count, err := redisClient.Incr(ctx, key).Result()
+		decision, err := limiter.Allow(ctx, policy, rateLimitKey(c, cfg, apiTokens))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Rate limit check failed"})
-			c.Abort()
+			log.Printf("Rate limit check failed: %v", err)
+			c.Next()
 			return
 		}
 
-		if count == 1 {
-			redisClient.Expire(ctx, key, cfg.RateLimitDuration)
-		}
+		reset := strconv.Itoa(int(math.Ceil(decision.Reset.Seconds())))
+		c.Header("RateLimit-Limit", strconv.Itoa(decision.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		c.Header("RateLimit-Reset", reset)
+		c.Header("RateLimit-Policy", strconv.Itoa(policy.Limit)+";w="+strconv.Itoa(int(policy.Window.Seconds())))
 
-		if count > int64(cfg.RateLimitRequests) {
+		if !decision.Allowed {
+			c.Header("Retry-After", reset)
 			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
 			c.Abort()
 			return
@@ -39,3 +92,20 @@ func RateLimitMiddleware(cfg *config.Config, redisClient *redis.Client) gin.Hand
 		c.Next()
 	}
 }
+
+// rateLimitKey identifies who a request counts against. Requests with a
+// valid access token or API token count against the user; anonymous
+// requests and invalid tokens count against the client IP.
+func rateLimitKey(c *gin.Context, cfg *config.Config, apiTokens *apitoken.Store) string {
+	token, fromCookie, errMsg := extractToken(c)
+	if errMsg == "" {
+		if !fromCookie && apitoken.IsAPIToken(token) {
+			if apiToken, err := lookupAPIToken(c, apiTokens, token); err == nil {
+				return "user:" + apiToken.UserID.String()
+			}
+		} else if claims, err := utils.ValidateJWT(token, cfg.JWTSecret); err == nil {
+			return "user:" + claims.UserID.String()
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/chatshare/backend/internal/apitoken"
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/ratelimit"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testPolicies = RateLimitPolicies{
+	Read:  ratelimit.Policy{Name: "read", Limit: 5, Window: time.Minute},
+	Write: ratelimit.Policy{Name: "write", Limit: 4, Window: time.Minute},
+	Routes: map[string]ratelimit.Policy{
+		"GET /api/v1/auth/me":  {Name: "read", Limit: 5, Window: time.Minute},
+		"POST /api/v1/chats":   {Name: "posts", Limit: 1, Window: time.Hour},
+		"GET /api/v1/disabled": {Name: "disabled", Window: time.Minute},
+	},
+	Prefixes: map[string]ratelimit.Policy{
+		"/api/v1/auth/": {Name: "auth", Limit: 2, Window: time.Minute},
+	},
+}
+
+type rateLimitTest struct {
+	cfg    *config.Config
+	redis  *miniredis.Miniredis
+	router *gin.Engine
+}
+
+func newRateLimitTest(t *testing.T) *rateLimitTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	sqlDB, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redisServer := miniredis.RunT(t)
+	rt := &rateLimitTest{
+		cfg:    &config.Config{JWTSecret: "jwt-secret"},
+		redis:  redisServer,
+		router: gin.New(),
+	}
+
+	limiter := ratelimit.NewLimiter(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}))
+	rt.router.Use(RateLimitMiddleware(rt.cfg, limiter, apitoken.NewStore(db), testPolicies))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	rt.router.GET("/api/v1/auth/:provider/redirect", ok)
+	rt.router.POST("/api/v1/auth/refresh", ok)
+	rt.router.GET("/api/v1/auth/me", ok)
+	rt.router.GET("/api/v1/chats", ok)
+	rt.router.POST("/api/v1/chats", ok)
+	rt.router.GET("/api/v1/disabled", ok)
+	return rt
+}
+
+// do sends a request from ip, signed in as userID unless it is uuid.Nil
+func (rt *rateLimitTest) do(t *testing.T, method, path, ip string, userID uuid.UUID) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = ip + ":1234"
+	if userID != uuid.Nil {
+		token, err := utils.GenerateJWT(userID, "ada@example.com", "user", "session-1", rt.cfg.JWTSecret, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	rt.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	rt := newRateLimitTest(t)
+
+	w := rt.do(t, http.MethodPost, "/api/v1/chats", "203.0.113.7", uuid.Nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	for header, want := range map[string]string{
+		"RateLimit-Limit":     "1",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "3600",
+		"RateLimit-Policy":    "1;w=3600",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	if w.Header().Get("Retry-After") != "" {
+		t.Error("allowed request has Retry-After")
+	}
+
+	w = rt.do(t, http.MethodPost, "/api/v1/chats", "203.0.113.7", uuid.Nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3600" {
+		t.Errorf("over the limit: status = %d, Retry-After %q; want 429 after 3600", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	rt := newRateLimitTest(t)
+	ada, grace := uuid.New(), uuid.New()
+
+	rt.do(t, http.MethodPost, "/api/v1/chats", "203.0.113.7", ada)
+
+	// Signed-in users behind one address have limits of their own
+	if w := rt.do(t, http.MethodPost, "/api/v1/chats", "203.0.113.7", grace); w.Code != http.StatusOK {
+		t.Errorf("another user at the same address: status = %d, want 200", w.Code)
+	}
+	// And keep theirs when their address changes
+	if w := rt.do(t, http.MethodPost, "/api/v1/chats", "198.51.100.1", ada); w.Code != http.StatusTooManyRequests {
+		t.Errorf("same user at another address: status = %d, want 429", w.Code)
+	}
+
+	// Anonymous clients are told apart by address
+	rt.do(t, http.MethodPost, "/api/v1/chats", "203.0.113.7", uuid.Nil)
+	if w := rt.do(t, http.MethodPost, "/api/v1/chats", "198.51.100.1", uuid.Nil); w.Code != http.StatusOK {
+		t.Errorf("anonymous client at another address: status = %d, want 200", w.Code)
+	}
+	if w := rt.do(t, http.MethodPost, "/api/v1/chats", "203.0.113.7", uuid.Nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("anonymous client at the same address: status = %d, want 429", w.Code)
+	}
+}
+
+func TestRateLimitPolicyForRequest(t *testing.T) {
+	rt := newRateLimitTest(t)
+
+	tests := []struct {
+		method, path, limit string
+	}{
+		{http.MethodGet, "/api/v1/auth/oidc/redirect", "2"},
+		{http.MethodPost, "/api/v1/auth/refresh", "2"},
+		{http.MethodGet, "/api/v1/auth/me", "5"},
+		{http.MethodGet, "/api/v1/chats", "5"},
+		{http.MethodPost, "/api/v1/chats", "1"},
+		{http.MethodGet, "/api/v1/disabled", ""},
+	}
+
+	for _, tt := range tests {
+		w := rt.do(t, tt.method, tt.path, "203.0.113.7", uuid.Nil)
+		if got := w.Header().Get("RateLimit-Limit"); got != tt.limit {
+			t.Errorf("%s %s: limit = %q, want %q", tt.method, tt.path, got, tt.limit)
+		}
+	}
+
+	// Routes under a prefix share its policy
+	if w := rt.do(t, http.MethodGet, "/api/v1/auth/google/redirect", "203.0.113.7", uuid.Nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("third auth request: status = %d, want 429", w.Code)
+	}
+}
+
+func TestRateLimitFailsOpen(t *testing.T) {
+	rt := newRateLimitTest(t)
+	rt.redis.Close()
+
+	for i := 0; i < 3; i++ {
+		w := rt.do(t, http.MethodPost, "/api/v1/chats", "203.0.113.7", uuid.Nil)
+		if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+			t.Errorf("with Redis down: status = %d, headers %v; want 200 without limits", w.Code, w.Header())
+		}
+	}
+}
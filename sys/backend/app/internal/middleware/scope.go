@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/chatshare/backend/internal/apitoken"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireScope declares the API token scope a route group needs for
+// changes. Safe methods need the read scope instead. Requires
+// AuthMiddleware first; requests signed in with a session are not limited.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, isAPIToken := c.Get("token_scopes")
+		if !isAPIToken {
+			c.Next()
+			return
+		}
+
+		needed := scope
+		if utils.IsSafeMethod(c.Request.Method) {
+			needed = apitoken.ScopeRead
+		}
+
+		for _, granted := range scopes.([]string) {
+			if granted == needed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "API token is missing the " + needed + " scope"})
+		c.Abort()
+	}
+}
+
+// RequireSession keeps API tokens out of a route group. It guards account
+// security and administration, which only a signed-in user may reach.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, isAPIToken := c.Get("api_token_id"); isAPIToken {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API tokens cannot access this endpoint"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
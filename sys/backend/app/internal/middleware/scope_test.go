@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chatshare/backend/internal/apitoken"
+	"github.com/google/uuid"
+)
+
+// expectAPIToken expects the lookup of token, granted scopes, for a user
+// with status
+func (at *authTest) expectAPIToken(token, scopes, status string, expiresAt *time.Time) {
+	userID := uuid.New()
+	at.mock.ExpectQuery(`SELECT \* FROM "api_tokens" WHERE token_hash = \$1 AND revoked_at IS NULL`).
+		WithArgs(apitoken.Hash(token)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "scopes", "expires_at"}).
+			AddRow(uuid.New(), userID, scopes, expiresAt))
+	at.mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role", "status"}).
+			AddRow(userID, "ada@example.com", "moderator", status))
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		scopes string
+		want   int
+	}{
+		{"read with read scope", http.MethodGet, "read", http.StatusOK},
+		{"read without read scope", http.MethodGet, "comments:write", http.StatusForbidden},
+		{"change with write scope", http.MethodPost, "read comments:write", http.StatusOK},
+		{"change with read scope only", http.MethodPost, "read", http.StatusForbidden},
+		{"change with another write scope", http.MethodPost, "read chats:write", http.StatusForbidden},
+		{"no scopes", http.MethodGet, "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := newAuthTest(t, RequireScope(apitoken.ScopeCommentsWrite))
+			at.expectAPIToken("cst_token", tt.scopes, "active", nil)
+
+			if w := at.do(bearer(tt.method, "cst_token")); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestRequireScopeIgnoresSessions(t *testing.T) {
+	at := newAuthTest(t, RequireScope(apitoken.ScopeCommentsWrite))
+	token := at.accessToken(t, uuid.New(), "user", "session-1")
+
+	if w := at.do(bearer(http.MethodPost, token)); w.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", w.Code)
+	}
+}
+
+func TestRequireSession(t *testing.T) {
+	at := newAuthTest(t, RequireSession())
+	at.expectAPIToken("cst_token", "read chats:write comments:write profile:write", "active", nil)
+
+	if w := at.do(bearer(http.MethodGet, "cst_token")); w.Code != http.StatusForbidden {
+		t.Errorf("API token: status = %d, want 403", w.Code)
+	}
+
+	token := at.accessToken(t, uuid.New(), "user", "session-1")
+	if w := at.do(bearer(http.MethodGet, token)); w.Code != http.StatusOK {
+		t.Errorf("session: status = %d, want 200", w.Code)
+	}
+}
+
+func TestAuthMiddlewareRejectsUnusableAPITokens(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+
+	// Revoked and unknown tokens are not found
+	at := newAuthTest(t)
+	at.mock.ExpectQuery(`FROM "api_tokens"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if w := at.do(bearer(http.MethodGet, "cst_revoked")); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token: status = %d, want 401", w.Code)
+	}
+
+	at = newAuthTest(t)
+	at.expectAPIToken("cst_expired", "read", "active", &expired)
+	if w := at.do(bearer(http.MethodGet, "cst_expired")); w.Code != http.StatusUnauthorized {
+		t.Errorf("expired token: status = %d, want 401", w.Code)
+	}
+
+	at = newAuthTest(t)
+	at.expectAPIToken("cst_suspended", "read", "suspended", nil)
+	if w := at.do(bearer(http.MethodGet, "cst_suspended")); w.Code != http.StatusForbidden {
+		t.Errorf("token of a suspended user: status = %d, want 403", w.Code)
+	}
+}
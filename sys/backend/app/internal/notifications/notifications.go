@@ -0,0 +1,135 @@
+package notifications
+
+import (
+	"log"
+
+	"github.com/chatshare/backend/internal/database"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Notification types
+const (
+	TypeComment  = "comment"  // someone commented on your chat
+	TypeReply    = "reply"    // someone replied to your comment
+	TypeFavorite = "favorite" // someone favorited your chat
+	TypeNewChat  = "new_chat" // a user you favorited posted a chat
+
+	TypeReportResolved  = "report_resolved"  // moderators acted on content you reported
+	TypeReportDismissed = "report_dismissed" // moderators found no problem with content you reported
+)
+
+// preferenceColumns maps each type to the users column that opts out of it
+var preferenceColumns = map[string]string{
+	TypeComment:  "notify_comment",
+	TypeReply:    "notify_reply",
+	TypeFavorite: "notify_favorite",
+	TypeNewChat:  "notify_new_chat",
+}
+
+// Notifier fans out notifications for user activity. Failures are logged
+// rather than returned so they never fail the action that caused them.
+type Notifier struct {
+	db *gorm.DB
+}
+
+func NewNotifier(db *gorm.DB) *Notifier {
+	return &Notifier{db: db}
+}
+
+// ChatCommented notifies the chat owner of a new top-level comment
+func (n *Notifier) ChatCommented(chat *database.Chat, comment *database.Comment) {
+	n.notify(chat.UserID, comment.UserID, TypeComment, &chat.ID, &comment.ID)
+}
+
+// CommentReplied notifies the author of the parent comment, and the chat
+// owner when that is someone else
+func (n *Notifier) CommentReplied(chat *database.Chat, parent, reply *database.Comment) {
+	n.notify(parent.UserID, reply.UserID, TypeReply, &chat.ID, &reply.ID)
+	if chat.UserID != parent.UserID {
+		n.notify(chat.UserID, reply.UserID, TypeComment, &chat.ID, &reply.ID)
+	}
+}
+
+// ChatFavorited notifies the chat owner that actorID favorited their chat
+func (n *Notifier) ChatFavorited(chat *database.Chat, actorID uuid.UUID) {
+	n.notify(chat.UserID, actorID, TypeFavorite, &chat.ID, nil)
+}
+
+// ChatPublished notifies everyone who favorited the chat's author. Private
+// chats are not announced.
+func (n *Notifier) ChatPublished(chat *database.Chat) {
+	if !chat.IsPublic || chat.Status != "active" {
+		return
+	}
+
+	err := n.db.Exec(`
+		INSERT INTO notifications (id, user_id, actor_id, type, chat_id, created_at)
+		SELECT gen_random_uuid(), f.user_id, ?, ?, ?, NOW()
+		FROM favorite_users f
+		JOIN users u ON u.id = f.user_id AND u.deleted_at IS NULL
+		WHERE f.target_user_id = ? AND f.user_id <> ? AND u.`+preferenceColumns[TypeNewChat]+` = ?`,
+		chat.UserID, TypeNewChat, chat.ID, chat.UserID, chat.UserID, true).Error
+	if err != nil {
+		log.Printf("Failed to notify followers of user %s about chat %s: %v", chat.UserID, chat.ID, err)
+	}
+}
+
+// ReportsClosed tells each reporter how moderatorID handled their report.
+// Reporters cannot opt out of these.
+func (n *Notifier) ReportsClosed(reports []database.Report, moderatorID uuid.UUID) {
+	if len(reports) == 0 {
+		return
+	}
+
+	notifications := make([]database.Notification, 0, len(reports))
+	for _, report := range reports {
+		notificationType := TypeReportDismissed
+		if report.Status == "resolved" {
+			notificationType = TypeReportResolved
+		}
+		chatID := report.ChatID
+		notifications = append(notifications, database.Notification{
+			ID:        uuid.New(),
+			UserID:    report.ReporterID,
+			ActorID:   moderatorID,
+			Type:      notificationType,
+			ChatID:    &chatID,
+			CommentID: report.CommentID,
+		})
+	}
+
+	if err := n.db.Create(&notifications).Error; err != nil {
+		log.Printf("Failed to notify %d reporters: %v", len(notifications), err)
+	}
+}
+
+// notify creates a notification unless the recipient caused it or opted out
+func (n *Notifier) notify(userID, actorID uuid.UUID, notificationType string, chatID, commentID *uuid.UUID) {
+	if userID == actorID {
+		return
+	}
+
+	var enabled int64
+	if err := n.db.Model(&database.User{}).
+		Where("id = ? AND "+preferenceColumns[notificationType]+" = ?", userID, true).
+		Count(&enabled).Error; err != nil {
+		log.Printf("Failed to read notification preferences of user %s: %v", userID, err)
+		return
+	}
+	if enabled == 0 {
+		return
+	}
+
+	notification := database.Notification{
+		ID:        uuid.New(),
+		UserID:    userID,
+		ActorID:   actorID,
+		Type:      notificationType,
+		ChatID:    chatID,
+		CommentID: commentID,
+	}
+	if err := n.db.Create(&notification).Error; err != nil {
+		log.Printf("Failed to create %s notification for user %s: %v", notificationType, userID, err)
+	}
+}
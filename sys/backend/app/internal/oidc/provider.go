@@ -0,0 +1,266 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chatshare/backend/internal/config"
+	"golang.org/x/oauth2"
+)
+
+var ErrNoSubject = errors.New("provider did not identify the user")
+
+// Identity is the account a provider signed in, after claim mapping
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+}
+
+// Provider is a login provider configured at runtime. OpenID Connect
+// providers are discovered from their issuer and identify the user with a
+// verified ID token; plain OAuth 2.0 providers use their user info endpoint.
+type Provider struct {
+	cfg    config.OIDCProviderConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	oauth       *oauth2.Config // nil until the endpoints are known
+	verifier    *Verifier      // nil for plain OAuth 2.0 providers
+	userInfoURL string
+}
+
+func NewProvider(cfg config.OIDCProviderConfig) *Provider {
+	return &Provider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *Provider) DisplayName() string {
+	return p.cfg.DisplayName
+}
+
+// AuthCodeURL returns the URL that starts a sign-in
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, opts ...oauth2.AuthCodeOption) (string, error) {
+	conf, err := p.endpoints(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for key, value := range p.cfg.AuthParams {
+		opts = append(opts, oauth2.SetAuthURLParam(key, value))
+	}
+	return conf.AuthCodeURL(state, opts...), nil
+}
+
+// Exchange redeems an authorization code and returns the signed-in account.
+// nonce is the one sent with the authorization request.
+func (p *Provider) Exchange(ctx context.Context, code, nonce string, opts ...oauth2.AuthCodeOption) (*Identity, error) {
+	conf, err := p.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := conf.Exchange(ctx, code, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+
+	var claims map[string]interface{}
+	if rawIDToken, _ := token.Extra("id_token").(string); rawIDToken != "" && p.verifier != nil {
+		idToken, err := p.verifier.Verify(ctx, rawIDToken, nonce)
+		if err != nil {
+			return nil, err
+		}
+		claims = idToken.Raw
+	}
+
+	// User info fills in what the ID token left out, or is all there is
+	if p.userInfoURL != "" {
+		userInfo, err := p.fetchUserInfo(ctx, conf.Client(ctx, token))
+		if err != nil && claims == nil {
+			return nil, err
+		}
+		if err == nil {
+			if claims == nil {
+				claims = userInfo
+			} else if !sameSubject(claims, userInfo) {
+				return nil, errors.New("user info is for a different user than the ID token")
+			} else {
+				for key, value := range userInfo {
+					if _, ok := claims[key]; !ok {
+						claims[key] = value
+					}
+				}
+			}
+		}
+	}
+
+	if claims == nil {
+		return nil, errors.New("provider returned neither an ID token nor user info")
+	}
+
+	identity := &Identity{
+		Subject:       claimString(claims, p.claim("sub")),
+		Email:         claimString(claims, p.claim("email")),
+		EmailVerified: claimString(claims, p.claim("email_verified")) == "true",
+		Name:          claimString(claims, p.claim("name")),
+		Picture:       claimString(claims, p.claim("picture")),
+	}
+	if identity.Subject == "" {
+		return nil, ErrNoSubject
+	}
+	return identity, nil
+}
+
+// claim returns the provider's name for a standard claim
+func (p *Provider) claim(name string) string {
+	if mapped := p.cfg.Claims[name]; mapped != "" {
+		return mapped
+	}
+	return name
+}
+
+// endpoints returns the OAuth configuration, running discovery the first
+// time. A failed discovery is retried on the next call.
+func (p *Provider) endpoints(ctx context.Context) (*oauth2.Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth != nil {
+		return p.oauth, nil
+	}
+
+	authURL, tokenURL := p.cfg.AuthURL, p.cfg.TokenURL
+	userInfoURL, jwksURL := p.cfg.UserInfoURL, p.cfg.JWKSURL
+	issuer := p.cfg.Issuer
+
+	if p.cfg.Issuer != "" {
+		doc, err := p.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		issuer = doc.Issuer
+		authURL = firstNonEmpty(authURL, doc.AuthorizationEndpoint)
+		tokenURL = firstNonEmpty(tokenURL, doc.TokenEndpoint)
+		userInfoURL = firstNonEmpty(userInfoURL, doc.UserInfoEndpoint)
+		jwksURL = firstNonEmpty(jwksURL, doc.JWKSURI)
+	}
+
+	if authURL == "" || tokenURL == "" {
+		return nil, fmt.Errorf("provider %s has no authorization or token endpoint", p.cfg.Name)
+	}
+
+	if jwksURL != "" {
+		p.verifier = &Verifier{
+			Issuer:   issuer,
+			ClientID: p.cfg.ClientID,
+			Keys:     NewKeySet(jwksURL),
+		}
+	}
+	p.userInfoURL = userInfoURL
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Scopes:       p.cfg.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  authURL,
+			TokenURL: tokenURL,
+		},
+	}
+	return p.oauth, nil
+}
+
+// discoveryDocument is the part of an OpenID Provider's metadata that
+// sign-in uses
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func (p *Provider) discover(ctx context.Context) (*discoveryDocument, error) {
+	url := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+
+	var doc discoveryDocument
+	if err := getJSON(ctx, p.client, url, &doc); err != nil {
+		return nil, fmt.Errorf("discover %s: %w", p.cfg.Name, err)
+	}
+	if doc.Issuer == "" {
+		return nil, fmt.Errorf("discover %s: metadata has no issuer", p.cfg.Name)
+	}
+	return &doc, nil
+}
+
+func (p *Provider) fetchUserInfo(ctx context.Context, client *http.Client) (map[string]interface{}, error) {
+	var claims map[string]interface{}
+	if err := getJSON(ctx, client, p.userInfoURL, &claims); err != nil {
+		return nil, fmt.Errorf("fetch user info: %w", err)
+	}
+	return claims, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+
+	// Numbers such as GitHub's user IDs must keep every digit
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func sameSubject(idToken, userInfo map[string]interface{}) bool {
+	sub := claimString(userInfo, "sub")
+	return sub == "" || sub == claimString(idToken, "sub")
+}
+
+// claimString formats a claim value, which may be a string, number or bool
+func claimString(claims map[string]interface{}, name string) string {
+	switch value := claims[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,314 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chatshare/backend/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIssuer is an OpenID Provider serving discovery, keys, tokens and user
+// info. Each authorization code stands for the claims of the ID token it is
+// redeemed for.
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	idTokens map[string]jwt.MapClaims // code -> ID token claims; nil for no ID token
+	userInfo map[string]interface{}   // nil to fail the user info request
+	noJWKS   bool                     // serve plain OAuth 2.0 without discovery
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockIssuer{key: key, idTokens: map[string]jwt.MapClaims{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		if issuer.noJWKS {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"userinfo_endpoint":      issuer.server.URL + "/userinfo",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "key-1",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		claims, ok := issuer.idTokens[r.Form.Get("code")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		response := map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		}
+		if claims != nil {
+			response["id_token"] = issuer.sign(t, claims)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" || issuer.userInfo == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(issuer.userInfo)
+	})
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (m *mockIssuer) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "key-1"
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// idToken returns the claims of a valid ID token for client-id
+func (m *mockIssuer) idToken(sub, nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   m.server.URL,
+		"aud":   "client-id",
+		"sub":   sub,
+		"nonce": nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func (m *mockIssuer) provider(claims map[string]string) *Provider {
+	return NewProvider(config.OIDCProviderConfig{
+		Name:         "mock",
+		Issuer:       m.server.URL,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://chatshare.test/auth/mock/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+		Claims:       claims,
+		AuthParams:   map[string]string{"prompt": "select_account"},
+	})
+}
+
+func TestProviderAuthCodeURL(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	authURL, err := issuer.provider(nil).AuthCodeURL(context.Background(), "state-token")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, issuer.server.URL+"/authorize?") {
+		t.Errorf("URL %s does not use the discovered endpoint", authURL)
+	}
+	query := parsed.Query()
+	if query.Get("state") != "state-token" || query.Get("client_id") != "client-id" || query.Get("prompt") != "select_account" {
+		t.Errorf("unexpected query %v", query)
+	}
+}
+
+func TestProviderExchange(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	claims := issuer.idToken("user-1", "nonce-1")
+	claims["email"] = "ada@example.com"
+	claims["email_verified"] = true
+	issuer.idTokens["code"] = claims
+	// User info completes the ID token without overriding it
+	issuer.userInfo = map[string]interface{}{
+		"sub":     "user-1",
+		"email":   "other@example.com",
+		"name":    "Ada Lovelace",
+		"picture": "https://example.com/ada.png",
+	}
+
+	identity, err := issuer.provider(nil).Exchange(context.Background(), "code", "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	want := Identity{
+		Subject:       "user-1",
+		Email:         "ada@example.com",
+		EmailVerified: true,
+		Name:          "Ada Lovelace",
+		Picture:       "https://example.com/ada.png",
+	}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestProviderExchangeRejectsInvalidIDTokens(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.userInfo = map[string]interface{}{"sub": "user-1"}
+
+	issuer.idTokens["good"] = issuer.idToken("user-1", "nonce-1")
+
+	otherClient := issuer.idToken("user-1", "nonce-1")
+	otherClient["aud"] = "other-client"
+	issuer.idTokens["other-client"] = otherClient
+
+	otherIssuer := issuer.idToken("user-1", "nonce-1")
+	otherIssuer["iss"] = "https://issuer.example.com"
+	issuer.idTokens["other-issuer"] = otherIssuer
+
+	expired := issuer.idToken("user-1", "nonce-1")
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	issuer.idTokens["expired"] = expired
+
+	tests := []struct {
+		name  string
+		code  string
+		nonce string
+		want  error
+	}{
+		{"bad nonce", "good", "nonce-2", ErrNonceMismatch},
+		{"missing nonce", "good", "", ErrNonceMismatch},
+		{"bad audience", "other-client", "nonce-1", ErrInvalidIDToken},
+		{"bad issuer", "other-issuer", "nonce-1", ErrInvalidIDToken},
+		{"expired", "expired", "nonce-1", ErrInvalidIDToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := issuer.provider(nil).Exchange(context.Background(), tt.code, tt.nonce)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderExchangeRejectsUserInfoOfAnotherSubject(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.idTokens["code"] = issuer.idToken("user-1", "nonce-1")
+	issuer.userInfo = map[string]interface{}{"sub": "user-2", "email": "mallory@example.com"}
+
+	if _, err := issuer.provider(nil).Exchange(context.Background(), "code", "nonce-1"); err == nil {
+		t.Error("Exchange accepted user info for a different subject")
+	}
+}
+
+func TestProviderExchangeMapsClaims(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	claims := issuer.idToken("pairwise-id", "nonce-1")
+	claims["oid"] = "object-id"
+	claims["preferred_username"] = "ada@example.com"
+	claims["mail_verified"] = "true"
+	issuer.idTokens["code"] = claims
+	issuer.userInfo = map[string]interface{}{"sub": "pairwise-id", "displayName": "Ada Lovelace"}
+
+	provider := issuer.provider(map[string]string{
+		"sub":            "oid",
+		"email":          "preferred_username",
+		"email_verified": "mail_verified",
+		"name":           "displayName",
+	})
+
+	identity, err := provider.Exchange(context.Background(), "code", "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	want := Identity{Subject: "object-id", Email: "ada@example.com", EmailVerified: true, Name: "Ada Lovelace"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestPlainOAuth2Provider(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.noJWKS = true
+	issuer.idTokens["code"] = nil
+	// GitHub identifies users by a number that must not lose digits
+	issuer.userInfo = map[string]interface{}{
+		"id":         json.Number("9007199254740993"),
+		"login":      "ada",
+		"email":      "ada@example.com",
+		"avatar_url": "https://example.com/ada.png",
+	}
+
+	provider := NewProvider(config.OIDCProviderConfig{
+		Name:         "github",
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		AuthURL:      issuer.server.URL + "/authorize",
+		TokenURL:     issuer.server.URL + "/token",
+		UserInfoURL:  issuer.server.URL + "/userinfo",
+		Claims: map[string]string{
+			"sub":     "id",
+			"name":    "login",
+			"picture": "avatar_url",
+		},
+	})
+
+	identity, err := provider.Exchange(context.Background(), "code", "")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	want := Identity{
+		Subject: "9007199254740993",
+		Email:   "ada@example.com",
+		Name:    "ada",
+		Picture: "https://example.com/ada.png",
+	}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+
+	// Without an ID token there is nothing else to identify the user
+	issuer.userInfo = nil
+	if _, err := provider.Exchange(context.Background(), "code", ""); err == nil {
+		t.Error("Exchange succeeded without user info")
+	}
+}
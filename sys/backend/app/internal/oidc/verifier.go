@@ -0,0 +1,128 @@
+// Package oidc verifies OpenID Connect ID tokens
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrNonceMismatch  = errors.New("ID token nonce does not match")
+)
+
+// Verifier checks the ID tokens an issuer hands out to one client
+type Verifier struct {
+	// Issuer may contain {tenantid}, which matches the token's tid claim
+	// (Microsoft's multi-tenant endpoint)
+	Issuer   string
+	ClientID string
+
+	// ClientSecret verifies HS256 tokens, which some providers (LINE web
+	// login) sign with the client secret. Leave empty to refuse HS256.
+	ClientSecret string
+
+	// Keys verifies RS256 and ES256 tokens. Leave nil to refuse them.
+	Keys *KeySet
+}
+
+// Claims are the standard claims of an ID token that sign-in needs
+type Claims struct {
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified FlexBool `json:"email_verified"`
+	Name          string   `json:"name"`
+	Picture       string   `json:"picture"`
+	jwt.RegisteredClaims
+
+	// Raw holds every claim of the token, for providers whose claims need
+	// mapping
+	Raw map[string]interface{} `json:"-"`
+}
+
+// Verify checks an ID token's signature, issuer, audience and expiry, and
+// that it carries the nonce sent with the authorization request. Pass an
+// empty nonce only when none was sent.
+func (v *Verifier) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if v.ClientSecret == "" {
+				return nil, errors.New("HMAC-signed ID tokens are not accepted")
+			}
+			return []byte(v.ClientSecret), nil
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+			if v.Keys == nil {
+				return nil, errors.New("no signing keys configured")
+			}
+			kid, _ := token.Header["kid"].(string)
+			return v.Keys.Key(ctx, kid)
+		default:
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+	},
+		jwt.WithValidMethods([]string{"HS256", "RS256", "ES256"}),
+		jwt.WithAudience(v.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	// The signature is good, so the payload can be read again as a map
+	payload, err := base64.RawURLEncoding.DecodeString(strings.Split(rawIDToken, ".")[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims.Raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	issuer := v.Issuer
+	if strings.Contains(issuer, "{tenantid}") {
+		tenantID, _ := claims.Raw["tid"].(string)
+		issuer = strings.ReplaceAll(issuer, "{tenantid}", tenantID)
+	}
+	if claims.Issuer != issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+	if claims.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	return claims, nil
+}
+
+// FlexBool decodes a boolean claim that some providers send as a string
+type FlexBool bool
+
+func (b *FlexBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case bool:
+		*b = FlexBool(v)
+	case string:
+		*b = FlexBool(v == "true")
+	default:
+		*b = false
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package providers
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// sharePath returns a share ID extractor matching the link path against
+// pattern, whose first capture group is the share ID
+func sharePath(pattern string) func(u *url.URL) (string, bool) {
+	re := regexp.MustCompile("^" + pattern + "/?$")
+	return func(u *url.URL) (string, bool) {
+		m := re.FindStringSubmatch(u.EscapedPath())
+		if m == nil {
+			return "", false
+		}
+		return m[1], true
+	}
+}
+
+// canonical returns a canonical URL builder from a format with one %s verb
+func canonical(format string) func(shareID string) string {
+	return func(shareID string) string {
+		return fmt.Sprintf(format, url.PathEscape(shareID))
+	}
+}
+
+func builtinProviders() []*Provider {
+	return []*Provider{
+		{
+			Name:         "chatgpt",
+			DisplayName:  "ChatGPT",
+			Hosts:        []string{"chatgpt.com", "chat.openai.com"},
+			ShareID:      sharePath(`/share/(?:e/)?([A-Za-z0-9-]+)`),
+			CanonicalURL: canonical("https://chatgpt.com/share/%s"),
+			NotFoundMarkers: []string{
+				"this shared link has been disabled",
+				"unable to load conversation",
+				"shared conversation not found",
+			},
+		},
+		{
+			Name:         "claude",
+			DisplayName:  "Claude",
+			Hosts:        []string{"claude.ai"},
+			ShareID:      sharePath(`/share/([A-Za-z0-9-]+)`),
+			CanonicalURL: canonical("https://claude.ai/share/%s"),
+			NotFoundMarkers: []string{
+				"this conversation was deleted",
+				"this shared chat is no longer available",
+			},
+		},
+		{
+			Name:         "copilot",
+			DisplayName:  "Microsoft Copilot",
+			Hosts:        []string{"copilot.microsoft.com", "bing.com"},
+			ShareID:      copilotShareID,
+			CanonicalURL: copilotCanonicalURL,
+			NotFoundMarkers: []string{
+				"this conversation is no longer available",
+				"conversation not found",
+			},
+		},
+		{
+			Name:         "gemini",
+			DisplayName:  "Google Gemini",
+			Hosts:        []string{"gemini.google.com", "g.co"},
+			ShareID:      geminiShareID,
+			CanonicalURL: canonical("https://gemini.google.com/share/%s"),
+		},
+		{
+			Name:         "perplexity",
+			DisplayName:  "Perplexity",
+			Hosts:        []string{"perplexity.ai"},
+			ShareID:      sharePath(`/search/([A-Za-z0-9._-]+)`),
+			CanonicalURL: canonical("https://www.perplexity.ai/search/%s"),
+		},
+		{
+			Name:         "poe",
+			DisplayName:  "Poe",
+			Hosts:        []string{"poe.com"},
+			ShareID:      sharePath(`/s/([A-Za-z0-9]+)`),
+			CanonicalURL: canonical("https://poe.com/s/%s"),
+		},
+		{
+			Name:         "deepseek",
+			DisplayName:  "DeepSeek",
+			Hosts:        []string{"chat.deepseek.com"},
+			ShareID:      sharePath(`/share/([A-Za-z0-9-]+)`),
+			CanonicalURL: canonical("https://chat.deepseek.com/share/%s"),
+		},
+		{
+			Name:         "grok",
+			DisplayName:  "Grok",
+			Hosts:        []string{"grok.com", "x.com"},
+			ShareID:      grokShareID,
+			CanonicalURL: canonical("https://grok.com/share/%s"),
+		},
+	}
+}
+
+var (
+	copilotSharePath = sharePath(`/shares/([A-Za-z0-9-]+)`)
+	geminiSharePath  = sharePath(`/share/([A-Za-z0-9]+)`)
+	geminiShortPath  = sharePath(`/gemini/share/([A-Za-z0-9]+)`)
+	grokSharePath    = sharePath(`/share/([A-Za-z0-9_-]+)`)
+	grokOnXPath      = sharePath(`/i/grok/share/([A-Za-z0-9_-]+)`)
+)
+
+// bingSharePrefix marks share IDs of bing.com links. Nothing says a Bing
+// share is reachable on copilot.microsoft.com, so they keep their own host.
+const bingSharePrefix = "bing:"
+
+// copilotShareID accepts copilot.microsoft.com/shares/<id> and the older
+// bing.com/chat share links, which carry the ID in a query parameter
+func copilotShareID(u *url.URL) (string, bool) {
+	if normalizeHost(u.Hostname()) == "bing.com" {
+		if !strings.HasPrefix(u.Path, "/chat") {
+			return "", false
+		}
+		id := u.Query().Get("shareId")
+		return bingSharePrefix + id, id != ""
+	}
+	return copilotSharePath(u)
+}
+
+func copilotCanonicalURL(shareID string) string {
+	if id, ok := strings.CutPrefix(shareID, bingSharePrefix); ok {
+		return "https://www.bing.com/chat?shareId=" + url.QueryEscape(id)
+	}
+	return "https://copilot.microsoft.com/shares/" + url.PathEscape(shareID)
+}
+
+// geminiShareID accepts gemini.google.com/share/<id> and g.co/gemini/share/<id>
+func geminiShareID(u *url.URL) (string, bool) {
+	if normalizeHost(u.Hostname()) == "g.co" {
+		return geminiShortPath(u)
+	}
+	return geminiSharePath(u)
+}
+
+// grokShareID accepts grok.com/share/<id> and x.com/i/grok/share/<id>
+func grokShareID(u *url.URL) (string, bool) {
+	if normalizeHost(u.Hostname()) == "x.com" {
+		return grokOnXPath(u)
+	}
+	return grokSharePath(u)
+}
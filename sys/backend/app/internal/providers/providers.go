@@ -0,0 +1,157 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrInvalidLink      = errors.New("link is not a valid http(s) URL")
+	ErrUnsupportedHost  = errors.New("host is not a supported chat provider")
+	ErrInvalidShareLink = errors.New("link is not a share link")
+)
+
+// Provider describes an AI chat service whose share links can be posted
+type Provider struct {
+	// Name is stored in Chat.ChatType
+	Name        string   `json:"name"`
+	DisplayName string   `json:"display_name"`
+	Hosts       []string `json:"hosts"`
+
+	// ShareID extracts the share identifier from a link on one of Hosts
+	ShareID func(u *url.URL) (string, bool) `json:"-"`
+
+	// CanonicalURL builds the canonical share link for a share identifier
+	CanonicalURL func(shareID string) string `json:"-"`
+
+	// NotFoundMarkers are phrases the provider renders on share pages whose
+	// conversation was deleted or unshared, even though they answer 200 OK
+	NotFoundMarkers []string `json:"-"`
+}
+
+// Match is a link resolved against the registry
+type Match struct {
+	Provider     *Provider
+	ShareID      string
+	CanonicalURL string
+}
+
+// Registry maps link hosts to providers
+type Registry struct {
+	mu        sync.RWMutex
+	providers []*Provider
+	byHost    map[string]*Provider
+}
+
+func NewRegistry(providers ...*Provider) *Registry {
+	r := &Registry{byHost: make(map[string]*Provider)}
+	for _, p := range providers {
+		r.Register(p)
+	}
+	return r
+}
+
+// Register adds a provider, replacing any earlier provider with the same name
+// or hosts
+func (r *Registry) Register(p *Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, existing := range r.providers {
+		if existing.Name == p.Name {
+			for host, owner := range r.byHost {
+				if owner == existing {
+					delete(r.byHost, host)
+				}
+			}
+			r.providers = append(r.providers[:i], r.providers[i+1:]...)
+			break
+		}
+	}
+	r.providers = append(r.providers, p)
+
+	for _, host := range p.Hosts {
+		r.byHost[normalizeHost(host)] = p
+	}
+}
+
+// Lookup returns the provider serving host, or nil
+func (r *Registry) Lookup(host string) *Provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byHost[normalizeHost(host)]
+}
+
+// Get returns the provider with the given name, or nil
+func (r *Registry) Get(name string) *Provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, p := range r.providers {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// List returns the registered providers sorted by name
+func (r *Registry) List() []*Provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]*Provider, len(r.providers))
+	copy(list, r.providers)
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Resolve identifies the provider of a share link and extracts its share ID
+func (r *Registry) Resolve(link string) (*Match, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidLink
+	}
+
+	p := r.Lookup(u.Hostname())
+	if p == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedHost, u.Hostname())
+	}
+
+	shareID, ok := p.ShareID(u)
+	if !ok {
+		return nil, fmt.Errorf("%s %w", p.DisplayName, ErrInvalidShareLink)
+	}
+
+	return &Match{
+		Provider:     p,
+		ShareID:      shareID,
+		CanonicalURL: p.CanonicalURL(shareID),
+	}, nil
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
+
+// Default is the registry of built-in providers
+var Default = NewRegistry(builtinProviders()...)
+
+// Resolve resolves a link against the default registry
+func Resolve(link string) (*Match, error) {
+	return Default.Resolve(link)
+}
+
+// Lookup returns the default registry's provider for host, or nil
+func Lookup(host string) *Provider {
+	return Default.Lookup(host)
+}
+
+// List returns the providers of the default registry
+func List() []*Provider {
+	return Default.List()
+}
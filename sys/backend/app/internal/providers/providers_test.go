@@ -0,0 +1,51 @@
+package providers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		link      string
+		provider  string
+		shareID   string
+		canonical string
+	}{
+		{"https://chat.openai.com/share/e/abc-123", "chatgpt", "abc-123", "https://chatgpt.com/share/abc-123"},
+		{"https://claude.ai/share/abc-123/", "claude", "abc-123", "https://claude.ai/share/abc-123"},
+		{"https://copilot.microsoft.com/shares/abc-123", "copilot", "abc-123", "https://copilot.microsoft.com/shares/abc-123"},
+		// Bing shares stay on Bing and apart from Copilot shares with the same ID
+		{"https://www.bing.com/chat?form=NTPCHB&shareId=abc-123", "copilot", "bing:abc-123", "https://www.bing.com/chat?shareId=abc-123"},
+		{"https://g.co/gemini/share/abc123", "gemini", "abc123", "https://gemini.google.com/share/abc123"},
+		{"https://x.com/i/grok/share/abc_123", "grok", "abc_123", "https://grok.com/share/abc_123"},
+	}
+
+	for _, tt := range tests {
+		match, err := Resolve(tt.link)
+		if err != nil {
+			t.Errorf("Resolve(%q): %v", tt.link, err)
+			continue
+		}
+		if match.Provider.Name != tt.provider || match.ShareID != tt.shareID || match.CanonicalURL != tt.canonical {
+			t.Errorf("Resolve(%q) = %s, %q, %q; want %s, %q, %q", tt.link,
+				match.Provider.Name, match.ShareID, match.CanonicalURL, tt.provider, tt.shareID, tt.canonical)
+		}
+	}
+}
+
+func TestResolveRejects(t *testing.T) {
+	tests := map[string]error{
+		"ftp://chatgpt.com/share/abc":       ErrInvalidLink,
+		"https://example.com/share/abc":     ErrUnsupportedHost,
+		"https://chatgpt.com/c/abc":         ErrInvalidShareLink,
+		"https://www.bing.com/search?q=abc": ErrInvalidShareLink,
+		"https://www.bing.com/chat":         ErrInvalidShareLink,
+	}
+
+	for link, want := range tests {
+		if _, err := Resolve(link); !errors.Is(err, want) {
+			t.Errorf("Resolve(%q): err = %v, want %v", link, err, want)
+		}
+	}
+}
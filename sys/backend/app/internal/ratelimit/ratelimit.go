@@ -0,0 +1,102 @@
+// Package ratelimit limits how often a client may make requests, using a
+// sliding window kept in Redis. Each policy has its own window, so a client
+// that used up the comment limit can still browse.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const KeyPrefix = "rate_limit:"
+
+// Policy allows Limit requests in any Window. Routes sharing a policy share
+// its limit.
+type Policy struct {
+	Name   string
+	Limit  int // zero disables the policy
+	Window time.Duration
+}
+
+// Decision is the outcome of a request against a policy
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Duration // until the oldest request in the window expires and frees a slot
+}
+
+// slidingWindow records requests in a sorted set scored by time, in
+// microseconds from the Redis clock so that every server agrees. Requests
+// older than the window are dropped; a request is allowed, and recorded,
+// while fewer than the limit remain.
+//
+// KEYS[1] the client's key; ARGV[1] window in microseconds, ARGV[2] limit,
+// ARGV[3] a unique member for this request.
+// Returns {allowed, remaining, microseconds until a slot frees}.
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[3])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', key, math.ceil(window / 1000))
+
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+
+return {allowed, limit - count, reset}
+`)
+
+// Limiter checks requests against policies
+type Limiter struct {
+	redis *redis.Client
+}
+
+func NewLimiter(redisClient *redis.Client) *Limiter {
+	return &Limiter{redis: redisClient}
+}
+
+// Allow records a request by the client identified by key, unless the
+// client has used up the policy's limit
+func (l *Limiter) Allow(ctx context.Context, policy Policy, key string) (Decision, error) {
+	if policy.Limit <= 0 {
+		return Decision{Allowed: true}, nil
+	}
+
+	result, err := slidingWindow.Run(ctx, l.redis,
+		[]string{KeyPrefix + policy.Name + ":" + key},
+		policy.Window.Microseconds(), policy.Limit, uuid.NewString(),
+	).Int64Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(result) != 3 {
+		return Decision{}, fmt.Errorf("unexpected rate limit script result %v", result)
+	}
+
+	return Decision{
+		Allowed:   result[0] == 1,
+		Limit:     policy.Limit,
+		Remaining: int(result[1]),
+		Reset:     time.Duration(result[2]) * time.Microsecond,
+	}, nil
+}
@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestLimiter(t *testing.T) (*Limiter, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	server.SetTime(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	return NewLimiter(redis.NewClient(&redis.Options{Addr: server.Addr()})), server
+}
+
+func TestAllowCountsDownToTheLimit(t *testing.T) {
+	limiter, _ := newTestLimiter(t)
+	policy := Policy{Name: "comments", Limit: 3, Window: time.Minute}
+	ctx := context.Background()
+
+	for want := 2; want >= 0; want-- {
+		decision, err := limiter.Allow(ctx, policy, "user:1")
+		if err != nil || !decision.Allowed || decision.Remaining != want || decision.Limit != 3 {
+			t.Fatalf("decision = %+v, %v; want allowed with %d remaining", decision, err, want)
+		}
+	}
+
+	decision, err := limiter.Allow(ctx, policy, "user:1")
+	if err != nil || decision.Allowed || decision.Remaining != 0 {
+		t.Fatalf("over the limit: decision = %+v, %v", decision, err)
+	}
+	if decision.Reset != time.Minute {
+		t.Errorf("reset = %v, want a minute", decision.Reset)
+	}
+}
+
+func TestAllowSlidesTheWindow(t *testing.T) {
+	limiter, server := newTestLimiter(t)
+	policy := Policy{Name: "auth", Limit: 2, Window: time.Minute}
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	limiter.Allow(ctx, policy, "ip:203.0.113.7")
+	server.SetTime(start.Add(30 * time.Second))
+	limiter.Allow(ctx, policy, "ip:203.0.113.7")
+
+	// Just before the first request leaves the window
+	server.SetTime(start.Add(time.Minute - time.Millisecond))
+	decision, _ := limiter.Allow(ctx, policy, "ip:203.0.113.7")
+	if decision.Allowed {
+		t.Fatal("allowed a third request within the window")
+	}
+	if decision.Reset != time.Millisecond {
+		t.Errorf("reset = %v, want 1ms", decision.Reset)
+	}
+
+	// Once it has, one slot is free, but the second request still counts
+	server.SetTime(start.Add(time.Minute))
+	if decision, _ := limiter.Allow(ctx, policy, "ip:203.0.113.7"); !decision.Allowed || decision.Remaining != 0 {
+		t.Errorf("after the first request expired: decision = %+v", decision)
+	}
+	if decision, _ := limiter.Allow(ctx, policy, "ip:203.0.113.7"); decision.Allowed {
+		t.Error("allowed a request beyond the freed slot")
+	}
+}
+
+func TestAllowKeepsKeysAndPoliciesApart(t *testing.T) {
+	limiter, _ := newTestLimiter(t)
+	ctx := context.Background()
+	auth := Policy{Name: "auth", Limit: 1, Window: time.Minute}
+	read := Policy{Name: "read", Limit: 1, Window: time.Minute}
+
+	limiter.Allow(ctx, auth, "user:1")
+	if decision, _ := limiter.Allow(ctx, auth, "user:2"); !decision.Allowed {
+		t.Error("one user's requests counted against another")
+	}
+	if decision, _ := limiter.Allow(ctx, read, "user:1"); !decision.Allowed {
+		t.Error("one policy's requests counted against another")
+	}
+}
+
+func TestAllowWithoutLimit(t *testing.T) {
+	limiter, server := newTestLimiter(t)
+	server.Close()
+
+	// A disabled policy does not need Redis
+	decision, err := limiter.Allow(context.Background(), Policy{Name: "read", Window: time.Minute}, "user:1")
+	if err != nil || !decision.Allowed {
+		t.Errorf("decision = %+v, %v", decision, err)
+	}
+}
@@ -0,0 +1,85 @@
+// Package rbac maps user roles to the permissions they grant
+package rbac
+
+// Roles a user can have
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleCurator   = "curator" // manages categories
+	RoleAdmin     = "admin"
+)
+
+// Roles lists every role in order of increasing access
+var Roles = []string{RoleUser, RoleCurator, RoleModerator, RoleAdmin}
+
+// Permission is an action on the admin API
+type Permission string
+
+const (
+	PermUsersRead        Permission = "users:read"        // list users and their activity
+	PermUsersManage      Permission = "users:manage"      // change account status
+	PermUsersRoles       Permission = "users:roles"       // change roles
+	PermChatsRead        Permission = "chats:read"        // list all chats and duplicates
+	PermChatsModerate    Permission = "chats:moderate"    // flag, hide or remove chats
+	PermChatsMaintain    Permission = "chats:maintain"    // data migrations
+	PermCommentsModerate Permission = "comments:moderate" // remove any comment
+	PermReportsReview    Permission = "reports:review"    // work the moderation queue
+	PermCategoriesManage Permission = "categories:manage" // create and edit categories
+	PermCategoriesDelete Permission = "categories:delete"
+	PermStatisticsRead   Permission = "statistics:read"
+	PermAuditRead        Permission = "audit:read"       // read and export the audit log
+	PermScreeningManage  Permission = "screening:manage" // edit the content screening rules
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleUser: {},
+	RoleCurator: {
+		PermCategoriesManage,
+		PermCategoriesDelete,
+	},
+	RoleModerator: {
+		PermUsersRead,
+		PermChatsRead,
+		PermChatsModerate,
+		PermCommentsModerate,
+		PermReportsReview,
+		PermScreeningManage,
+		PermStatisticsRead,
+	},
+	RoleAdmin: {
+		PermUsersRead,
+		PermUsersManage,
+		PermUsersRoles,
+		PermChatsRead,
+		PermChatsModerate,
+		PermChatsMaintain,
+		PermCommentsModerate,
+		PermReportsReview,
+		PermCategoriesManage,
+		PermCategoriesDelete,
+		PermScreeningManage,
+		PermStatisticsRead,
+		PermAuditRead,
+	},
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Can reports whether role grants perm. Unknown roles grant nothing.
+func Can(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions returns the permissions role grants
+func Permissions(role string) []Permission {
+	return append([]Permission{}, rolePermissions[role]...)
+}
@@ -0,0 +1,53 @@
+package rbac
+
+import "testing"
+
+func TestCan(t *testing.T) {
+	tests := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{RoleAdmin, PermUsersRoles, true},
+		{RoleAdmin, PermAuditRead, true},
+		{RoleModerator, PermCommentsModerate, true},
+		{RoleModerator, PermReportsReview, true},
+		{RoleModerator, PermUsersRoles, false},
+		{RoleModerator, PermAuditRead, false},
+		{RoleModerator, PermCategoriesManage, false},
+		{RoleCurator, PermCategoriesDelete, true},
+		{RoleCurator, PermChatsModerate, false},
+		{RoleUser, PermChatsRead, false},
+		{"superuser", PermChatsRead, false},
+		{"", PermChatsRead, false},
+	}
+
+	for _, tt := range tests {
+		if got := Can(tt.role, tt.perm); got != tt.want {
+			t.Errorf("Can(%q, %s) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestValidRole(t *testing.T) {
+	for _, role := range Roles {
+		if !ValidRole(role) {
+			t.Errorf("ValidRole(%q) = false", role)
+		}
+	}
+	if ValidRole("superuser") || ValidRole("") {
+		t.Error("unknown role is valid")
+	}
+}
+
+func TestPermissionsReturnsACopy(t *testing.T) {
+	perms := Permissions(RoleModerator)
+	perms[0] = PermUsersRoles
+
+	if Can(RoleModerator, PermUsersRoles) {
+		t.Error("changing the returned slice granted a permission")
+	}
+	if len(Permissions(RoleUser)) != 0 || len(Permissions("superuser")) != 0 {
+		t.Error("a role without permissions has some")
+	}
+}
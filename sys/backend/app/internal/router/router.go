@@ -1,30 +1,58 @@
 package router
 
 import (
+	"github.com/chatshare/backend/internal/apitoken"
 	"github.com/chatshare/backend/internal/config"
 	"github.com/chatshare/backend/internal/firebase"
 	"github.com/chatshare/backend/internal/handlers"
+	"github.com/chatshare/backend/internal/mailer"
 	"github.com/chatshare/backend/internal/middleware"
+	"github.com/chatshare/backend/internal/ratelimit"
+	"github.com/chatshare/backend/internal/rbac"
+	"github.com/chatshare/backend/internal/screening"
+	"github.com/chatshare/backend/internal/transcript"
+	"github.com/chatshare/backend/internal/userstate"
+	"github.com/chatshare/backend/internal/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
-func SetupRouter(cfg *config.Config, db *gorm.DB, redisClient *redis.Client, firebaseService *firebase.FirebaseService) *gin.Engine {
+func SetupRouter(cfg *config.Config, db *gorm.DB, redisClient *redis.Client, firebaseService *firebase.FirebaseService, importer *transcript.Importer, emails *mailer.Sender) *gin.Engine {
 	r := gin.Default()
 
+	// Personal API tokens, accepted by the auth middleware alongside JWTs.
+	// Each protected route group declares the scope it needs.
+	apiTokens := apitoken.NewStore(db)
+
 	// Middleware
 	r.Use(middleware.CORSMiddleware(cfg))
-	r.Use(middleware.RateLimitMiddleware(cfg, redisClient))
+	r.Use(middleware.RateLimitMiddleware(cfg, ratelimit.NewLimiter(redisClient), apiTokens, rateLimitPolicies(cfg)))
+
+	// Refresh sessions and the access token denylist
+	sessionStore := utils.NewSessionStore(redisClient)
+
+	// Cached user status and role, checked on every authenticated request
+	userStates := userstate.NewStore(db, redisClient)
+
+	// Content screening run before chats and comments are saved
+	screener := screening.NewScreener(db)
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(db, cfg, redisClient, firebaseService)
-	userHandler := handlers.NewUserHandler(db, cfg)
-	chatHandler := handlers.NewChatHandler(db, cfg)
+	authHandler := handlers.NewAuthHandler(db, cfg, sessionStore, firebaseService, userStates)
+	userHandler := handlers.NewUserHandler(db, cfg, emails, userStates)
+	chatHandler := handlers.NewChatHandler(db, cfg, screener)
 	searchHandler := handlers.NewSearchHandler(db, cfg)
 	categoryHandler := handlers.NewCategoryHandler(db, cfg)
-	commentHandler := handlers.NewCommentHandler(db, cfg)
-	adminHandler := handlers.NewAdminHandler(db, cfg)
+	commentHandler := handlers.NewCommentHandler(db, cfg, emails, screener)
+	adminHandler := handlers.NewAdminHandler(db, cfg, sessionStore, userStates)
+	transcriptHandler := handlers.NewTranscriptHandler(db, cfg, importer, screener)
+	notificationHandler := handlers.NewNotificationHandler(db, cfg)
+	emailHandler := handlers.NewEmailHandler(db, cfg)
+	sessionHandler := handlers.NewSessionHandler(db, cfg, sessionStore)
+	apiTokenHandler := handlers.NewAPITokenHandler(db, cfg)
+	reportHandler := handlers.NewReportHandler(db, cfg)
+	screeningHandler := handlers.NewScreeningHandler(db, cfg, screener)
 
 	// Root redirect - redirect to production welcome page
 	r.GET("/", func(c *gin.Context) {
@@ -50,10 +78,24 @@ func SetupRouter(cfg *config.Config, db *gorm.DB, redisClient *redis.Client, fir
 			auth.GET("/line/url", authHandler.GetLINEOAuthURL)
 			auth.GET("/line/callback", authHandler.LINECallbackGET)
 			auth.POST("/line/callback", authHandler.LINECallback)
-			auth.GET("/me", middleware.AuthMiddleware(cfg), authHandler.GetCurrentUser)
+
+			// Firebase Auth (mobile apps)
+			auth.POST("/firebase", authHandler.FirebaseSignIn)
+
+			// Providers configured with OIDC_PROVIDERS
+			auth.GET("/providers", authHandler.ListLoginProviders)
+			auth.GET("/:provider/redirect", authHandler.OIDCLogin)
+			auth.GET("/:provider/callback", authHandler.OIDCCallback)
+			auth.POST("/:provider/callback", authHandler.OIDCCallback)
+
+			auth.GET("/me", middleware.AuthMiddleware(cfg, sessionStore, apiTokens, userStates), middleware.RequireScope(apitoken.ScopeRead), authHandler.GetCurrentUser)
+
+			// Access token renewal
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.GET("/csrf", authHandler.GetCSRFToken)
 
 			// Logout
-			auth.POST("/logout", middleware.AuthMiddleware(cfg), authHandler.Logout)
+			auth.POST("/logout", middleware.AuthMiddleware(cfg, sessionStore, apiTokens, userStates), middleware.RequireSession(), authHandler.Logout)
 		}
 
 		// Public routes
@@ -63,8 +105,9 @@ func SetupRouter(cfg *config.Config, db *gorm.DB, redisClient *redis.Client, fir
 			public.GET("/categories", categoryHandler.ListCategories)
 
 			// Chats (with optional auth)
-			public.GET("/chats", middleware.OptionalAuthMiddleware(cfg), chatHandler.ListChats)
-			public.GET("/chats/:id", middleware.OptionalAuthMiddleware(cfg), chatHandler.GetChat)
+			public.GET("/chats", middleware.OptionalAuthMiddleware(cfg, sessionStore, apiTokens, userStates), chatHandler.ListChats)
+			public.GET("/chats/:id", middleware.OptionalAuthMiddleware(cfg, sessionStore, apiTokens, userStates), chatHandler.GetChat)
+			public.GET("/chats/:id/messages", middleware.OptionalAuthMiddleware(cfg, sessionStore, apiTokens, userStates), transcriptHandler.ListMessages)
 
 			// Search and rankings
 			public.GET("/search", searchHandler.SearchChats)
@@ -79,28 +122,59 @@ func SetupRouter(cfg *config.Config, db *gorm.DB, redisClient *redis.Client, fir
 
 			// Comments (public viewing)
 			public.GET("/chats/:id/comments", commentHandler.ListComments)
+
+			// Email unsubscribe links (signed, no login)
+			public.GET("/email/unsubscribe", emailHandler.GetUnsubscribe)
+			public.POST("/email/unsubscribe", emailHandler.Unsubscribe)
 		}
 
 		// Protected routes (require authentication)
 		protected := v1.Group("")
-		protected.Use(middleware.AuthMiddleware(cfg))
+		protected.Use(middleware.AuthMiddleware(cfg, sessionStore, apiTokens, userStates))
 		{
 			// User routes
-			user := protected.Group("/user")
+			user := protected.Group("/user", middleware.RequireScope(apitoken.ScopeProfileWrite))
 			{
 				user.GET("/profile", userHandler.GetProfile)
 				user.PUT("/profile", userHandler.UpdateProfile)
 				user.GET("/favorites/users", userHandler.ListFavoriteUsers)
 				user.POST("/favorites/users/:id", userHandler.AddFavoriteUser)
 				user.DELETE("/favorites/users/:id", userHandler.RemoveFavoriteUser)
+				user.GET("/moderation-history", userHandler.GetModerationHistory)
+				// Notifications
+				user.GET("/notifications", notificationHandler.ListNotifications)
+				user.GET("/notifications/unread-count", notificationHandler.GetUnreadCount)
+				user.PUT("/notifications/read-all", notificationHandler.MarkAllRead)
+				user.PUT("/notifications/:id/read", notificationHandler.MarkRead)
+				user.GET("/notifications/preferences", notificationHandler.GetPreferences)
+				user.PUT("/notifications/preferences", notificationHandler.UpdatePreferences)
+			}
+
+			// Account security (not available to API tokens)
+			account := protected.Group("/user", middleware.RequireSession())
+			{
+				// Signed-in devices
+				account.GET("/sessions", sessionHandler.ListSessions)
+				account.DELETE("/sessions", sessionHandler.RevokeAllSessions)
+				account.DELETE("/sessions/:id", sessionHandler.RevokeSession)
+				// Linked sign-in methods
+				account.GET("/identities", authHandler.ListIdentities)
+				account.POST("/identities/:provider", authHandler.LinkIdentity)
+				account.DELETE("/identities/:id", authHandler.UnlinkIdentity)
+				account.POST("/merge", authHandler.MergeAccount)
+				// Personal API tokens
+				account.GET("/api-tokens", apiTokenHandler.ListAPITokens)
+				account.POST("/api-tokens", apiTokenHandler.CreateAPIToken)
+				account.DELETE("/api-tokens/:id", apiTokenHandler.RevokeAPIToken)
 				// Delete own account
-				user.DELETE("/account", userHandler.DeleteAccount)
+				account.DELETE("/account", userHandler.DeleteAccount)
 			}
 
 			// Chat management
-			chats := protected.Group("/chats")
+			chats := protected.Group("/chats", middleware.RequireScope(apitoken.ScopeChatsWrite))
 			{
 				chats.POST("", chatHandler.CreateChat)
+				chats.POST("/import", transcriptHandler.UploadTranscript)
 				chats.PUT("/:id", chatHandler.UpdateChat)
 				chats.DELETE("/:id", chatHandler.DeleteChat)
 
@@ -111,35 +185,72 @@ func SetupRouter(cfg *config.Config, db *gorm.DB, redisClient *redis.Client, fir
 				// Share tracking
 				chats.POST("/:id/share", chatHandler.RecordShare)
 
-				// Comments
-				chats.POST("/:id/comments", commentHandler.CreateComment)
-				chats.DELETE("/:id/comments/:commentId", commentHandler.DeleteComment)
+				// Conversation transcript
+				chats.POST("/:id/messages/import", transcriptHandler.ImportMessages)
+
+				// Reporting to moderators
+				chats.POST("/:id/report", reportHandler.ReportChat)
 			}
+
+			// Comments
+			comments := protected.Group("/chats/:id/comments", middleware.RequireScope(apitoken.ScopeCommentsWrite))
+			{
+				comments.POST("", commentHandler.CreateComment)
+				comments.POST("/:commentId/replies", commentHandler.CreateReply)
+				comments.DELETE("/:commentId", commentHandler.DeleteComment)
+			}
+			protected.POST("/comments/:id/report", middleware.RequireScope(apitoken.ScopeCommentsWrite), reportHandler.ReportComment)
 		}
 
-		// Admin routes (require admin role)
+		// Admin routes (each requires a permission of the user's role)
 		admin := v1.Group("/admin")
-		admin.Use(middleware.AuthMiddleware(cfg))
-		admin.Use(middleware.AdminMiddleware())
+		admin.Use(middleware.AuthMiddleware(cfg, sessionStore, apiTokens, userStates))
+		admin.Use(middleware.RequireSession())
+		admin.Use(middleware.RequireStaff())
 		{
 			// User management
-			admin.GET("/users", adminHandler.ListUsers)
-			admin.PUT("/users/:id/status", adminHandler.UpdateUserStatus)
-			admin.PUT("/users/:id/role", adminHandler.UpdateUserRole)
-			admin.GET("/users/:id/activity", adminHandler.GetUserActivity)
+			admin.GET("/users", middleware.RequirePermission(rbac.PermUsersRead), adminHandler.ListUsers)
+			admin.PUT("/users/:id/status", middleware.RequirePermission(rbac.PermUsersManage), adminHandler.UpdateUserStatus)
+			admin.PUT("/users/:id/role", middleware.RequirePermission(rbac.PermUsersRoles), adminHandler.UpdateUserRole)
+			admin.GET("/users/:id/activity", middleware.RequirePermission(rbac.PermUsersRead), adminHandler.GetUserActivity)
+			admin.GET("/roles", middleware.RequirePermission(rbac.PermUsersRoles), adminHandler.ListRoles)
 
 			// Chat management
-			admin.GET("/chats", adminHandler.ListAllChats)
-			admin.PUT("/chats/:id/status", adminHandler.UpdateChatStatus)
-			admin.DELETE("/chats/:id", adminHandler.DeleteChatByAdmin)
+			admin.GET("/chats", middleware.RequirePermission(rbac.PermChatsRead), adminHandler.ListAllChats)
+			admin.PUT("/chats/:id/status", middleware.RequirePermission(rbac.PermChatsModerate), adminHandler.UpdateChatStatus)
+			admin.DELETE("/chats/:id", middleware.RequirePermission(rbac.PermChatsModerate), adminHandler.DeleteChatByAdmin)
+			admin.GET("/chats/duplicates", middleware.RequirePermission(rbac.PermChatsRead), adminHandler.ListDuplicateChats)
+			admin.POST("/chats/backfill-links", middleware.RequirePermission(rbac.PermChatsMaintain), adminHandler.BackfillCanonicalLinks)
+
+			// Comment moderation
+			admin.GET("/comments", middleware.RequirePermission(rbac.PermCommentsModerate), adminHandler.ListAllComments)
+			admin.PUT("/comments/:id/status", middleware.RequirePermission(rbac.PermCommentsModerate), adminHandler.UpdateCommentStatus)
+			admin.POST("/comments/bulk", middleware.RequirePermission(rbac.PermCommentsModerate), adminHandler.BulkUpdateCommentStatus)
+
+			// Moderation queue
+			admin.GET("/reports", middleware.RequirePermission(rbac.PermReportsReview), reportHandler.ListReports)
+			admin.POST("/reports/bulk", middleware.RequirePermission(rbac.PermReportsReview), reportHandler.BulkUpdateReports)
 
 			// Category management
-			admin.POST("/categories", adminHandler.CreateCategory)
-			admin.PUT("/categories/:id", adminHandler.UpdateCategory)
-			admin.DELETE("/categories/:id", adminHandler.DeleteCategory)
+			admin.POST("/categories", middleware.RequirePermission(rbac.PermCategoriesManage), adminHandler.CreateCategory)
+			admin.PUT("/categories/:id", middleware.RequirePermission(rbac.PermCategoriesManage), adminHandler.UpdateCategory)
+			admin.DELETE("/categories/:id", middleware.RequirePermission(rbac.PermCategoriesDelete), adminHandler.DeleteCategory)
+
+			// Content screening rules
+			admin.GET("/screening/rules", middleware.RequirePermission(rbac.PermScreeningManage), screeningHandler.ListScreeningRules)
+			admin.POST("/screening/rules", middleware.RequirePermission(rbac.PermScreeningManage), screeningHandler.CreateScreeningRule)
+			admin.PUT("/screening/rules/:id", middleware.RequirePermission(rbac.PermScreeningManage), screeningHandler.UpdateScreeningRule)
+			admin.DELETE("/screening/rules/:id", middleware.RequirePermission(rbac.PermScreeningManage), screeningHandler.DeleteScreeningRule)
+			admin.POST("/screening/test", middleware.RequirePermission(rbac.PermScreeningManage), screeningHandler.TestScreening)
+
+			// Chat providers
+			admin.GET("/providers", middleware.RequirePermission(rbac.PermChatsRead), adminHandler.ListProviders)
 
 			// Statistics
-			admin.GET("/statistics", adminHandler.GetStatistics)
+			admin.GET("/statistics", middleware.RequirePermission(rbac.PermStatisticsRead), adminHandler.GetStatistics)
+
+			// Audit log
+			admin.GET("/audit-log", middleware.RequirePermission(rbac.PermAuditRead), adminHandler.ListAuditLog)
 		}
 	}
 
@@ -150,3 +261,40 @@ func SetupRouter(cfg *config.Config, db *gorm.DB, redisClient *redis.Client, fir
 
 	return r
 }
+
+// rateLimitPolicies sets strict limits on signing in and posting, and a
+// lenient one on reading. Every auth route counts as signing in, except the
+// reads the web app makes on each page load. Other routes without a policy
+// of their own share the read or write limit.
+func rateLimitPolicies(cfg *config.Config) middleware.RateLimitPolicies {
+	policy := func(name string, limit config.RateLimit) ratelimit.Policy {
+		return ratelimit.Policy{Name: name, Limit: limit.Requests, Window: limit.Window}
+	}
+
+	auth := policy("auth", cfg.RateLimitAuth)
+	posts := policy("posts", cfg.RateLimitPosts)
+	comments := policy("comments", cfg.RateLimitComments)
+	reports := policy("reports", cfg.RateLimitReports)
+
+	read := policy("read", cfg.RateLimitRead)
+
+	return middleware.RateLimitPolicies{
+		Read:  read,
+		Write: ratelimit.Policy{Name: "write", Limit: cfg.RateLimitRequests, Window: cfg.RateLimitDuration},
+		Routes: map[string]ratelimit.Policy{
+			"GET /api/v1/auth/me":        read,
+			"GET /api/v1/auth/csrf":      read,
+			"GET /api/v1/auth/providers": read,
+
+			"POST /api/v1/chats":                                 posts,
+			"POST /api/v1/chats/import":                          posts,
+			"POST /api/v1/chats/:id/comments":                    comments,
+			"POST /api/v1/chats/:id/comments/:commentId/replies": comments,
+			"POST /api/v1/chats/:id/report":                      reports,
+			"POST /api/v1/comments/:id/report":                   reports,
+		},
+		Prefixes: map[string]ratelimit.Policy{
+			"/api/v1/auth/": auth,
+		},
+	}
+}
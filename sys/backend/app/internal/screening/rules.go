@@ -0,0 +1,226 @@
+package screening
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/chatshare/backend/internal/database"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Rule types
+const (
+	TypeWord       = "word"        // a word or phrase, matched case-insensitively as a whole word
+	TypeRegex      = "regex"       // a regular expression (RE2 syntax)
+	TypeLinks      = "links"       // more than Threshold links
+	TypeDuplicate  = "duplicate"   // the same text posted more than Threshold times in the window
+	TypeNewAccount = "new_account" // Threshold posts in the window by accounts younger than AccountAgeHours
+)
+
+// Values of ScreeningRule.AppliesTo besides the content kinds
+const AppliesToAll = "all"
+
+// linkPattern matches one link, with or without a scheme
+var linkPattern = regexp.MustCompile(`(?i)(?:https?://|\bwww\.)\S+`)
+
+// Compile turns a configured rule into one that can run. It fails for rules
+// that are missing settings their type needs, such as an invalid pattern.
+func Compile(row database.ScreeningRule) (Rule, error) {
+	rule := &configuredRule{id: row.ID, appliesTo: row.AppliesTo}
+
+	switch row.Action {
+	case "flag":
+		rule.action = Flag
+	case "reject":
+		rule.action = Reject
+	default:
+		return nil, fmt.Errorf("unknown action %q", row.Action)
+	}
+
+	switch row.AppliesTo {
+	case "", AppliesToAll:
+		rule.appliesTo = AppliesToAll
+	case KindChat, KindComment:
+	default:
+		return nil, fmt.Errorf("unknown content kind %q", row.AppliesTo)
+	}
+
+	if row.Threshold < 0 {
+		return nil, errors.New("threshold cannot be negative")
+	}
+
+	switch row.Type {
+	case TypeWord, TypeRegex:
+		pattern := strings.TrimSpace(row.Pattern)
+		if pattern == "" {
+			return nil, errors.New("pattern is required")
+		}
+		if row.Type == TypeWord {
+			pattern = wordPattern(pattern)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		rule.match = matchPattern(re)
+
+	case TypeLinks:
+		rule.match = matchLinks(row.Threshold)
+
+	case TypeDuplicate:
+		if row.WindowMinutes <= 0 {
+			return nil, errors.New("window_minutes is required")
+		}
+		rule.match = matchDuplicate(row.Threshold, time.Duration(row.WindowMinutes)*time.Minute)
+
+	case TypeNewAccount:
+		if row.WindowMinutes <= 0 {
+			return nil, errors.New("window_minutes is required")
+		}
+		if row.AccountAgeHours <= 0 {
+			return nil, errors.New("account_age_hours is required")
+		}
+		rule.match = matchNewAccount(row.Threshold, time.Duration(row.WindowMinutes)*time.Minute,
+			time.Duration(row.AccountAgeHours)*time.Hour)
+
+	default:
+		return nil, fmt.Errorf("unknown rule type %q", row.Type)
+	}
+
+	return rule, nil
+}
+
+// matcher reports whether content breaks a rule, and why
+type matcher func(ctx context.Context, db *gorm.DB, content Content) (reason string, matched bool, err error)
+
+// configuredRule is a rule configured through the admin API
+type configuredRule struct {
+	id        uuid.UUID
+	action    Verdict
+	appliesTo string
+	match     matcher
+}
+
+func (r *configuredRule) Check(ctx context.Context, db *gorm.DB, content Content) (Result, error) {
+	if r.appliesTo != AppliesToAll && r.appliesTo != content.Kind {
+		return Result{}, nil
+	}
+
+	reason, matched, err := r.match(ctx, db, content)
+	if err != nil {
+		return Result{}, fmt.Errorf("rule %s: %w", r.id, err)
+	}
+	if !matched {
+		return Result{}, nil
+	}
+	return Result{Verdict: r.action, RuleID: r.id, Reason: reason}, nil
+}
+
+// wordPattern matches word as a whole word, ignoring case. Word boundaries
+// are only required next to letters and digits, so words like "c++" work.
+// RE2's \b only knows ASCII letters, so the boundaries are spelled out.
+func wordPattern(word string) string {
+	const boundary = `[^\p{L}\p{M}\p{N}_]`
+
+	pattern := regexp.QuoteMeta(word)
+	if first, _ := utf8.DecodeRuneInString(word); isWordRune(first) {
+		pattern = `(?:^|` + boundary + `)` + pattern
+	}
+	if last, _ := utf8.DecodeLastRuneInString(word); isWordRune(last) {
+		pattern += `(?:` + boundary + `|$)`
+	}
+	return `(?i)` + pattern
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.M, r)
+}
+
+func matchPattern(re *regexp.Regexp) matcher {
+	return func(_ context.Context, _ *gorm.DB, content Content) (string, bool, error) {
+		return "Contains language that is not allowed", re.MatchString(content.Text()), nil
+	}
+}
+
+func matchLinks(max int) matcher {
+	return func(_ context.Context, _ *gorm.DB, content Content) (string, bool, error) {
+		links := len(linkPattern.FindAllStringIndex(content.Text(), -1))
+		return fmt.Sprintf("Contains more than %d links", max), links > max, nil
+	}
+}
+
+// matchDuplicate counts the author's posts in the window with the same text,
+// ignoring case and surrounding space. Deleted posts count too, so deleting
+// and reposting does not get around the rule.
+func matchDuplicate(max int, window time.Duration) matcher {
+	return func(_ context.Context, db *gorm.DB, content Content) (string, bool, error) {
+		since := time.Now().Add(-window)
+		body := strings.ToLower(strings.TrimSpace(content.Body))
+
+		var query *gorm.DB
+		if content.Kind == KindChat {
+			query = db.Unscoped().Model(&database.Chat{}).
+				Where("LOWER(TRIM(title)) = ? AND LOWER(TRIM(description)) = ?", strings.ToLower(strings.TrimSpace(content.Title)), body)
+		} else {
+			query = db.Unscoped().Model(&database.Comment{}).
+				Where("LOWER(TRIM(content)) = ?", body)
+		}
+
+		var copies int64
+		if err := query.Where("user_id = ? AND id <> ? AND created_at > ?", content.UserID, content.ID, since).
+			Count(&copies).Error; err != nil {
+			return "", false, err
+		}
+		return "Repeats one of your recent posts", copies > int64(max), nil
+	}
+}
+
+// matchNewAccount limits how often accounts younger than age can post.
+// Edits are not posts and are never limited.
+func matchNewAccount(max int, window, age time.Duration) matcher {
+	return func(_ context.Context, db *gorm.DB, content Content) (string, bool, error) {
+		if content.Edit {
+			return "", false, nil
+		}
+
+		var user database.User
+		if err := db.Select("id", "created_at").First(&user, "id = ?", content.UserID).Error; err != nil {
+			return "", false, err
+		}
+		if time.Since(user.CreatedAt) >= age {
+			return "", false, nil
+		}
+
+		since := time.Now().Add(-window)
+		var chats, comments int64
+		if err := db.Unscoped().Model(&database.Chat{}).
+			Where("user_id = ? AND created_at > ?", content.UserID, since).Count(&chats).Error; err != nil {
+			return "", false, err
+		}
+		if err := db.Unscoped().Model(&database.Comment{}).
+			Where("user_id = ? AND created_at > ?", content.UserID, since).Count(&comments).Error; err != nil {
+			return "", false, err
+		}
+
+		reason := fmt.Sprintf("New accounts can post %d times every %s", max, formatWindow(window))
+		return reason, chats+comments >= int64(max), nil
+	}
+}
+
+// formatWindow describes a duration in whole hours or minutes
+func formatWindow(d time.Duration) string {
+	if d%time.Hour == 0 {
+		if d == time.Hour {
+			return "hour"
+		}
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	}
+	return fmt.Sprintf("%d minutes", d/time.Minute)
+}
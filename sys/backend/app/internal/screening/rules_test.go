@@ -0,0 +1,217 @@
+package screening
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger:                 logger.Default.LogMode(logger.Silent),
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, mock
+}
+
+func countRows(count int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"count"}).AddRow(count)
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name string
+		row  database.ScreeningRule
+		err  string
+	}{
+		{"word", database.ScreeningRule{Type: TypeWord, Pattern: "casino", Action: "flag"}, ""},
+		{"regex for comments", database.ScreeningRule{Type: TypeRegex, Pattern: `(?i)buy\s+now`, Action: "reject", AppliesTo: KindComment}, ""},
+		{"links", database.ScreeningRule{Type: TypeLinks, Threshold: 2, Action: "flag", AppliesTo: AppliesToAll}, ""},
+		{"duplicate", database.ScreeningRule{Type: TypeDuplicate, WindowMinutes: 60, Action: "flag"}, ""},
+		{"new account", database.ScreeningRule{Type: TypeNewAccount, Threshold: 3, WindowMinutes: 60, AccountAgeHours: 24, Action: "reject"}, ""},
+		{"unknown action", database.ScreeningRule{Type: TypeWord, Pattern: "casino", Action: "delete"}, "unknown action"},
+		{"unknown kind", database.ScreeningRule{Type: TypeWord, Pattern: "casino", Action: "flag", AppliesTo: "user"}, "unknown content kind"},
+		{"unknown type", database.ScreeningRule{Type: "image", Action: "flag"}, "unknown rule type"},
+		{"negative threshold", database.ScreeningRule{Type: TypeLinks, Threshold: -1, Action: "flag"}, "negative"},
+		{"blank pattern", database.ScreeningRule{Type: TypeWord, Pattern: "  ", Action: "flag"}, "pattern is required"},
+		{"invalid regex", database.ScreeningRule{Type: TypeRegex, Pattern: "(casino", Action: "flag"}, "invalid pattern"},
+		{"duplicate without window", database.ScreeningRule{Type: TypeDuplicate, Action: "flag"}, "window_minutes"},
+		{"new account without window", database.ScreeningRule{Type: TypeNewAccount, AccountAgeHours: 24, Action: "flag"}, "window_minutes"},
+		{"new account without age", database.ScreeningRule{Type: TypeNewAccount, WindowMinutes: 60, Action: "flag"}, "account_age_hours"},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile(tt.row)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestWordPattern(t *testing.T) {
+	tests := []struct {
+		word, text string
+		want       bool
+	}{
+		{"casino", "Best Casino in town", true},
+		{"casino", "casino", true},
+		{"casino", "casinos", false},
+		{"casino", "onlinecasino", false},
+		{"casino", "casino_bonus", false},
+		{"free money", "get FREE MONEY now", true},
+		{"c++", "I write C++ daily", true},
+		{"c++", "c++17 is fine", true},
+		{"c++", "abc++", false},
+		{".net", "built on .NET", true},
+		{"café", "un café au lait", true},
+		{"café", "cafés", false},
+		{"straße", "die Straße.", true},
+		{"straße", "hauptstraße", false},
+		{"日本", "日本の", false},
+		{"日本", "行く 日本 へ", true},
+		{"naïve", "so naïve!", true},
+	}
+
+	for _, tt := range tests {
+		re := regexp.MustCompile(wordPattern(tt.word))
+		if got := re.MatchString(tt.text); got != tt.want {
+			t.Errorf("%q in %q = %v, want %v", tt.word, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestLinksRule(t *testing.T) {
+	rule, err := Compile(database.ScreeningRule{Type: TypeLinks, Threshold: 2, Action: "flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		body string
+		want Verdict
+	}{
+		{"no links here", Allow},
+		{"see https://a.test and http://b.test", Allow},
+		{"see https://a.test, http://b.test and www.c.test", Flag},
+		{"HTTPS://A.TEST HTTP://B.TEST WWW.C.TEST", Flag},
+		{"https:// is not a link, nor is awww.test, but https://a.test is", Allow},
+	}
+
+	for _, tt := range tests {
+		result, err := rule.Check(context.Background(), nil, Content{Kind: KindComment, Body: tt.body})
+		if err != nil || result.Verdict != tt.want {
+			t.Errorf("%q: verdict = %s, %v; want %s", tt.body, result.Verdict, err, tt.want)
+		}
+	}
+}
+
+func TestDuplicateRule(t *testing.T) {
+	rule, err := Compile(database.ScreeningRule{Type: TypeDuplicate, Threshold: 1, WindowMinutes: 60, Action: "flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One earlier copy is allowed, a second one is not
+	for copies, want := range []Verdict{Allow, Allow, Flag, Flag} {
+		db, mock := newMockDB(t)
+		userID := uuid.New()
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "comments" WHERE LOWER\(TRIM\(content\)\) = \$1 AND \(user_id = \$2 AND id <> \$3 AND created_at > \$4\)`).
+			WithArgs("buy now", userID, uuid.Nil, sqlmock.AnyArg()).
+			WillReturnRows(countRows(copies))
+
+		result, err := rule.Check(context.Background(), db, Content{Kind: KindComment, UserID: userID, Body: "  Buy NOW "})
+		if err != nil || result.Verdict != want {
+			t.Errorf("%d copies: verdict = %s, %v; want %s", copies, result.Verdict, err, want)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestNewAccountRule(t *testing.T) {
+	rule, err := Compile(database.ScreeningRule{Type: TypeNewAccount, Threshold: 3, WindowMinutes: 60, AccountAgeHours: 24, Action: "reject"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		age      time.Duration
+		chats    int
+		comments int
+		edit     bool
+		want     Verdict
+	}{
+		{"below the limit", time.Hour, 1, 1, false, Allow},
+		{"at the limit", time.Hour, 1, 2, false, Reject},
+		{"old account", 48 * time.Hour, 0, 0, false, Allow},
+		{"edit", time.Hour, 0, 0, true, Allow},
+	}
+
+	for _, tt := range tests {
+		db, mock := newMockDB(t)
+		userID := uuid.New()
+		if !tt.edit {
+			mock.ExpectQuery(`SELECT "id","created_at" FROM "users" WHERE id = \$1`).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(userID, time.Now().Add(-tt.age)))
+		}
+		if tt.age < 24*time.Hour && !tt.edit {
+			mock.ExpectQuery(`SELECT count\(\*\) FROM "chats" WHERE user_id = \$1 AND created_at > \$2`).
+				WithArgs(userID, sqlmock.AnyArg()).
+				WillReturnRows(countRows(tt.chats))
+			mock.ExpectQuery(`SELECT count\(\*\) FROM "comments" WHERE user_id = \$1 AND created_at > \$2`).
+				WithArgs(userID, sqlmock.AnyArg()).
+				WillReturnRows(countRows(tt.comments))
+		}
+
+		result, err := rule.Check(context.Background(), db, Content{Kind: KindChat, UserID: userID, Edit: tt.edit})
+		if err != nil || result.Verdict != tt.want {
+			t.Errorf("%s: verdict = %s, %v; want %s", tt.name, result.Verdict, err, tt.want)
+		}
+		if tt.want == Reject && result.Reason != "New accounts can post 3 times every hour" {
+			t.Errorf("%s: reason = %q", tt.name, result.Reason)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestRuleAppliesTo(t *testing.T) {
+	rule, err := Compile(database.ScreeningRule{Type: TypeWord, Pattern: "casino", Action: "flag", AppliesTo: KindChat})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result, _ := rule.Check(context.Background(), nil, Content{Kind: KindComment, Body: "casino"}); result.Verdict != Allow {
+		t.Errorf("comment: verdict = %s, want allow", result.Verdict)
+	}
+	if result, _ := rule.Check(context.Background(), nil, Content{Kind: KindChat, Title: "casino"}); result.Verdict != Flag {
+		t.Errorf("chat: verdict = %s, want flag", result.Verdict)
+	}
+}
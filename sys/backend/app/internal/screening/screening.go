@@ -0,0 +1,157 @@
+// Package screening checks new chats and comments before they are saved.
+// Every rule gives a verdict: allow, flag (save the content as "flagged",
+// hidden until a moderator reviews it) or reject (refuse it). The content
+// gets the most severe verdict of all rules.
+//
+// Rules are rows of database.ScreeningRule, managed through the admin API
+// and reloaded every minute, plus any rules added in code with Use.
+package screening
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/chatshare/backend/internal/database"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ReloadInterval is how often the configured rules are read again. Changes
+// made through this instance's admin API apply immediately.
+const ReloadInterval = time.Minute
+
+// Verdict is what a rule decided about a piece of content. Higher verdicts
+// are more severe.
+type Verdict int
+
+const (
+	Allow Verdict = iota
+	Flag
+	Reject
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Flag:
+		return "flag"
+	case Reject:
+		return "reject"
+	default:
+		return "allow"
+	}
+}
+
+// Kinds of content
+const (
+	KindChat    = "chat"
+	KindComment = "comment"
+)
+
+// Content is a chat or comment about to be saved
+type Content struct {
+	Kind   string
+	ID     uuid.UUID // the chat or comment being edited; uuid.Nil for new content
+	UserID uuid.UUID
+	Title  string // chats only
+	Body   string // the chat's description or the comment
+	Edit   bool   // an edit of existing content rather than a new post
+}
+
+// Text is all the text of the content, for rules that match words
+func (c Content) Text() string {
+	if c.Title == "" {
+		return c.Body
+	}
+	return c.Title + "\n" + c.Body
+}
+
+// Result is a rule's verdict and the reason shown to the author
+type Result struct {
+	Verdict Verdict
+	RuleID  uuid.UUID // uuid.Nil for rules added in code
+	Reason  string
+}
+
+// Rule screens content. Rules that do not apply return a zero Result.
+type Rule interface {
+	Check(ctx context.Context, db *gorm.DB, content Content) (Result, error)
+}
+
+// Screener runs content through every rule
+type Screener struct {
+	db    *gorm.DB
+	extra []Rule
+
+	mu       sync.Mutex
+	rules    []Rule
+	loadedAt time.Time
+}
+
+func NewScreener(db *gorm.DB) *Screener {
+	return &Screener{db: db}
+}
+
+// Use adds rules that run after the configured ones
+func (s *Screener) Use(rules ...Rule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.extra = append(s.extra, rules...)
+}
+
+// Invalidate makes the next Screen read the configured rules again
+func (s *Screener) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadedAt = time.Time{}
+}
+
+// Screen returns the most severe verdict any rule gives content. A rule that
+// fails is logged and skipped rather than holding up the post.
+func (s *Screener) Screen(ctx context.Context, content Content) Result {
+	var result Result
+	for _, rule := range s.load(ctx) {
+		r, err := rule.Check(ctx, s.db.WithContext(ctx), content)
+		if err != nil {
+			log.Printf("Screening failed: %v", err)
+			continue
+		}
+		if r.Verdict > result.Verdict {
+			result = r
+		}
+		if result.Verdict == Reject {
+			break
+		}
+	}
+	return result
+}
+
+// load returns the rules to run, reading the configured ones again when they
+// are older than ReloadInterval. If they cannot be read, the previous rules
+// stay in use.
+func (s *Screener) load(ctx context.Context) []Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.loadedAt) >= ReloadInterval {
+		var rows []database.ScreeningRule
+		if err := s.db.WithContext(ctx).Where("is_active = ?", true).Order("created_at").Find(&rows).Error; err != nil {
+			log.Printf("Failed to load screening rules: %v", err)
+		} else {
+			rules := make([]Rule, 0, len(rows))
+			for _, row := range rows {
+				rule, err := Compile(row)
+				if err != nil {
+					log.Printf("Skipping screening rule %s: %v", row.ID, err)
+					continue
+				}
+				rules = append(rules, rule)
+			}
+			s.rules = rules
+			s.loadedAt = time.Now()
+		}
+	}
+
+	return append(append([]Rule{}, s.rules...), s.extra...)
+}
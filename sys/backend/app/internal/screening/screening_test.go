@@ -0,0 +1,104 @@
+package screening
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// fixedRule gives the same result every time and counts its runs
+type fixedRule struct {
+	result Result
+	err    error
+	runs   int
+}
+
+func (r *fixedRule) Check(context.Context, *gorm.DB, Content) (Result, error) {
+	r.runs++
+	return r.result, r.err
+}
+
+func flagRule(reason string) *fixedRule {
+	return &fixedRule{result: Result{Verdict: Flag, Reason: reason}}
+}
+
+// newTestScreener returns a screener with no configured rules and rules
+func newTestScreener(t *testing.T, rules ...Rule) *Screener {
+	t.Helper()
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "screening_rules" WHERE is_active = \$1 ORDER BY created_at`).
+		WithArgs(true).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	screener := NewScreener(db)
+	screener.Use(rules...)
+	return screener
+}
+
+func TestScreenReturnsMostSevereVerdict(t *testing.T) {
+	reject := &fixedRule{result: Result{Verdict: Reject, Reason: "rejected"}}
+	after := flagRule("after")
+
+	tests := []struct {
+		name   string
+		rules  []Rule
+		reason string
+	}{
+		{"no rules", nil, ""},
+		{"allow", []Rule{&fixedRule{}}, ""},
+		{"first flag wins", []Rule{&fixedRule{}, flagRule("first"), flagRule("second")}, "first"},
+		{"reject over flag", []Rule{flagRule("flag"), reject, after}, "rejected"},
+		{"failing rules are skipped", []Rule{&fixedRule{err: errors.New("down")}, flagRule("flag")}, "flag"},
+	}
+
+	for _, tt := range tests {
+		result := newTestScreener(t, tt.rules...).Screen(context.Background(), Content{Kind: KindComment})
+		if result.Reason != tt.reason {
+			t.Errorf("%s: reason = %q, want %q", tt.name, result.Reason, tt.reason)
+		}
+	}
+
+	// Nothing is more severe than a rejection, so later rules do not run
+	if after.runs != 0 {
+		t.Errorf("a rule ran %d times after a rejection", after.runs)
+	}
+}
+
+func TestScreenerSkipsInvalidRules(t *testing.T) {
+	db, mock := newMockDB(t)
+	valid, invalid := uuid.New(), uuid.New()
+	mock.ExpectQuery(`FROM "screening_rules"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type", "pattern", "action", "applies_to", "is_active"}).
+			AddRow(invalid, TypeRegex, "(casino", "reject", AppliesToAll, true).
+			AddRow(valid, TypeWord, "casino", "flag", AppliesToAll, true))
+
+	result := NewScreener(db).Screen(context.Background(), Content{Kind: KindChat, Title: "Casino night"})
+	if result.Verdict != Flag || result.RuleID != valid {
+		t.Errorf("result = %+v, want a flag from rule %s", result, valid)
+	}
+}
+
+func TestScreenerReloadsAfterInvalidate(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`FROM "screening_rules"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	screener := NewScreener(db)
+
+	// Rules are read once per ReloadInterval
+	screener.Screen(context.Background(), Content{Kind: KindChat})
+	screener.Screen(context.Background(), Content{Kind: KindChat})
+
+	mock.ExpectQuery(`FROM "screening_rules"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type", "pattern", "action"}).
+			AddRow(uuid.New(), TypeWord, "casino", "reject"))
+	screener.Invalidate()
+	if result := screener.Screen(context.Background(), Content{Kind: KindChat, Title: "casino"}); result.Verdict != Reject {
+		t.Errorf("verdict after Invalidate = %s, want reject", result.Verdict)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,381 @@
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Upload formats accepted by ParseUpload
+const (
+	FormatChatGPTExport = "chatgpt"
+	FormatClaudeExport  = "claude"
+	FormatMarkdown      = "markdown"
+)
+
+// LineError points at the line of an uploaded file that could not be parsed
+type LineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// ParseErrors lists every problem found in an uploaded file
+type ParseErrors []LineError
+
+func (e ParseErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, le := range e {
+		if le.Line > 0 {
+			msgs[i] = fmt.Sprintf("line %d: %s", le.Line, le.Message)
+		} else {
+			msgs[i] = le.Message
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Upload is a conversation parsed from an uploaded export file
+type Upload struct {
+	Format   string
+	Title    string
+	Messages []Message
+}
+
+// ParseUpload parses an uploaded conversation. format may be empty, in which
+// case JSON exports are told apart by their fields and anything else is read
+// as Markdown.
+func ParseUpload(data []byte, format string) (*Upload, error) {
+	if format == "" {
+		format = detectFormat(data)
+	}
+
+	var upload *Upload
+	var err error
+	switch format {
+	case FormatChatGPTExport:
+		upload, err = parseChatGPTExport(data)
+	case FormatClaudeExport:
+		upload, err = parseClaudeExport(data)
+	case FormatMarkdown:
+		upload, err = parseMarkdown(data)
+	default:
+		return nil, ParseErrors{{Message: fmt.Sprintf("unknown format %q", format)}}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(upload.Messages) == 0 {
+		return nil, ParseErrors{{Message: "no messages found"}}
+	}
+	upload.Format = format
+	return upload, nil
+}
+
+func detectFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return FormatMarkdown
+	}
+
+	// Only the top-level field names are needed to tell the exports apart
+	var probe interface{}
+	if json.Unmarshal(trimmed, &probe) != nil {
+		// Report the syntax error against the format most uploads use
+		return FormatChatGPTExport
+	}
+	if _, ok := findKey(probe, "chat_messages"); ok {
+		return FormatClaudeExport
+	}
+	return FormatChatGPTExport
+}
+
+// singleEntry unwraps a one-element array, since users often upload the
+// whole export file when it only holds the conversation they want
+func singleEntry(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return data, nil
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, jsonError(data, data, err)
+	}
+	if len(entries) != 1 {
+		return nil, ParseErrors{{Line: 1, Message: fmt.Sprintf("export contains %d conversations; upload a single conversation", len(entries))}}
+	}
+	return entries[0], nil
+}
+
+// parseChatGPTExport reads one conversation from a ChatGPT conversations.json
+// export. Messages form a tree in mapping; the shown branch is the path from
+// current_node back to the root.
+func parseChatGPTExport(data []byte) (*Upload, error) {
+	entry, err := singleEntry(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var conv struct {
+		Title       string `json:"title"`
+		CurrentNode string `json:"current_node"`
+		Mapping     map[string]struct {
+			Parent  *string `json:"parent"`
+			Message *struct {
+				Author struct {
+					Role string `json:"role"`
+				} `json:"author"`
+				Content struct {
+					ContentType string            `json:"content_type"`
+					Parts       []json.RawMessage `json:"parts"`
+				} `json:"content"`
+			} `json:"message"`
+		} `json:"mapping"`
+	}
+	if err := json.Unmarshal(entry, &conv); err != nil {
+		return nil, jsonError(data, entry, err)
+	}
+	if len(conv.Mapping) == 0 {
+		return nil, ParseErrors{{Line: 1, Message: `missing "mapping"; this is not a ChatGPT conversation export`}}
+	}
+
+	// Older exports omit current_node; fall back to the deepest leaf
+	node := conv.CurrentNode
+	if _, ok := conv.Mapping[node]; !ok {
+		parents := make(map[string]*string, len(conv.Mapping))
+		for id, entry := range conv.Mapping {
+			parents[id] = entry.Parent
+		}
+		node = deepestLeaf(parents)
+	}
+
+	var messages []Message
+	seen := make(map[string]bool)
+	for node != "" && !seen[node] {
+		seen[node] = true
+		entry := conv.Mapping[node]
+
+		if msg := entry.Message; msg != nil && msg.Content.ContentType == "text" {
+			role := msg.Author.Role
+			if role == RoleUser || role == RoleAssistant {
+				var parts []string
+				for _, raw := range msg.Content.Parts {
+					var part string
+					if json.Unmarshal(raw, &part) == nil && part != "" {
+						parts = append(parts, part)
+					}
+				}
+				if content := strings.TrimSpace(strings.Join(parts, "\n\n")); content != "" {
+					messages = append(messages, Message{Role: role, Content: content})
+				}
+			}
+		}
+
+		if entry.Parent == nil {
+			break
+		}
+		node = *entry.Parent
+	}
+
+	// The walk went from the leaf to the root
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return &Upload{Title: conv.Title, Messages: messages}, nil
+}
+
+// deepestLeaf returns the node with the longest path to the root, which is
+// the latest branch of a conversation whose export lacks current_node
+func deepestLeaf(parents map[string]*string) string {
+	best, bestDepth := "", -1
+	for node := range parents {
+		depth := 0
+		seen := map[string]bool{node: true}
+		for parent := parents[node]; parent != nil && !seen[*parent]; parent = parents[*parent] {
+			seen[*parent] = true
+			depth++
+		}
+		if depth > bestDepth || (depth == bestDepth && node > best) {
+			best, bestDepth = node, depth
+		}
+	}
+	return best
+}
+
+// parseClaudeExport reads one conversation from a Claude data export
+func parseClaudeExport(data []byte) (*Upload, error) {
+	entry, err := singleEntry(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var conv struct {
+		Name         string `json:"name"`
+		ChatMessages []struct {
+			Sender  string `json:"sender"`
+			Text    string `json:"text"`
+			Content []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"chat_messages"`
+	}
+	if err := json.Unmarshal(entry, &conv); err != nil {
+		return nil, jsonError(data, entry, err)
+	}
+	if conv.ChatMessages == nil {
+		return nil, ParseErrors{{Line: 1, Message: `missing "chat_messages"; this is not a Claude conversation export`}}
+	}
+
+	var messages []Message
+	for _, turn := range conv.ChatMessages {
+		role := RoleAssistant
+		if turn.Sender == "human" {
+			role = RoleUser
+		}
+
+		text := turn.Text
+		if len(turn.Content) > 0 {
+			var blocks []string
+			for _, block := range turn.Content {
+				if block.Type == "text" && block.Text != "" {
+					blocks = append(blocks, block.Text)
+				}
+			}
+			text = strings.Join(blocks, "\n\n")
+		}
+
+		if text = strings.TrimSpace(text); text != "" {
+			messages = append(messages, Message{Role: role, Content: text})
+		}
+	}
+
+	return &Upload{Title: conv.Name, Messages: messages}, nil
+}
+
+const markdownRoles = `user|human|you|me|assistant|ai|chatgpt|claude|copilot|gemini`
+
+var (
+	// "## User", "**Assistant**", "Claude:" on a line of their own
+	markdownRoleLine = regexp.MustCompile(`(?i)^(?:#{1,6}\s+)?(?:\*\*)?(` + markdownRoles + `)(?:\*\*)?\s*:?\s*(?:\*\*)?\s*$`)
+	// "User: how do I ...", "**Claude:** You can ..."
+	markdownRoleInline = regexp.MustCompile(`(?i)^(?:\*\*)?(` + markdownRoles + `)(?:\*\*)?\s*:\s*(?:\*\*)?\s*(.+)$`)
+)
+
+// parseMarkdown reads a conversation written as Markdown, where each turn
+// starts with a role marker line such as "## User" or "Assistant:".
+// Markers inside fenced code blocks are ignored.
+func parseMarkdown(data []byte) (*Upload, error) {
+	var (
+		messages []Message
+		errs     ParseErrors
+		current  *Message
+		startAt  int
+		body     []string
+		inFence  bool
+		title    string
+	)
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+		current.Content = strings.TrimSpace(strings.Join(body, "\n"))
+		if current.Content == "" {
+			errs = append(errs, LineError{Line: startAt, Message: "role marker has no message below it"})
+		} else {
+			messages = append(messages, *current)
+		}
+		current, body = nil, nil
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lineNo := i + 1
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		}
+
+		if !inFence {
+			if m := markdownRoleLine.FindStringSubmatch(trimmed); m != nil {
+				flush()
+				current, startAt = &Message{Role: markdownRole(m[1])}, lineNo
+				continue
+			}
+			if m := markdownRoleInline.FindStringSubmatch(trimmed); m != nil {
+				flush()
+				current, startAt = &Message{Role: markdownRole(m[1])}, lineNo
+				body = append(body, m[2])
+				continue
+			}
+		}
+
+		if current == nil {
+			if trimmed == "" {
+				continue
+			}
+			// A leading "# Title" names the conversation
+			if title == "" && len(messages) == 0 && strings.HasPrefix(trimmed, "# ") {
+				title = strings.TrimSpace(strings.TrimPrefix(trimmed, "# "))
+				continue
+			}
+			errs = append(errs, LineError{Line: lineNo, Message: "text before the first role marker (e.g. \"## User\" or \"Assistant:\")"})
+			continue
+		}
+
+		body = append(body, line)
+	}
+
+	if inFence {
+		errs = append(errs, LineError{Line: len(lines), Message: "unclosed code block"})
+	}
+	flush()
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &Upload{Title: title, Messages: messages}, nil
+}
+
+func markdownRole(marker string) string {
+	switch strings.ToLower(marker) {
+	case "user", "human", "you", "me":
+		return RoleUser
+	default:
+		return RoleAssistant
+	}
+}
+
+// jsonError converts an error from decoding part of data into a line-level
+// error, with the line counted from the start of data
+func jsonError(data, part []byte, err error) error {
+	base := int64(bytes.Index(data, part))
+	if base < 0 {
+		base = 0
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return ParseErrors{{Line: lineAt(data, base+syntaxErr.Offset), Message: syntaxErr.Error()}}
+	case errors.As(err, &typeErr):
+		return ParseErrors{{Line: lineAt(data, base+typeErr.Offset), Message: fmt.Sprintf("field %q should be %s, not %s", typeErr.Field, typeErr.Type, typeErr.Value)}}
+	default:
+		return ParseErrors{{Message: err.Error()}}
+	}
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
@@ -0,0 +1,199 @@
+package transcript
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/chatshare/backend/internal/config"
+	"github.com/chatshare/backend/internal/database"
+	"github.com/chatshare/backend/internal/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	userAgent = "ChatShareImporter/1.0 (+https://chatshare.dev)"
+
+	// Share pages embed the whole conversation, so allow for long ones
+	maxPageBytes = 10 * 1024 * 1024
+
+	// Chats imported per background round
+	importBatchSize = 20
+)
+
+// Importer fetches chat share pages and stores their conversation as
+// database.ChatMessage rows
+type Importer struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	client *http.Client
+}
+
+func NewImporter(db *gorm.DB, cfg *config.Config) *Importer {
+	return &Importer{
+		db:  db,
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// SetHTTPClient replaces the client used to fetch share pages
+func (i *Importer) SetHTTPClient(client *http.Client) {
+	i.client = client
+}
+
+// Start imports chats that have never been imported, immediately and then
+// every TranscriptImportInterval until ctx is cancelled
+func (i *Importer) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(i.cfg.TranscriptImportInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := i.RunOnce(ctx); err != nil {
+				log.Printf("Transcript import round failed: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunOnce imports a batch of chats that have not been attempted yet
+func (i *Importer) RunOnce(ctx context.Context) error {
+	var chats []database.Chat
+	if err := i.db.WithContext(ctx).
+		Where("transcript_fetched_at IS NULL AND is_link_valid = ? AND source = ?", true, "link").
+		Where("chat_type IN ?", supportedProviders()).
+		Order("created_at ASC").
+		Limit(importBatchSize).
+		Find(&chats).Error; err != nil {
+		return err
+	}
+
+	for _, chat := range chats {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, err := i.Import(ctx, &chat); err != nil {
+			log.Printf("Failed to import transcript of chat %s: %v", chat.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// Import fetches the chat's share page and replaces its stored messages.
+// The outcome is recorded on the chat either way.
+func (i *Importer) Import(ctx context.Context, chat *database.Chat) ([]database.ChatMessage, error) {
+	messages, err := i.fetch(ctx, chat)
+	if err != nil {
+		i.recordFailure(ctx, chat.ID, err)
+		return nil, err
+	}
+
+	rows, err := i.Store(ctx, chat.ID, messages)
+	if err != nil {
+		i.recordFailure(ctx, chat.ID, err)
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// Store replaces the messages of a chat and reindexes it for search
+func (i *Importer) Store(ctx context.Context, chatID uuid.UUID, messages []Message) ([]database.ChatMessage, error) {
+	rows := make([]database.ChatMessage, len(messages))
+	for idx, msg := range messages {
+		rows[idx] = database.ChatMessage{
+			ID:       uuid.New(),
+			ChatID:   chatID,
+			Position: idx,
+			Role:     msg.Role,
+			Content:  msg.Content,
+		}
+	}
+
+	err := i.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("chat_id = ?", chatID).Delete(&database.ChatMessage{}).Error; err != nil {
+			return err
+		}
+		if len(rows) > 0 {
+			if err := tx.CreateInBatches(rows, 100).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&database.Chat{}).Where("id = ?", chatID).UpdateColumns(map[string]interface{}{
+			"message_count":         len(rows),
+			"transcript_fetched_at": time.Now(),
+			"transcript_error":      "",
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.RefreshChatSearchVector(i.db.WithContext(ctx), chatID); err != nil {
+		log.Printf("Failed to index chat %s for search: %v", chatID, err)
+	}
+
+	return rows, nil
+}
+
+func (i *Importer) fetch(ctx context.Context, chat *database.Chat) ([]Message, error) {
+	parser, err := ParserFor(chat.ChatType)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, chat.PublicLink, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("share page returned HTTP %d", resp.StatusCode)
+	}
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxPageBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	return parser.Parse(page)
+}
+
+func (i *Importer) recordFailure(ctx context.Context, chatID uuid.UUID, importErr error) {
+	reason := utils.TruncateRunes(importErr.Error(), 255)
+
+	i.db.WithContext(ctx).Model(&database.Chat{}).Where("id = ?", chatID).UpdateColumns(map[string]interface{}{
+		"transcript_fetched_at": time.Now(),
+		"transcript_error":      reason,
+	})
+}
+
+func supportedProviders() []string {
+	names := make([]string, 0, len(parsers))
+	for name := range parsers {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,154 @@
+package transcript
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// htmlToMarkdown converts the rendered HTML of a chat message to Markdown.
+// It covers the elements providers use for message bodies: paragraphs,
+// headings, lists, code, emphasis, links, quotes and line breaks.
+func htmlToMarkdown(n *html.Node) string {
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		writeMarkdown(&b, child, 0)
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(b.String(), "\n\n"))
+}
+
+func writeMarkdown(b *strings.Builder, n *html.Node, listDepth int) {
+	switch n.Type {
+	case html.TextNode:
+		// Indentation between block elements is not part of the message
+		if strings.TrimSpace(n.Data) == "" && strings.Contains(n.Data, "\n") {
+			return
+		}
+		b.WriteString(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Button, atom.Svg:
+		return
+	case atom.P, atom.Div:
+		writeChildren(b, n, listDepth)
+		b.WriteString("\n\n")
+	case atom.Br:
+		b.WriteString("\n")
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		b.WriteString("\n" + strings.Repeat("#", level) + " ")
+		writeChildren(b, n, listDepth)
+		b.WriteString("\n\n")
+	case atom.Strong, atom.B:
+		b.WriteString("**")
+		writeChildren(b, n, listDepth)
+		b.WriteString("**")
+	case atom.Em, atom.I:
+		b.WriteString("_")
+		writeChildren(b, n, listDepth)
+		b.WriteString("_")
+	case atom.Code:
+		b.WriteString("`" + textContent(n) + "`")
+	case atom.Pre:
+		b.WriteString("\n```" + codeLanguage(n) + "\n")
+		b.WriteString(strings.TrimRight(textContent(n), "\n"))
+		b.WriteString("\n```\n\n")
+	case atom.A:
+		b.WriteString("[")
+		writeChildren(b, n, listDepth)
+		b.WriteString("](" + attr(n, "href") + ")")
+	case atom.Blockquote:
+		var inner strings.Builder
+		writeChildren(&inner, n, listDepth)
+		for _, line := range strings.Split(strings.TrimSpace(inner.String()), "\n") {
+			b.WriteString("> " + line + "\n")
+		}
+		b.WriteString("\n")
+	case atom.Ul, atom.Ol:
+		b.WriteString("\n")
+		index := 1
+		for li := n.FirstChild; li != nil; li = li.NextSibling {
+			if li.DataAtom != atom.Li {
+				continue
+			}
+			marker := "- "
+			if n.DataAtom == atom.Ol {
+				marker = fmt.Sprintf("%d. ", index)
+				index++
+			}
+			b.WriteString(strings.Repeat("  ", listDepth) + marker)
+			var item strings.Builder
+			writeChildren(&item, li, listDepth+1)
+			b.WriteString(strings.TrimSpace(item.String()) + "\n")
+		}
+		b.WriteString("\n")
+	default:
+		writeChildren(b, n, listDepth)
+	}
+}
+
+func writeChildren(b *strings.Builder, n *html.Node, listDepth int) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		writeMarkdown(b, child, listDepth)
+	}
+}
+
+// codeLanguage reads the language of a code block from a language-* class
+func codeLanguage(pre *html.Node) string {
+	for n := pre; n != nil; n = n.FirstChild {
+		for _, class := range strings.Fields(attr(n, "class")) {
+			if lang, ok := strings.CutPrefix(class, "language-"); ok {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(textContent(child))
+	}
+	return b.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// findAll returns the elements below n, in document order, for which match
+// returns true. Matching elements are not searched further.
+func findAll(n *html.Node, match func(*html.Node) bool) []*html.Node {
+	var found []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && match(n) {
+			found = append(found, n)
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return found
+}
@@ -0,0 +1,266 @@
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	ErrNoParser    = errors.New("transcript import is not supported for this provider")
+	ErrNoMessages  = errors.New("no messages found on share page")
+	ErrPageChanged = errors.New("share page format not recognized")
+)
+
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is one turn of a conversation, with its content in Markdown
+type Message struct {
+	Role    string
+	Content string
+}
+
+// Parser extracts the conversation from a provider's share page HTML
+type Parser interface {
+	Parse(page []byte) ([]Message, error)
+}
+
+// ParserFunc adapts a function to the Parser interface
+type ParserFunc func(page []byte) ([]Message, error)
+
+func (f ParserFunc) Parse(page []byte) ([]Message, error) {
+	return f(page)
+}
+
+// parsers maps provider names (Chat.ChatType) to their share page parser
+var parsers = map[string]Parser{
+	"chatgpt": ParserFunc(ParseChatGPT),
+	"claude":  ParserFunc(ParseClaude),
+	"copilot": ParserFunc(ParseCopilot),
+}
+
+// ParserFor returns the share page parser of a provider
+func ParserFor(provider string) (Parser, error) {
+	p, ok := parsers[provider]
+	if !ok {
+		return nil, ErrNoParser
+	}
+	return p, nil
+}
+
+// ParseChatGPT reads a ChatGPT share page. The conversation is embedded as
+// Next.js page data, with the visible turns in linear_conversation.
+func ParseChatGPT(page []byte) ([]Message, error) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	scripts := findAll(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Script && attr(n, "id") == "__NEXT_DATA__"
+	})
+	if len(scripts) == 0 {
+		return nil, ErrPageChanged
+	}
+
+	var data struct {
+		Props struct {
+			PageProps struct {
+				ServerResponse struct {
+					Data struct {
+						LinearConversation []struct {
+							Message *struct {
+								Author struct {
+									Role string `json:"role"`
+								} `json:"author"`
+								Content struct {
+									ContentType string            `json:"content_type"`
+									Parts       []json.RawMessage `json:"parts"`
+								} `json:"content"`
+							} `json:"message"`
+						} `json:"linear_conversation"`
+					} `json:"data"`
+				} `json:"serverResponse"`
+			} `json:"pageProps"`
+		} `json:"props"`
+	}
+	if err := json.Unmarshal([]byte(textContent(scripts[0])), &data); err != nil {
+		return nil, ErrPageChanged
+	}
+
+	var messages []Message
+	for _, node := range data.Props.PageProps.ServerResponse.Data.LinearConversation {
+		if node.Message == nil || node.Message.Content.ContentType != "text" {
+			continue
+		}
+
+		role := node.Message.Author.Role
+		if role != RoleUser && role != RoleAssistant {
+			continue
+		}
+
+		// Parts are strings for text turns; other part types are attachments
+		var parts []string
+		for _, raw := range node.Message.Content.Parts {
+			var part string
+			if json.Unmarshal(raw, &part) == nil && part != "" {
+				parts = append(parts, part)
+			}
+		}
+
+		content := strings.TrimSpace(strings.Join(parts, "\n\n"))
+		if content == "" {
+			continue
+		}
+		messages = append(messages, Message{Role: role, Content: content})
+	}
+
+	if len(messages) == 0 {
+		return nil, ErrNoMessages
+	}
+	return messages, nil
+}
+
+// ParseClaude reads a Claude share page. The snapshot is embedded as JSON in
+// a script tag, with the turns in a chat_messages array whose sender is
+// "human" or "assistant".
+func ParseClaude(page []byte) ([]Message, error) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	scripts := findAll(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Script
+	})
+
+	type claudeMessage struct {
+		Sender  string `json:"sender"`
+		Text    string `json:"text"`
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+
+	for _, script := range scripts {
+		body := textContent(script)
+		if !strings.Contains(body, `"chat_messages"`) {
+			continue
+		}
+
+		var raw interface{}
+		if err := json.Unmarshal([]byte(body), &raw); err != nil {
+			continue
+		}
+		found, ok := findKey(raw, "chat_messages")
+		if !ok {
+			continue
+		}
+
+		encoded, _ := json.Marshal(found)
+		var turns []claudeMessage
+		if err := json.Unmarshal(encoded, &turns); err != nil {
+			return nil, ErrPageChanged
+		}
+
+		var messages []Message
+		for _, turn := range turns {
+			role := RoleAssistant
+			if turn.Sender == "human" {
+				role = RoleUser
+			}
+
+			// Newer snapshots split the text into typed content blocks
+			text := turn.Text
+			if len(turn.Content) > 0 {
+				var blocks []string
+				for _, block := range turn.Content {
+					if block.Type == "text" && block.Text != "" {
+						blocks = append(blocks, block.Text)
+					}
+				}
+				text = strings.Join(blocks, "\n\n")
+			}
+
+			text = strings.TrimSpace(text)
+			if text == "" {
+				continue
+			}
+			messages = append(messages, Message{Role: role, Content: text})
+		}
+
+		if len(messages) == 0 {
+			return nil, ErrNoMessages
+		}
+		return messages, nil
+	}
+
+	return nil, ErrPageChanged
+}
+
+// ParseCopilot reads a Copilot share page, which is server-rendered with
+// each turn in an element marked data-content="user-message" or
+// data-content="ai-message"
+func ParseCopilot(page []byte) ([]Message, error) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	turns := findAll(doc, func(n *html.Node) bool {
+		kind := attr(n, "data-content")
+		return kind == "user-message" || kind == "ai-message"
+	})
+	if len(turns) == 0 {
+		return nil, ErrPageChanged
+	}
+
+	var messages []Message
+	for _, turn := range turns {
+		role := RoleAssistant
+		if attr(turn, "data-content") == "user-message" {
+			role = RoleUser
+		}
+
+		content := htmlToMarkdown(turn)
+		if content == "" {
+			continue
+		}
+		messages = append(messages, Message{Role: role, Content: content})
+	}
+
+	if len(messages) == 0 {
+		return nil, ErrNoMessages
+	}
+	return messages, nil
+}
+
+// findKey searches decoded JSON depth-first for the first value under key
+func findKey(v interface{}, key string) (interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if found, ok := v[key]; ok {
+			return found, true
+		}
+		for _, child := range v {
+			if found, ok := findKey(child, key); ok {
+				return found, true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if found, ok := findKey(child, key); ok {
+				return found, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,115 @@
+package transcript
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	page, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestParsersOnSharePages(t *testing.T) {
+	tests := []struct {
+		provider string
+		fixture  string
+		want     []Message
+	}{
+		{
+			provider: "chatgpt",
+			fixture:  "chatgpt_share.html",
+			want: []Message{
+				{Role: RoleUser, Content: "How do I keep a sourdough starter alive?"},
+				{Role: RoleAssistant, Content: "Feed it **once a day** with equal weights of flour and water.\n\nKeep it at room temperature, around 24°C."},
+				{Role: RoleUser, Content: "What if I go on holiday?"},
+				{Role: RoleAssistant, Content: "Put it in the fridge; it will keep for about two weeks."},
+			},
+		},
+		{
+			provider: "claude",
+			fixture:  "claude_share.html",
+			want: []Message{
+				{Role: RoleUser, Content: "Write a regex that matches ISO 8601 dates."},
+				{Role: RoleAssistant, Content: "Here is one:\n\n```\n^\\d{4}-\\d{2}-\\d{2}$\n```\n\nIt does not check that the day exists in the month."},
+				{Role: RoleUser, Content: "Thanks!"},
+			},
+		},
+		{
+			provider: "copilot",
+			fixture:  "copilot_share.html",
+			want: []Message{
+				{Role: RoleUser, Content: "How do I reverse a list in Python?"},
+				{Role: RoleAssistant, Content: "Use `reversed()` or slice it with a **negative step**:\n\n" +
+					"```python\nnumbers = [1, 2, 3]\nprint(numbers[::-1])\n```\n\n" +
+					"- `list.reverse()` changes the list in place\n- Slicing returns a new list\n\n" +
+					"See the [tutorial](https://docs.python.org/3/tutorial/datastructures.html)."},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			parser, err := ParserFor(tt.provider)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := parser.Parse(readFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse returned\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsersOnUnrecognizedPages(t *testing.T) {
+	// A page of another provider, or a redesigned one, is reported as such
+	// rather than as an empty conversation
+	pages := map[string]string{
+		"chatgpt": "claude_share.html",
+		"claude":  "copilot_share.html",
+		"copilot": "chatgpt_share.html",
+	}
+
+	for provider, fixture := range pages {
+		parser, err := ParserFor(provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parser.Parse(readFixture(t, fixture)); !errors.Is(err, ErrPageChanged) {
+			t.Errorf("%s parser on %s: err = %v, want ErrPageChanged", provider, fixture, err)
+		}
+	}
+}
+
+func TestParsersOnEmptyConversations(t *testing.T) {
+	pages := map[string]string{
+		"chatgpt": `<script id="__NEXT_DATA__">{"props":{"pageProps":{"serverResponse":{"data":{"linear_conversation":[{"id":"root"}]}}}}}</script>`,
+		"claude":  `<script>{"chat_messages":[]}</script>`,
+		"copilot": `<div data-content="user-message"><button>Edit</button></div>`,
+	}
+
+	for provider, page := range pages {
+		parser, _ := ParserFor(provider)
+		if _, err := parser.Parse([]byte(page)); !errors.Is(err, ErrNoMessages) {
+			t.Errorf("%s parser: err = %v, want ErrNoMessages", provider, err)
+		}
+	}
+}
+
+func TestParserForUnsupportedProvider(t *testing.T) {
+	if _, err := ParserFor("gemini"); !errors.Is(err, ErrNoParser) {
+		t.Errorf("err = %v, want ErrNoParser", err)
+	}
+}
@@ -0,0 +1,185 @@
+// Package userstate decides whether a user may sign in and make requests.
+// The auth middleware checks every request against a short-lived Redis copy
+// of each user's status and role, so suspensions and role changes apply
+// without waiting for access tokens to expire.
+package userstate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chatshare/backend/internal/database"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// User statuses
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended" // until SuspendedUntil, or indefinitely
+	StatusDeleted   = "deleted"
+)
+
+// Error codes returned to clients with a 403
+const (
+	CodeAccountSuspended = "account_suspended"
+	CodeAccountDeleted   = "account_deleted"
+)
+
+const (
+	StatePrefix = "user:state:"
+
+	// How long a user's state is cached. Changes made through the admin API
+	// clear the cache immediately.
+	CacheTTL = 30 * time.Second
+)
+
+// State is what authentication needs to know about a user
+type State struct {
+	Status           string     `json:"status"`
+	Role             string     `json:"role"`
+	SuspendedUntil   *time.Time `json:"suspended_until,omitempty"`
+	SuspensionReason string     `json:"suspension_reason,omitempty"`
+}
+
+func FromUser(user *database.User) *State {
+	return &State{
+		Status:           user.Status,
+		Role:             user.Role,
+		SuspendedUntil:   user.SuspendedUntil,
+		SuspensionReason: user.SuspensionReason,
+	}
+}
+
+// suspensionOver reports whether a timed suspension has run out
+func (s *State) suspensionOver(now time.Time) bool {
+	return s.Status == StatusSuspended && s.SuspendedUntil != nil && !now.Before(*s.SuspendedUntil)
+}
+
+// Check returns an *AccountError if the user may not sign in or make
+// requests at now
+func (s *State) Check(now time.Time) *AccountError {
+	switch {
+	case s.Status == StatusSuspended && !s.suspensionOver(now):
+		return &AccountError{
+			Code:           CodeAccountSuspended,
+			Message:        "Your account is suspended",
+			SuspendedUntil: s.SuspendedUntil,
+			Reason:         s.SuspensionReason,
+		}
+	case s.Status == StatusDeleted:
+		return &AccountError{Code: CodeAccountDeleted, Message: "This account has been deleted"}
+	}
+	return nil
+}
+
+// AccountError explains why a user was refused
+type AccountError struct {
+	Code           string
+	Message        string
+	SuspendedUntil *time.Time // nil for an indefinite suspension
+	Reason         string
+}
+
+func (e *AccountError) Error() string {
+	return e.Message
+}
+
+// Details returns what clients need to show a suspension notice
+func (e *AccountError) Details() map[string]interface{} {
+	details := map[string]interface{}{}
+	if e.SuspendedUntil != nil {
+		details["suspended_until"] = e.SuspendedUntil
+	}
+	if e.Reason != "" {
+		details["reason"] = e.Reason
+	}
+	return details
+}
+
+// Store loads user states, caching them in Redis
+type Store struct {
+	db          *gorm.DB
+	redisClient *redis.Client
+}
+
+func NewStore(db *gorm.DB, redisClient *redis.Client) *Store {
+	return &Store{db: db, redisClient: redisClient}
+}
+
+// Get returns a user's current state. A user that no longer exists is
+// reported as deleted.
+func (s *Store) Get(ctx context.Context, userID uuid.UUID) (*State, error) {
+	key := StatePrefix + userID.String()
+
+	// A cache failure falls back to the database
+	if value, err := s.redisClient.Get(ctx, key).Result(); err == nil {
+		var state State
+		if json.Unmarshal([]byte(value), &state) == nil {
+			return &state, nil
+		}
+	}
+
+	state, err := s.load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expire the cache no later than the end of a suspension
+	ttl := CacheTTL
+	if state.Status == StatusSuspended && state.SuspendedUntil != nil {
+		if remaining := time.Until(*state.SuspendedUntil); remaining < ttl {
+			ttl = remaining
+		}
+	}
+	if ttl > 0 {
+		if data, err := json.Marshal(state); err == nil {
+			s.redisClient.Set(ctx, key, data, ttl)
+		}
+	}
+
+	return state, nil
+}
+
+// load reads a user's state from the database, lifting a timed suspension
+// that has run out
+func (s *Store) load(ctx context.Context, userID uuid.UUID) (*State, error) {
+	var user database.User
+	err := s.db.WithContext(ctx).Select("id", "status", "role", "suspended_until", "suspension_reason").
+		First(&user, "id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &State{Status: StatusDeleted}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load user state: %w", err)
+	}
+
+	state := FromUser(&user)
+	if state.suspensionOver(time.Now()) {
+		if err := lift(s.db.WithContext(ctx), userID); err != nil {
+			return nil, err
+		}
+		state = &State{Status: StatusActive, Role: user.Role}
+	}
+	return state, nil
+}
+
+// lift ends a user's suspension
+func lift(db *gorm.DB, userID uuid.UUID) error {
+	return db.Model(&database.User{}).
+		Where("id = ? AND status = ?", userID, StatusSuspended).
+		Updates(map[string]interface{}{
+			"status":            StatusActive,
+			"suspended_until":   nil,
+			"suspension_reason": "",
+		}).Error
+}
+
+// Invalidate drops a user's cached state after their status or role changed
+func (s *Store) Invalidate(ctx context.Context, userID uuid.UUID) error {
+	return s.redisClient.Del(ctx, StatePrefix+userID.String()).Err()
+}
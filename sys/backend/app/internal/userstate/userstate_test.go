@@ -0,0 +1,158 @@
+package userstate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestStore(t *testing.T) (*Store, sqlmock.Sqlmock, *miniredis.Miniredis) {
+	t.Helper()
+
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger:                 logger.Default.LogMode(logger.Silent),
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := miniredis.RunT(t)
+	return NewStore(db, redis.NewClient(&redis.Options{Addr: server.Addr()})), mock, server
+}
+
+// expectUser expects the state of userID to be read from the database
+func expectUser(mock sqlmock.Sqlmock, userID uuid.UUID, status string, suspendedUntil *time.Time) {
+	mock.ExpectQuery(`SELECT "id","status","role","suspended_until","suspension_reason" FROM "users" WHERE id = \$1`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "role", "suspended_until", "suspension_reason"}).
+			AddRow(userID, status, "user", suspendedUntil, "spam"))
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	tests := []struct {
+		name  string
+		state State
+		want  string
+	}{
+		{"active", State{Status: StatusActive}, ""},
+		{"suspended until later", State{Status: StatusSuspended, SuspendedUntil: &later}, CodeAccountSuspended},
+		{"suspended indefinitely", State{Status: StatusSuspended}, CodeAccountSuspended},
+		{"suspension over", State{Status: StatusSuspended, SuspendedUntil: &earlier}, ""},
+		{"suspension ending now", State{Status: StatusSuspended, SuspendedUntil: &now}, ""},
+		{"deleted", State{Status: StatusDeleted}, CodeAccountDeleted},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if err := tt.state.Check(now); err != nil {
+			got = err.Code
+		}
+		if got != tt.want {
+			t.Errorf("%s: Check = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAccountErrorDetails(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+	err := (&State{Status: StatusSuspended, SuspendedUntil: &until, SuspensionReason: "spam"}).Check(time.Now())
+
+	details := err.Details()
+	if details["suspended_until"] != &until || details["reason"] != "spam" {
+		t.Errorf("Details() = %v", details)
+	}
+	if details := (&AccountError{}).Details(); len(details) != 0 {
+		t.Errorf("Details() of an indefinite suspension without reason = %v", details)
+	}
+}
+
+func TestGetCachesState(t *testing.T) {
+	store, mock, server := newTestStore(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	// Only the first call reads the database
+	expectUser(mock, userID, StatusActive, nil)
+	for i := 0; i < 2; i++ {
+		state, err := store.Get(ctx, userID)
+		if err != nil || state.Status != StatusActive || state.Role != "user" {
+			t.Fatalf("Get = %+v, %v", state, err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if ttl := server.TTL(StatePrefix + userID.String()); ttl != CacheTTL {
+		t.Errorf("cache TTL = %v, want %v", ttl, CacheTTL)
+	}
+
+	// Until the state changes
+	store.Invalidate(ctx, userID)
+	expectUser(mock, userID, StatusSuspended, nil)
+	if state, _ := store.Get(ctx, userID); state.Status != StatusSuspended {
+		t.Errorf("state after Invalidate = %+v", state)
+	}
+}
+
+func TestGetCachesSuspensionNoLongerThanItLasts(t *testing.T) {
+	store, mock, server := newTestStore(t)
+	userID := uuid.New()
+
+	until := time.Now().Add(5 * time.Second)
+	expectUser(mock, userID, StatusSuspended, &until)
+	if _, err := store.Get(context.Background(), userID); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := server.TTL(StatePrefix + userID.String()); ttl <= 0 || ttl > 5*time.Second {
+		t.Errorf("cache TTL = %v, want at most 5s", ttl)
+	}
+}
+
+func TestGetLiftsExpiredSuspension(t *testing.T) {
+	store, mock, _ := newTestStore(t)
+	userID := uuid.New()
+
+	until := time.Now().Add(-time.Minute)
+	expectUser(mock, userID, StatusSuspended, &until)
+	mock.ExpectExec(`UPDATE "users" SET "status"=\$1,"suspended_until"=\$2,"suspension_reason"=\$3,.* WHERE \(id = \$5 AND status = \$6\)`).
+		WithArgs(StatusActive, nil, "", sqlmock.AnyArg(), userID, StatusSuspended).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	state, err := store.Get(context.Background(), userID)
+	if err != nil || state.Status != StatusActive || state.SuspendedUntil != nil {
+		t.Errorf("Get = %+v, %v; want an active user", state, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetReportsMissingUserAsDeleted(t *testing.T) {
+	store, mock, _ := newTestStore(t)
+	userID := uuid.New()
+
+	mock.ExpectQuery(`FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	state, err := store.Get(context.Background(), userID)
+	if err != nil || state.Status != StatusDeleted {
+		t.Errorf("Get = %+v, %v; want a deleted user", state, err)
+	}
+}
@@ -10,6 +10,15 @@ import (
 const (
 	SessionCookieName = "chatshare_session"
 	SessionMaxAge     = 7 * 24 * 60 * 60 // 7 days in seconds
+
+	// RefreshCookieName holds the refresh token, sent only to the refresh
+	// endpoint
+	RefreshCookieName = "chatshare_refresh"
+	RefreshCookiePath = "/api/v1/auth/refresh"
+
+	// legacyRefreshCookiePath is where refresh cookies used to be scoped.
+	// It also matched the OAuth callbacks, so such cookies are cleared.
+	legacyRefreshCookiePath = "/api/v1/auth"
 )
 
 // SetSessionCookie sets a secure session cookie
@@ -58,15 +67,14 @@ func SetSameSiteCookie(c *gin.Context, name, value string, maxAge int, path, dom
 	http.SetCookie(c.Writer, cookie)
 }
 
-// SetAuthCookie sets an authentication cookie with secure defaults
-func SetAuthCookie(c *gin.Context, token string, isProduction bool) {
-	maxAge := int(7 * 24 * time.Hour / time.Second) // 7 days
-
+// SetAuthCookie sets an authentication cookie with secure defaults. The
+// cookie lives as long as the access token it holds.
+func SetAuthCookie(c *gin.Context, token string, expiration time.Duration, isProduction bool) {
 	SetSameSiteCookie(
 		c,
 		SessionCookieName,
 		token,
-		maxAge,
+		int(expiration/time.Second),
 		"/",
 		"",
 		isProduction, // Secure flag (HTTPS only in production)
@@ -74,3 +82,91 @@ func SetAuthCookie(c *gin.Context, token string, isProduction bool) {
 		http.SameSiteLaxMode,
 	)
 }
+
+// SetRefreshCookie stores the refresh token in a cookie scoped to the
+// refresh endpoint, so it is not sent with any other request
+func SetRefreshCookie(c *gin.Context, token string, expiration time.Duration, isProduction bool) {
+	clearLegacyRefreshCookie(c)
+	SetSameSiteCookie(
+		c,
+		RefreshCookieName,
+		token,
+		int(expiration/time.Second),
+		RefreshCookiePath,
+		"",
+		isProduction,
+		true,
+		http.SameSiteStrictMode,
+	)
+}
+
+// ClearRefreshCookie removes the refresh token cookie
+func ClearRefreshCookie(c *gin.Context) {
+	clearLegacyRefreshCookie(c)
+	c.SetCookie(
+		RefreshCookieName,
+		"",
+		-1,
+		RefreshCookiePath,
+		"",
+		false,
+		true,
+	)
+}
+
+func clearLegacyRefreshCookie(c *gin.Context) {
+	c.SetCookie(
+		RefreshCookieName,
+		"",
+		-1,
+		legacyRefreshCookiePath,
+		"",
+		false,
+		true,
+	)
+}
+
+// OAuthStateCookieName binds a browser sign-in to the browser that started
+// it: the callback only signs in a browser holding the state it returns
+const OAuthStateCookieName = "chatshare_oauth_state"
+
+// SetOAuthStateCookie stores the state of a sign-in started by redirecting
+// the browser to a provider. Providers may post the callback from their own
+// site, so in production the cookie is sent with cross-site requests.
+func SetOAuthStateCookie(c *gin.Context, state, path string, isProduction bool) {
+	sameSite := http.SameSiteLaxMode
+	if isProduction {
+		sameSite = http.SameSiteNoneMode
+	}
+	SetSameSiteCookie(
+		c,
+		OAuthStateCookieName,
+		state,
+		int(StateTokenExpiration/time.Second),
+		path,
+		"",
+		isProduction,
+		true,
+		sameSite,
+	)
+}
+
+// ClearOAuthStateCookie removes the sign-in state cookie
+func ClearOAuthStateCookie(c *gin.Context, path string) {
+	c.SetCookie(
+		OAuthStateCookieName,
+		"",
+		-1,
+		path,
+		"",
+		false,
+		true,
+	)
+}
+
+// ClearAuthCookies removes the access token, refresh token and CSRF cookies
+func ClearAuthCookies(c *gin.Context) {
+	ClearSessionCookie(c)
+	ClearRefreshCookie(c)
+	ClearCSRFCookie(c)
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVerifyCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		method  string
+		origin  string
+		referer string
+		cookie  string
+		header  string
+		want    bool
+	}{
+		{name: "safe method", method: http.MethodGet, want: true},
+		{name: "matching token", method: http.MethodPost, origin: "https://chatshare.test", cookie: "token", header: "token", want: true},
+		{name: "no origin", method: http.MethodPost, cookie: "token", header: "token", want: true},
+		{name: "origin case and trailing slash", method: http.MethodPost, origin: "HTTPS://ChatShare.test/", cookie: "token", header: "token", want: true},
+		{name: "referer of the API", method: http.MethodPost, referer: "https://api.chatshare.test/docs", cookie: "token", header: "token", want: true},
+		{name: "foreign origin", method: http.MethodPost, origin: "https://evil.test", cookie: "token", header: "token"},
+		{name: "foreign referer", method: http.MethodDelete, referer: "https://evil.test/page", cookie: "token", header: "token"},
+		{name: "lookalike origin", method: http.MethodPost, origin: "https://chatshare.test.evil.test", cookie: "token", header: "token"},
+		{name: "null origin falls back to referer", method: http.MethodPost, origin: "null", referer: "https://evil.test/", cookie: "token", header: "token"},
+		{name: "missing header", method: http.MethodPost, cookie: "token"},
+		{name: "missing cookie", method: http.MethodPut, header: "token"},
+		{name: "mismatched token", method: http.MethodPost, cookie: "token", header: "other"},
+		{name: "both empty", method: http.MethodPost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(tt.method, "/api/v1/chats", nil)
+			if tt.origin != "" {
+				c.Request.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				c.Request.Header.Set("Referer", tt.referer)
+			}
+			if tt.cookie != "" {
+				c.Request.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				c.Request.Header.Set(CSRFHeaderName, tt.header)
+			}
+
+			if got := VerifyCSRF(c, "https://chatshare.test", "https://api.chatshare.test"); got != tt.want {
+				t.Errorf("VerifyCSRF = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ type Response struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	Code    string      `json:"code,omitempty"` // machine-readable error, e.g. account_suspended
 	Message string      `json:"message,omitempty"`
 }
 
@@ -13,13 +14,18 @@ type PaginatedResponse struct {
 	Success    bool        `json:"success"`
 	Data       interface{} `json:"data"`
 	Pagination Pagination  `json:"pagination"`
+	Meta       interface{} `json:"meta,omitempty"` // listing-specific extras, such as unread counts
 }
 
+// Pagination describes either an offset page (page, total_pages) or a keyset
+// page (next_cursor, has_more). Total is omitted when it was not counted.
 type Pagination struct {
-	Page       int   `json:"page"`
-	PageSize   int   `json:"page_size"`
-	Total      int64 `json:"total"`
-	TotalPages int   `json:"total_pages"`
+	Page       int    `json:"page,omitempty"`
+	PageSize   int    `json:"page_size"`
+	Total      *int64 `json:"total,omitempty"`
+	TotalPages *int   `json:"total_pages,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    *bool  `json:"has_more,omitempty"`
 }
 
 func SuccessResponse(c *gin.Context, statusCode int, data interface{}) {
@@ -36,6 +42,27 @@ func ErrorResponse(c *gin.Context, statusCode int, err string) {
 	})
 }
 
+// ErrorResponseWithData responds with an error along with details the client
+// can act on, such as the ID of a conflicting record
+func ErrorResponseWithData(c *gin.Context, statusCode int, err string, data interface{}) {
+	c.JSON(statusCode, Response{
+		Success: false,
+		Data:    data,
+		Error:   err,
+	})
+}
+
+// ErrorResponseWithCode responds with an error that clients handle by its
+// code rather than its message
+func ErrorResponseWithCode(c *gin.Context, statusCode int, code, err string, data interface{}) {
+	c.JSON(statusCode, Response{
+		Success: false,
+		Data:    data,
+		Error:   err,
+		Code:    code,
+	})
+}
+
 func MessageResponse(c *gin.Context, statusCode int, message string) {
 	c.JSON(statusCode, Response{
 		Success: true,
@@ -44,19 +71,46 @@ func MessageResponse(c *gin.Context, statusCode int, message string) {
 }
 
 func PaginatedSuccessResponse(c *gin.Context, statusCode int, data interface{}, page, pageSize int, total int64) {
+	c.JSON(statusCode, PaginatedResponse{
+		Success:    true,
+		Data:       data,
+		Pagination: OffsetPagination(page, pageSize, total),
+	})
+}
+
+// CursorPaginatedSuccessResponse responds with a keyset page. nextCursor is
+// empty on the last page, and total may be nil when it was not requested.
+func CursorPaginatedSuccessResponse(c *gin.Context, statusCode int, data interface{}, pageSize int, nextCursor string, total *int64) {
+	c.JSON(statusCode, PaginatedResponse{
+		Success:    true,
+		Data:       data,
+		Pagination: CursorPagination(pageSize, nextCursor, total),
+	})
+}
+
+// OffsetPagination describes a page selected by number
+func OffsetPagination(page, pageSize int, total int64) Pagination {
 	totalPages := int(total) / pageSize
 	if int(total)%pageSize != 0 {
 		totalPages++
 	}
 
-	c.JSON(statusCode, PaginatedResponse{
-		Success: true,
-		Data:    data,
-		Pagination: Pagination{
-			Page:       page,
-			PageSize:   pageSize,
-			Total:      total,
-			TotalPages: totalPages,
-		},
-	})
+	return Pagination{
+		Page:       page,
+		PageSize:   pageSize,
+		Total:      &total,
+		TotalPages: &totalPages,
+	}
+}
+
+// CursorPagination describes a keyset page
+func CursorPagination(pageSize int, nextCursor string, total *int64) Pagination {
+	hasMore := nextCursor != ""
+
+	return Pagination{
+		PageSize:   pageSize,
+		Total:      total,
+		NextCursor: nextCursor,
+		HasMore:    &hasMore,
+	}
 }
@@ -2,9 +2,15 @@ package utils
 
 import (
 	"context"
-	"fmt"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -12,6 +18,7 @@ const (
 	// OAuth state tokens expire after 10 minutes
 	StateTokenExpiration = 10 * time.Minute
 	StateTokenPrefix     = "oauth:state:"
+	SessionPrefix        = "session:"
 )
 
 type SessionStore struct {
@@ -66,20 +73,444 @@ func (s *SessionStore) ValidateAndDeleteState(ctx context.Context, state string)
 	return true, nil
 }
 
+// OAuthState is what a sign-in keeps between the authorization request and
+// the callback
+type OAuthState struct {
+	Provider     string `json:"provider,omitempty"` // set by the generic OIDC flow
+	Nonce        string `json:"nonce,omitempty"`
+	CodeVerifier string `json:"code_verifier,omitempty"` // empty when the client keeps the PKCE verifier
+}
+
+// StoreStateData stores an OAuth state token along with the nonce and PKCE
+// verifier of its authorization request
+func (s *SessionStore) StoreStateData(ctx context.Context, state string, data *OAuthState) error {
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.redisClient.Set(ctx, StateTokenPrefix+state, value, StateTokenExpiration).Err()
+}
+
+// LookupState returns what was stored with an OAuth state token without
+// deleting it, or nil if the token is unknown or expired
+func (s *SessionStore) LookupState(ctx context.Context, state string) (*OAuthState, error) {
+	value, err := s.redisClient.Get(ctx, StateTokenPrefix+state).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseOAuthState(value)
+}
+
+// ConsumeState validates and deletes an OAuth state token (one-time use) and
+// returns what was stored with it, or nil if the token is unknown or expired
+func (s *SessionStore) ConsumeState(ctx context.Context, state string) (*OAuthState, error) {
+	value, err := s.redisClient.GetDel(ctx, StateTokenPrefix+state).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseOAuthState(value)
+}
+
+func parseOAuthState(value string) (*OAuthState, error) {
+	// Tokens from StoreState carry no data
+	data := &OAuthState{}
+	if value != "valid" {
+		if err := json.Unmarshal([]byte(value), data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
 // StoreSession stores user session data
 func (s *SessionStore) StoreSession(ctx context.Context, sessionID string, data interface{}, expiration time.Duration) error {
-	key := fmt.Sprintf("session:%s", sessionID)
+	key := SessionPrefix + sessionID
 	return s.redisClient.Set(ctx, key, data, expiration).Err()
 }
 
 // GetSession retrieves user session data
 func (s *SessionStore) GetSession(ctx context.Context, sessionID string) (string, error) {
-	key := fmt.Sprintf("session:%s", sessionID)
+	key := SessionPrefix + sessionID
 	return s.redisClient.Get(ctx, key).Result()
 }
 
 // DeleteSession deletes user session
 func (s *SessionStore) DeleteSession(ctx context.Context, sessionID string) error {
-	key := fmt.Sprintf("session:%s", sessionID)
+	key := SessionPrefix + sessionID
 	return s.redisClient.Del(ctx, key).Err()
 }
+
+const (
+	RefreshTokenPrefix   = "refresh:token:"
+	RefreshSessionPrefix = "refresh:session:"
+	UserSessionsPrefix   = "refresh:user:"
+	SessionSeenPrefix    = "session:seen:"
+	DeniedTokenPrefix    = "denylist:jti:"
+	DeniedSessionPrefix  = "denylist:session:"
+
+	// Last seen is written at most this often per session
+	SessionSeenInterval = time.Minute
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// Session is a login on one device. Its details are kept with StoreSession;
+// every refresh token issued for it belongs to the same family and only the
+// latest one can be used.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Device     string    `json:"device"`   // web, ios or android from DetectDevice, or mobile for an SDK sign-in it took for web
+	Provider   string    `json:"provider"` // how the user signed in: google, line, firebase
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"` // the session of the requesting token
+}
+
+// rotateRefreshScript swaps the session's current token for a new one if
+// the presented token is still current. Returns -1 when the session is gone,
+// 0 when the token was already rotated (reuse) and 1 on success.
+var rotateRefreshScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'current')
+if not current then
+	return -1
+end
+if current ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'current', ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+redis.call('SET', KEYS[2], ARGV[3], 'PX', ARGV[4])
+return 1
+`)
+
+// CreateRefreshSession records a new login and returns the first refresh
+// token of its family. The session's ID and timestamps are filled in.
+func (s *SessionStore) CreateRefreshSession(ctx context.Context, session *Session, ttl time.Duration) (string, error) {
+	token, tokenHash, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	session.ID = uuid.NewString()
+	session.CreatedAt = now
+	session.LastSeenAt = now
+
+	if err := s.saveSession(ctx, session, ttl); err != nil {
+		return "", err
+	}
+
+	familyKey := RefreshSessionPrefix + session.ID
+	userKey := UserSessionsPrefix + session.UserID.String()
+
+	_, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, familyKey, "user_id", session.UserID.String(), "current", tokenHash)
+		pipe.Expire(ctx, familyKey, ttl)
+		pipe.Set(ctx, RefreshTokenPrefix+tokenHash, session.ID, ttl)
+		pipe.SAdd(ctx, userKey, session.ID)
+		pipe.Expire(ctx, userKey, ttl)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family. Presenting a token that was already rotated revokes the whole
+// family, since either the client or an attacker holds a stolen copy.
+func (s *SessionStore) RotateRefreshToken(ctx context.Context, refreshToken, ipAddress string, ttl, accessTTL time.Duration) (*Session, string, error) {
+	oldHash := hashRefreshToken(refreshToken)
+
+	sessionID, err := s.redisClient.Get(ctx, RefreshTokenPrefix+oldHash).Result()
+	if err == redis.Nil {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	session, err := s.GetUserSession(ctx, sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	if session == nil {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+
+	newToken, newHash, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	result, err := rotateRefreshScript.Run(ctx, s.redisClient,
+		[]string{RefreshSessionPrefix + sessionID, RefreshTokenPrefix + newHash},
+		oldHash, newHash, sessionID, ttl.Milliseconds(),
+	).Int()
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch result {
+	case -1:
+		return nil, "", ErrRefreshTokenInvalid
+	case 0:
+		if err := s.RevokeSession(ctx, session.UserID, sessionID, accessTTL); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	session.LastSeenAt = time.Now()
+	session.IPAddress = ipAddress
+	if err := s.saveSession(ctx, session, ttl); err != nil {
+		return nil, "", err
+	}
+	s.redisClient.Expire(ctx, UserSessionsPrefix+session.UserID.String(), ttl)
+
+	return session, newToken, nil
+}
+
+// GetUserSession returns a session, or nil if it expired or was revoked
+func (s *SessionStore) GetUserSession(ctx context.Context, sessionID string) (*Session, error) {
+	data, err := s.GetSession(ctx, sessionID)
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+// ListUserSessions returns the user's active sessions, most recently used
+// first. Sessions that have expired are dropped from the user's index.
+func (s *SessionStore) ListUserSessions(ctx context.Context, userID uuid.UUID) ([]Session, error) {
+	userKey := UserSessionsPrefix + userID.String()
+
+	ids, err := s.redisClient.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(ids))
+	for _, id := range ids {
+		session, err := s.GetUserSession(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if session == nil || session.UserID != userID {
+			s.redisClient.SRem(ctx, userKey, id)
+			continue
+		}
+		sessions = append(sessions, *session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+// TouchSession records activity on a session, writing at most once per
+// SessionSeenInterval
+func (s *SessionStore) TouchSession(ctx context.Context, sessionID, ipAddress string) error {
+	first, err := s.redisClient.SetNX(ctx, SessionSeenPrefix+sessionID, "1", SessionSeenInterval).Result()
+	if err != nil || !first {
+		return err
+	}
+
+	session, err := s.GetUserSession(ctx, sessionID)
+	if err != nil || session == nil {
+		return err
+	}
+
+	ttl, err := s.redisClient.TTL(ctx, SessionPrefix+sessionID).Result()
+	if err != nil || ttl <= 0 {
+		return err
+	}
+
+	session.LastSeenAt = time.Now()
+	session.IPAddress = ipAddress
+	return s.saveSession(ctx, session, ttl)
+}
+
+// RevokeSession ends a session: its refresh tokens stop working at once and
+// access tokens issued for it are denied until they would have expired
+func (s *SessionStore) RevokeSession(ctx context.Context, userID uuid.UUID, sessionID string, accessTTL time.Duration) error {
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, RefreshSessionPrefix+sessionID, SessionPrefix+sessionID, SessionSeenPrefix+sessionID)
+		pipe.SRem(ctx, UserSessionsPrefix+userID.String(), sessionID)
+		pipe.Set(ctx, DeniedSessionPrefix+sessionID, "revoked", accessTTL)
+		return nil
+	})
+	return err
+}
+
+// RevokeAllSessions ends every session of a user except the one with ID
+// keep, which may be empty. It returns the number of sessions ended.
+func (s *SessionStore) RevokeAllSessions(ctx context.Context, userID uuid.UUID, keep string, accessTTL time.Duration) (int, error) {
+	ids, err := s.redisClient.SMembers(ctx, UserSessionsPrefix+userID.String()).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, id := range ids {
+		if id == keep {
+			continue
+		}
+		if err := s.RevokeSession(ctx, userID, id, accessTTL); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// DenyToken rejects an access token by its jti until it expires
+func (s *SessionStore) DenyToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.redisClient.Set(ctx, DeniedTokenPrefix+tokenID, "revoked", ttl).Err()
+}
+
+// IsTokenDenied reports whether an access token or its session was revoked
+func (s *SessionStore) IsTokenDenied(ctx context.Context, tokenID, sessionID string) (bool, error) {
+	var keys []string
+	if tokenID != "" {
+		keys = append(keys, DeniedTokenPrefix+tokenID)
+	}
+	if sessionID != "" {
+		keys = append(keys, DeniedSessionPrefix+sessionID)
+	}
+	if len(keys) == 0 {
+		return false, nil
+	}
+
+	count, err := s.redisClient.Exists(ctx, keys...).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+const (
+	MergeTokenPrefix = "merge:token:"
+
+	// Account merges must be confirmed within 10 minutes
+	MergeTokenExpiration = 10 * time.Minute
+)
+
+var ErrMergeTokenInvalid = errors.New("invalid or expired merge token")
+
+// MergeRequest is a pending merge of one account into another, created once
+// the user has proven they can sign in to both
+type MergeRequest struct {
+	TargetUserID uuid.UUID `json:"target_user_id"` // the account that is kept
+	SourceUserID uuid.UUID `json:"source_user_id"` // the account merged into it and deleted
+}
+
+// CreateMergeRequest stores a pending merge and returns the one-time token
+// that confirms it
+func (s *SessionStore) CreateMergeRequest(ctx context.Context, req *MergeRequest) (string, error) {
+	token, err := GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.redisClient.Set(ctx, MergeTokenPrefix+token, data, MergeTokenExpiration).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeMergeRequest returns the pending merge of a token and invalidates it
+func (s *SessionStore) ConsumeMergeRequest(ctx context.Context, token string) (*MergeRequest, error) {
+	data, err := s.redisClient.GetDel(ctx, MergeTokenPrefix+token).Bytes()
+	if err == redis.Nil {
+		return nil, ErrMergeTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var req MergeRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (s *SessionStore) saveSession(ctx context.Context, session *Session, ttl time.Duration) error {
+	stored := *session
+	stored.Current = false
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return s.StoreSession(ctx, session.ID, data, ttl)
+}
+
+// newRefreshToken returns an opaque refresh token and the hash it is stored
+// under, so a Redis dump does not leak usable tokens
+func newRefreshToken() (string, string, error) {
+	token, err := GenerateRandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// DetectDevice classifies a client as web, ios or android. Apps can name
+// their platform in the X-Client-Platform header; otherwise the user agent
+// decides.
+func DetectDevice(platform, userAgent string) string {
+	switch strings.ToLower(platform) {
+	case "ios", "android", "web":
+		return strings.ToLower(platform)
+	}
+
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ios"), strings.Contains(ua, "cfnetwork"):
+		return "ios"
+	case strings.Contains(ua, "android"), strings.Contains(ua, "okhttp"):
+		return "android"
+	default:
+		return "web"
+	}
+}
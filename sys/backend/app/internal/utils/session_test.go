@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestSessionStore(t *testing.T) (*SessionStore, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	return NewSessionStore(redis.NewClient(&redis.Options{Addr: server.Addr()})), server
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	store, _ := newTestSessionStore(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	session := &Session{UserID: userID, Device: "web", Provider: "google"}
+	first, err := store.CreateRefreshSession(ctx, session, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateRefreshSession: %v", err)
+	}
+
+	rotated, second, err := store.RotateRefreshToken(ctx, first, "203.0.113.7", time.Hour, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if rotated.ID != session.ID || rotated.IPAddress != "203.0.113.7" {
+		t.Errorf("rotated session = %+v", rotated)
+	}
+	if second == first {
+		t.Error("rotation returned the same refresh token")
+	}
+
+	if _, third, err := store.RotateRefreshToken(ctx, second, "", time.Hour, 15*time.Minute); err != nil || third == "" {
+		t.Errorf("rotating the current token: err = %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	store, _ := newTestSessionStore(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	session := &Session{UserID: userID}
+	first, _ := store.CreateRefreshSession(ctx, session, time.Hour)
+	_, second, err := store.RotateRefreshToken(ctx, first, "", time.Hour, 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The old token is presented again: one of its holders stole it
+	if _, _, err := store.RotateRefreshToken(ctx, first, "", time.Hour, 15*time.Minute); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reusing a rotated token: err = %v, want ErrRefreshTokenReused", err)
+	}
+
+	// The whole family is gone, including the token the other holder got
+	if _, _, err := store.RotateRefreshToken(ctx, second, "", time.Hour, 15*time.Minute); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("token of a revoked family: err = %v, want ErrRefreshTokenInvalid", err)
+	}
+	if denied, _ := store.IsTokenDenied(ctx, "", session.ID); !denied {
+		t.Error("access tokens of the revoked session are still accepted")
+	}
+	if sessions, _ := store.ListUserSessions(ctx, userID); len(sessions) != 0 {
+		t.Errorf("user still has %d sessions", len(sessions))
+	}
+}
+
+func TestRotateRefreshTokenRejectsUnknownTokens(t *testing.T) {
+	store, server := newTestSessionStore(t)
+	ctx := context.Background()
+
+	if _, _, err := store.RotateRefreshToken(ctx, "made-up", "", time.Hour, time.Minute); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("unknown token: err = %v, want ErrRefreshTokenInvalid", err)
+	}
+
+	token, _ := store.CreateRefreshSession(ctx, &Session{UserID: uuid.New()}, time.Hour)
+	server.FastForward(time.Hour + time.Second)
+	if _, _, err := store.RotateRefreshToken(ctx, token, "", time.Hour, time.Minute); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("expired token: err = %v, want ErrRefreshTokenInvalid", err)
+	}
+}
+
+func TestRevokeAllSessionsKeepsCurrent(t *testing.T) {
+	store, _ := newTestSessionStore(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	current := &Session{UserID: userID}
+	store.CreateRefreshSession(ctx, current, time.Hour)
+	other := &Session{UserID: userID}
+	otherToken, _ := store.CreateRefreshSession(ctx, other, time.Hour)
+
+	revoked, err := store.RevokeAllSessions(ctx, userID, current.ID, time.Minute)
+	if err != nil || revoked != 1 {
+		t.Fatalf("RevokeAllSessions = %d, %v; want 1", revoked, err)
+	}
+	if _, _, err := store.RotateRefreshToken(ctx, otherToken, "", time.Hour, time.Minute); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("refresh token of a revoked session: err = %v", err)
+	}
+	if denied, _ := store.IsTokenDenied(ctx, "", current.ID); denied {
+		t.Error("the kept session was revoked")
+	}
+}
+
+func TestDenyToken(t *testing.T) {
+	store, server := newTestSessionStore(t)
+	ctx := context.Background()
+
+	if err := store.DenyToken(ctx, "jti-1", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if denied, _ := store.IsTokenDenied(ctx, "jti-1", "session-1"); !denied {
+		t.Error("denied token was accepted")
+	}
+	if denied, _ := store.IsTokenDenied(ctx, "jti-2", "session-1"); denied {
+		t.Error("another token was denied")
+	}
+
+	// The entry lasts only as long as the token would have
+	server.FastForward(time.Minute + time.Second)
+	if denied, _ := store.IsTokenDenied(ctx, "jti-1", ""); denied {
+		t.Error("denylist entry outlived the token")
+	}
+
+	// Tokens that already expired need no entry
+	store.DenyToken(ctx, "jti-3", time.Now().Add(-time.Second))
+	if server.Exists(DeniedTokenPrefix + "jti-3") {
+		t.Error("expired token was added to the denylist")
+	}
+}
@@ -0,0 +1,14 @@
+package utils
+
+// TruncateRunes shortens s to at most max characters without splitting a
+// multi-byte UTF-8 character, matching how PostgreSQL measures varchar(n)
+func TruncateRunes(s string, max int) string {
+	count := 0
+	for i := range s {
+		if count == max {
+			return s[:i]
+		}
+		count++
+	}
+	return s
+}
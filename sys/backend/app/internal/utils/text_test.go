@@ -0,0 +1,24 @@
+package utils
+
+import "testing"
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"truncated", 5, "trunc"},
+		{"日本語のタイトル", 3, "日本語"},
+		{"café au lait", 4, "café"},
+		{"", 3, ""},
+	}
+
+	for _, tt := range tests {
+		if got := TruncateRunes(tt.in, tt.max); got != tt.want {
+			t.Errorf("TruncateRunes(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+		}
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 
 	"github.com/chatshare/backend/internal/config"
 	"github.com/chatshare/backend/internal/database"
 	"github.com/chatshare/backend/internal/firebase"
+	"github.com/chatshare/backend/internal/linkcheck"
+	"github.com/chatshare/backend/internal/mailer"
 	"github.com/chatshare/backend/internal/redis"
 	"github.com/chatshare/backend/internal/router"
+	"github.com/chatshare/backend/internal/transcript"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
@@ -56,8 +60,28 @@ func main() {
 		log.Println("Firebase credentials path not provided, running without Firebase integration")
 	}
 
+	// Start background link health checks
+	if cfg.LinkCheckEnabled {
+		linkcheck.NewChecker(db, cfg).Start(context.Background())
+		log.Printf("Link health checker started (every %s)", cfg.LinkCheckInterval)
+	}
+
+	// Start background transcript imports
+	importer := transcript.NewImporter(db, cfg)
+	if cfg.TranscriptImportEnabled {
+		importer.Start(context.Background())
+		log.Printf("Transcript importer started (every %s)", cfg.TranscriptImportInterval)
+	}
+
+	// Email delivery (logs instead of sending without a SendGrid API key)
+	emails := mailer.NewSender(db, cfg, mailer.New(cfg))
+	if cfg.EmailDigestEnabled {
+		emails.Start(context.Background())
+		log.Printf("Weekly digest emails enabled (checking every %s)", cfg.EmailDigestInterval)
+	}
+
 	// Initialize router
-	r := router.SetupRouter(cfg, db, redisClient, firebaseService)
+	r := router.SetupRouter(cfg, db, redisClient, firebaseService, importer, emails)
 
 	// Start server
 	port := os.Getenv("PORT")